@@ -10,20 +10,25 @@ import (
 	"c-z.dev/go-micro/api/resolver"
 	"c-z.dev/go-micro/api/server"
 	"c-z.dev/go-micro/auth"
+	"c-z.dev/go-micro/client"
+	"c-z.dev/go-micro/errors"
 	"c-z.dev/go-micro/logger"
 	"c-z.dev/go-micro/util/ctx"
 	inauth "c-z.dev/micro/internal/auth"
 	"c-z.dev/micro/internal/namespace"
+	pb "c-z.dev/micro/service/auth/proto"
 )
 
 // Wrapper wraps a handler and authenticates requests
 func Wrapper(r resolver.Resolver, nr *namespace.Resolver) server.Wrapper {
+	keys := inauth.NewAPIKeys(pb.NewKeysService("go.micro.auth", client.DefaultClient))
 	return func(h http.Handler) http.Handler {
 		return authWrapper{
 			handler:    h,
 			resolver:   r,
 			nsResolver: nr,
-			auth:       auth.DefaultAuth,
+			auth:       inauth.Verifier(auth.DefaultAuth),
+			keys:       keys,
 		}
 	}
 }
@@ -31,6 +36,7 @@ func Wrapper(r resolver.Resolver, nr *namespace.Resolver) server.Wrapper {
 type authWrapper struct {
 	handler    http.Handler
 	auth       auth.Auth
+	keys       *inauth.APIKeys
 	resolver   resolver.Resolver
 	nsResolver *namespace.Resolver
 }
@@ -53,6 +59,18 @@ func (a authWrapper) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		if strings.HasPrefix(header, auth.BearerScheme) {
 			token = header[len(auth.BearerScheme):]
 		}
+		// API keys are swapped for an access token which is passed on in their place
+		if strings.HasPrefix(header, inauth.APIKeyScheme) {
+			tok, err := a.keys.Token(ns, header[len(inauth.APIKeyScheme):], req.RemoteAddr)
+			if err != nil {
+				verr := err.(*errors.Error)
+				http.Error(w, verr.Detail, int(verr.Code))
+				return
+			}
+			token = tok
+			req.Header.Set("Authorization", auth.BearerScheme+token)
+			req = req.WithContext(ctx.FromRequest(req))
+		}
 	} else {
 		// Get the token out the cookies if not provided in headers
 		if c, err := req.Cookie("micro-token"); err == nil && c != nil {
@@ -95,11 +113,20 @@ func (a authWrapper) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	// determine the resource path. there is an inconsistency in how resolvers
 	// use method, some use it as Users.ReadUser (the rpc method), and others
 	// use it as the HTTP method, e.g GET. TODO: Refactor this to make it consistent.
-	resEndpoint := endpoint.Path
+	// Paths are prefixed with the HTTP method so rules can be limited to methods,
+	// e.g. GET /foo/bar
+	resEndpoint := req.Method + " " + endpoint.Path
 	if len(endpoint.Path) == 0 {
 		resEndpoint = endpoint.Method
 	}
 
+	// The login page is served to anyone, otherwise callers without an account would be
+	// redirected to it forever
+	if isLoginPage(a.auth.Options().LoginURL, req) {
+		a.handler.ServeHTTP(w, req)
+		return
+	}
+
 	// Perform the verification check to see if the account has access to
 	// the resource they're requesting
 	res := &auth.Resource{Type: "service", Name: resName, Endpoint: resEndpoint}
@@ -128,3 +155,15 @@ func (a authWrapper) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	loginWithRedirect := fmt.Sprintf("%v?%v", loginURL, params.Encode())
 	http.Redirect(w, req, loginWithRedirect, http.StatusTemporaryRedirect)
 }
+
+// isLoginPage returns true if the request is for the page at the login URL
+func isLoginPage(loginURL string, req *http.Request) bool {
+	if len(loginURL) == 0 {
+		return false
+	}
+	u, err := url.Parse(loginURL)
+	if err != nil || (len(u.Host) > 0 && u.Host != req.Host) {
+		return false
+	}
+	return len(u.Path) > 0 && u.Path == req.URL.Path
+}
@@ -311,6 +311,15 @@ func StoreCommands() []*cli.Command {
 					Aliases: []string{"o"},
 					Usage:   "list offset",
 				},
+				&cli.StringFlag{
+					Name:  "suffix",
+					Usage: "only list keys with this suffix",
+				},
+				&cli.UintFlag{
+					Name:  "page-size",
+					Usage: "number of keys to fetch from the store at once",
+					Value: 1000,
+				},
 			},
 		},
 		{
@@ -337,6 +346,19 @@ func StoreCommands() []*cli.Command {
 					Usage:   "table to write to",
 					Value:   "micro",
 				},
+				&cli.Uint64Flag{
+					Name:  "if-version",
+					Usage: "only write the record if it is at this version",
+				},
+				&cli.BoolFlag{
+					Name:  "if-not-exists",
+					Usage: "only write the record if it doesn't exist",
+				},
+				&cli.StringFlag{
+					Name:  "store",
+					Usage: "store service to call for conditional writes",
+					Value: "go.micro.store",
+				},
 			},
 		},
 		{
@@ -387,6 +409,180 @@ func StoreCommands() []*cli.Command {
 				},
 			},
 		},
+		{
+			Name:   "usage",
+			Usage:  "Show the number of keys and bytes in every table along with any quotas",
+			Action: storecli.Usage,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "store",
+					Usage: "store service to call",
+					Value: "go.micro.store",
+				},
+				&cli.StringFlag{
+					Name:    "database",
+					Aliases: []string{"d"},
+					Usage:   "database to show the usage of, every database if not set",
+				},
+			},
+		},
+		{
+			Name:      "quota",
+			Usage:     "Set the quota of a database in place of the default quota, sizes can be given as e.g. 10MB",
+			UsageText: `micro store quota [options]`,
+			Action:    storecli.SetQuota,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "store",
+					Usage: "store service to call",
+					Value: "go.micro.store",
+				},
+				&cli.StringFlag{
+					Name:    "database",
+					Aliases: []string{"d"},
+					Usage:   "database to set the quota of",
+				},
+				&cli.Uint64Flag{
+					Name:  "keys",
+					Usage: "number of keys across every table, unlimited if not set",
+				},
+				&cli.StringFlag{
+					Name:  "bytes",
+					Usage: "size of every key and value, unlimited if not set",
+				},
+				&cli.StringFlag{
+					Name:  "value-size",
+					Usage: "size of the largest value which can be written, unlimited if not set",
+				},
+				&cli.BoolFlag{
+					Name:  "remove",
+					Usage: "remove the quota of the database so the default quota applies",
+				},
+			},
+		},
+		{
+			Name:   "rotate-key",
+			Usage:  "Add a new data key to a database and rewrite its values with it in the background",
+			Action: storecli.RotateKey,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "store",
+					Usage: "store service to call",
+					Value: "go.micro.store",
+				},
+				&cli.StringFlag{
+					Name:    "database",
+					Aliases: []string{"d"},
+					Usage:   "database to rotate the data key of, defaults to your namespace",
+				},
+			},
+		},
+		{
+			Name:  "index",
+			Usage: "Manage indexes on fields of JSON values",
+			Subcommands: []*cli.Command{
+				{
+					Name:      "create",
+					Usage:     "Index a field of the values in a table, nested fields are separated by dots",
+					UsageText: `micro store index create [options] field`,
+					Action:    storecli.IndexCreate,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "store",
+							Usage: "store service to call",
+							Value: "go.micro.store",
+						},
+						&cli.StringFlag{
+							Name:    "database",
+							Aliases: []string{"d"},
+							Usage:   "database of the index, defaults to your namespace",
+						},
+						&cli.StringFlag{
+							Name:    "table",
+							Aliases: []string{"t"},
+							Usage:   "table of the index",
+							Value:   "micro",
+						},
+					},
+				},
+				{
+					Name:   "list",
+					Usage:  "List the indexes of a database",
+					Action: storecli.IndexList,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "store",
+							Usage: "store service to call",
+							Value: "go.micro.store",
+						},
+						&cli.StringFlag{
+							Name:    "database",
+							Aliases: []string{"d"},
+							Usage:   "database of the index, defaults to your namespace",
+						},
+						&cli.StringFlag{
+							Name:    "table",
+							Aliases: []string{"t"},
+							Usage:   "table of the index, every table if not set",
+						},
+					},
+				},
+				{
+					Name:      "rebuild",
+					Usage:     "Index every record of a table again",
+					UsageText: `micro store index rebuild [options] field`,
+					Action:    storecli.IndexRebuild,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "store",
+							Usage: "store service to call",
+							Value: "go.micro.store",
+						},
+						&cli.StringFlag{
+							Name:    "database",
+							Aliases: []string{"d"},
+							Usage:   "database of the index, defaults to your namespace",
+						},
+						&cli.StringFlag{
+							Name:    "table",
+							Aliases: []string{"t"},
+							Usage:   "table of the index",
+							Value:   "micro",
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:      "watch",
+			Usage:     "Print changes to the keys of a table as they happen",
+			UsageText: `micro store watch [options] [prefix]`,
+			Action:    storecli.Watch,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "store",
+					Usage: "store service to call",
+					Value: "go.micro.store",
+				},
+				&cli.StringFlag{
+					Name:    "database",
+					Aliases: []string{"d"},
+					Usage:   "database to watch",
+					Value:   "micro",
+				},
+				&cli.StringFlag{
+					Name:    "table",
+					Aliases: []string{"t"},
+					Usage:   "table to watch",
+					Value:   "micro",
+				},
+				&cli.StringFlag{
+					Name:    "output",
+					Aliases: []string{"o"},
+					Usage:   "output format (json)",
+				},
+			},
+		},
 		{
 			Name:   "snapshot",
 			Usage:  "Back up a store",
@@ -394,10 +590,28 @@ func StoreCommands() []*cli.Command {
 			Flags: append(storecli.CommonFlags,
 				&cli.StringFlag{
 					Name:    "destination",
-					Usage:   "Backup destination",
+					Usage:   "Backup destination URL, e.g. file:///path, store://backend/database/table or https://host/path",
 					Value:   "file:///tmp/store-snapshot",
 					EnvVars: []string{"MICRO_SNAPSHOT_DESTINATION"},
 				},
+				&cli.StringFlag{
+					Name:    "compression",
+					Usage:   "Compression of the backup: none, gzip or zstd",
+					Value:   "none",
+					EnvVars: []string{"MICRO_SNAPSHOT_COMPRESSION"},
+				},
+				&cli.BoolFlag{
+					Name:  "all",
+					Usage: "Back up every database and table known to the store service",
+				},
+				&cli.BoolFlag{
+					Name:  "chain",
+					Usage: "Store the backup under a new snapshot ID in the destination and mark it as the latest",
+				},
+				&cli.BoolFlag{
+					Name:  "incremental",
+					Usage: "Only back up the keys added, changed or deleted since the latest snapshot in the destination, implies --chain",
+				},
 			),
 		},
 		{
@@ -413,11 +627,102 @@ func StoreCommands() []*cli.Command {
 			Flags: append(storecli.CommonFlags,
 				&cli.StringFlag{
 					Name:  "source",
-					Usage: "Backup source",
+					Usage: "Backup source URL, records are restored to the database and table they were backed up from",
 					Value: "file:///tmp/store-snapshot",
 				},
+				&cli.StringFlag{
+					Name:  "at",
+					Usage: "Restore the snapshot with the given ID, or latest, by replaying the chain of snapshots in the source",
+				},
+				&cli.BoolFlag{
+					Name:  "keep-expired",
+					Usage: "Restore records which expired since the backup was taken, without an expiry",
+				},
 			),
 		},
+		{
+			Name:      "export",
+			Usage:     "Write every record of a table as JSON Lines or CSV, a page of keys at a time in key order",
+			UsageText: `micro store export [options]`,
+			Action:    storecli.Export,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "database",
+					Aliases: []string{"d"},
+					Usage:   "database to export",
+					Value:   "micro",
+				},
+				&cli.StringFlag{
+					Name:    "table",
+					Aliases: []string{"t"},
+					Usage:   "table to export",
+					Value:   "micro",
+				},
+				&cli.StringFlag{
+					Name:    "output",
+					Aliases: []string{"o"},
+					Usage:   "file to write to, - writes to stdout",
+					Value:   "-",
+				},
+				&cli.StringFlag{
+					Name:  "format",
+					Usage: "jsonl or csv, by default csv if the output ends in .csv",
+				},
+				&cli.StringFlag{
+					Name:  "encoding",
+					Usage: "encoding of the values, utf8 or base64. Values which aren't valid UTF-8 are always base64 encoded",
+					Value: "utf8",
+				},
+				&cli.StringFlag{
+					Name:  "prefix",
+					Usage: "only export the keys with this prefix",
+				},
+				&cli.UintFlag{
+					Name:  "page-size",
+					Usage: "number of keys listed at once",
+					Value: 1000,
+				},
+			},
+		},
+		{
+			Name:  "import",
+			Usage: "Apply a file of writes and deletes in batches which are applied all or nothing",
+			UsageText: `micro store import [options] file
+
+The file is JSON Lines or CSV as written by micro store export. Every line writes a record,
+or deletes one with {"op": "delete", "key": "k"} or an op column. Values are utf8 unless
+encoding is base64, expiry is a duration and expires_at a time. - reads from stdin.
+The whole file is checked before the first batch is applied.`,
+			Action: storecli.Import,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "store",
+					Usage: "store service to call",
+					Value: "go.micro.store",
+				},
+				&cli.StringFlag{
+					Name:    "database",
+					Aliases: []string{"d"},
+					Usage:   "database to import to",
+					Value:   "micro",
+				},
+				&cli.StringFlag{
+					Name:    "table",
+					Aliases: []string{"t"},
+					Usage:   "table to import to",
+					Value:   "micro",
+				},
+				&cli.StringFlag{
+					Name:  "format",
+					Usage: "format of the file, jsonl or csv, by default csv if the file name ends in .csv",
+				},
+				&cli.IntFlag{
+					Name:  "batch-size",
+					Usage: "number of operations applied at once, up to 10000",
+					Value: 1000,
+				},
+			},
+		},
 	}
 }
 
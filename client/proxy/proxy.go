@@ -24,7 +24,9 @@ import (
 	sgrpc "c-z.dev/go-micro/server/grpc"
 	"c-z.dev/go-micro/util/mux"
 	"c-z.dev/go-micro/util/wrapper"
+	inauth "c-z.dev/micro/internal/auth"
 	"c-z.dev/micro/internal/helper"
+	pb "c-z.dev/micro/service/auth/proto"
 
 	"github.com/urfave/cli/v2"
 )
@@ -147,9 +149,14 @@ func Run(ctx *cli.Context, srvOpts ...micro.Option) {
 
 	a := *cmd.DefaultOptions().Auth
 	a.Init(authOpts...)
-	authFn := func() auth.Auth { return a }
+	// verify with the rules of micro rather than go-micro, so glob rules are enforced
+	v := inauth.Verifier(a)
+	authFn := func() auth.Auth { return v }
+	// API keys are swapped for tokens before the auth wrapper, which only accepts tokens
+	keys := inauth.NewAPIKeys(pb.NewKeysService("go.micro.auth", service.Client()))
+	keysOpt := server.WrapHandler(keys.HandlerWrapper())
 	authOpt := server.WrapHandler(wrapper.AuthHandler(authFn))
-	serverOpts = append(serverOpts, authOpt)
+	serverOpts = append(serverOpts, keysOpt, authOpt)
 
 	// set proxy
 	switch Protocol {
@@ -0,0 +1,365 @@
+// Package oidc logs users in to the web dashboard with an OpenID Connect provider. It's a
+// relying party using the authorization code flow with PKCE. The account of a user is
+// provisioned with the auth service on their first login and updated on every login after,
+// with scopes mapped from the claims of their ID token. The auth service issues the tokens
+// of these accounts, they have no secret to get one with themselves.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"c-z.dev/go-micro/auth"
+	"c-z.dev/go-micro/errors"
+	"c-z.dev/go-micro/metadata"
+	inauth "c-z.dev/micro/internal/auth"
+	"c-z.dev/micro/internal/namespace"
+	pb "c-z.dev/micro/service/auth/proto"
+)
+
+const (
+	// LoginPath starts a login, the user is sent back to the path in the redirect_to query
+	// parameter once logged in
+	LoginPath = "/oidc/login"
+	// CallbackPath is where the provider redirects back to, it must be registered with it
+	CallbackPath = "/oidc/callback"
+
+	// stateCookieName keeps the state of a login between LoginPath and CallbackPath
+	stateCookieName = "micro-oidc"
+	// loginTimeout is how long a user has to login with the provider
+	loginTimeout = time.Minute * 10
+	// provider is set on the accounts provisioned for users
+	provider = "oidc"
+	// tokenExpiry is how long the token of a logged in user lasts
+	tokenExpiry = time.Hour * 24
+)
+
+// Config of the relying party
+type Config struct {
+	// Issuer is the URL of the provider, its configuration is discovered from it
+	Issuer string
+	// ClientID and ClientSecret are the credentials of the dashboard with the provider
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the absolute URL of CallbackPath registered with the provider, it's
+	// derived from the request when empty
+	RedirectURL string
+	// Scopes are the OAuth2 scopes requested as well as openid, e.g. email or groups
+	Scopes []string
+	// ClaimScopes give accounts scopes when their ID token has a claim. When there are
+	// any, users without a matching claim can't login.
+	ClaimScopes []ClaimScope
+	// APIKey is the key of an account with the admin scope, accounts are provisioned and
+	// their tokens issued with it. Without one the calls are made as the dashboard.
+	APIKey string
+	// HTTPClient calls the provider, http.DefaultClient is used when nil
+	HTTPClient *http.Client
+}
+
+// ClaimScope gives the accounts whose ID token has a claim with a value a scope
+type ClaimScope struct {
+	Claim string
+	// Value is matched against the claim, or any of its values if it's a list. A * matches
+	// any value.
+	Value string
+	Scope string
+}
+
+// ParseClaimScope parses a mapping in the format claim=value:scope, e.g. groups=admins:admin
+func ParseClaimScope(s string) (ClaimScope, error) {
+	eq := strings.Index(s, "=")
+	colon := strings.LastIndex(s, ":")
+	if eq <= 0 || colon < eq || colon == len(s)-1 {
+		return ClaimScope{}, fmt.Errorf("invalid claim mapping %q, must be in the format claim=value:scope", s)
+	}
+	return ClaimScope{Claim: s[:eq], Value: s[eq+1 : colon], Scope: s[colon+1:]}, nil
+}
+
+// matches returns true if the claims include the mapping's claim value
+func (c ClaimScope) matches(claims map[string]interface{}) bool {
+	var values []interface{}
+	switch v := claims[c.Claim].(type) {
+	case nil:
+		return false
+	case []interface{}:
+		values = v
+	default:
+		values = []interface{}{v}
+	}
+	for _, v := range values {
+		if c.Value == "*" || fmt.Sprint(v) == c.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// RelyingParty serves the login flow
+type RelyingParty struct {
+	config   Config
+	auth     pb.AuthService
+	accounts pb.AccountsService
+	apiKeys  *inauth.APIKeys
+
+	// the provider's configuration and keys are discovered on first use
+	sync.Mutex
+	provider *providerConfig
+	keys     map[string]*jsonWebKey
+}
+
+// New returns a relying party for a provider, provisioning accounts with the auth service.
+// The API key of the config is exchanged for a token with apiKeys.
+func New(config Config, auth pb.AuthService, accounts pb.AccountsService, apiKeys *inauth.APIKeys) *RelyingParty {
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+	return &RelyingParty{config: config, auth: auth, accounts: accounts, apiKeys: apiKeys}
+}
+
+// loginState is kept in a cookie while the user logs in with the provider
+type loginState struct {
+	State      string `json:"state"`
+	Nonce      string `json:"nonce"`
+	Verifier   string `json:"verifier"`
+	RedirectTo string `json:"redirect_to"`
+}
+
+// LoginHandler redirects the user to the provider to login
+func (rp *RelyingParty) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	provider, err := rp.discover(r.Context())
+	if err != nil {
+		http.Error(w, "Error discovering the identity provider: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	ls := &loginState{
+		State:      randomString(),
+		Nonce:      randomString(),
+		Verifier:   randomString(),
+		RedirectTo: SafeRedirect(r.URL.Query().Get("redirect_to")),
+	}
+	b, _ := json.Marshal(ls)
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookieName,
+		Value:    base64.RawURLEncoding.EncodeToString(b),
+		Path:     CallbackPath,
+		MaxAge:   int(loginTimeout.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	challenge := sha256.Sum256([]byte(ls.Verifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {rp.config.ClientID},
+		"redirect_uri":          {rp.redirectURL(r)},
+		"scope":                 {strings.Join(append([]string{"openid"}, rp.config.Scopes...), " ")},
+		"state":                 {ls.State},
+		"nonce":                 {ls.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	http.Redirect(w, r, provider.AuthorizationEndpoint+"?"+params.Encode(), http.StatusFound)
+}
+
+// CallbackHandler completes the login, provisioning the account of a user on their first
+// login and setting their token cookie
+func (rp *RelyingParty) CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	c, err := r.Cookie(stateCookieName)
+	if err != nil {
+		http.Error(w, "Login expired, please try again", http.StatusBadRequest)
+		return
+	}
+	// the state is only good for one attempt
+	http.SetCookie(w, &http.Cookie{Name: stateCookieName, Path: CallbackPath, MaxAge: -1})
+
+	var ls loginState
+	b, err := base64.RawURLEncoding.DecodeString(c.Value)
+	if err != nil || json.Unmarshal(b, &ls) != nil {
+		http.Error(w, "Login expired, please try again", http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	if len(ls.State) == 0 || q.Get("state") != ls.State {
+		http.Error(w, "Login state doesn't match, please try again", http.StatusBadRequest)
+		return
+	}
+	if e := q.Get("error"); len(e) > 0 {
+		http.Error(w, "Login failed: "+e+" "+q.Get("error_description"), http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := rp.exchange(r.Context(), q.Get("code"), ls.Verifier, rp.redirectURL(r))
+	if err != nil {
+		http.Error(w, "Login failed: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if claims["nonce"] != ls.Nonce {
+		http.Error(w, "Login failed: nonce doesn't match", http.StatusUnauthorized)
+		return
+	}
+
+	tok, err := rp.login(r.Context(), claims)
+	if err != nil {
+		if verr := parseError(err); verr.Code == http.StatusForbidden {
+			http.Error(w, verr.Detail, http.StatusForbidden)
+			return
+		}
+		http.Error(w, "Login failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     inauth.TokenCookieName,
+		Value:    tok.AccessToken,
+		Path:     "/",
+		Expires:  time.Unix(tok.Expiry, 0),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, ls.RedirectTo, http.StatusFound)
+}
+
+// login gets a token for the account of a user, provisioning it on their first login
+func (rp *RelyingParty) login(ctx context.Context, claims map[string]interface{}) (*pb.Token, error) {
+	sub, _ := claims["sub"].(string)
+	if len(sub) == 0 {
+		return nil, fmt.Errorf("ID token has no subject")
+	}
+	id := accountID(rp.config.Issuer, sub)
+
+	var scopes []string
+	for _, cs := range rp.config.ClaimScopes {
+		if cs.matches(claims) && !contains(scopes, cs.Scope) {
+			scopes = append(scopes, cs.Scope)
+		}
+	}
+	if len(rp.config.ClaimScopes) > 0 && len(scopes) == 0 {
+		return nil, errors.Forbidden("go.micro.web", "Your account isn't allowed to login")
+	}
+
+	ctx, err := rp.adminContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// the account gets a random secret which is never shown, it can't be used to get a token
+	md := accountMetadata(claims, rp.config.Issuer)
+	_, err = rp.auth.Generate(ctx, &pb.GenerateRequest{
+		Id:       id,
+		Type:     "user",
+		Scopes:   scopes,
+		Provider: provider,
+		Metadata: md,
+	})
+	if err != nil && parseError(err).Code == http.StatusBadRequest {
+		// the account exists, keep it in step with the claims
+		_, err = rp.accounts.Update(ctx, &pb.UpdateAccountRequest{
+			Id:       id,
+			Scopes:   scopes,
+			Metadata: md,
+			Provider: provider,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rsp, err := rp.accounts.IssueToken(ctx, &pb.IssueTokenRequest{
+		Id:          id,
+		Provider:    provider,
+		TokenExpiry: int64(tokenExpiry.Seconds()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rsp.Token, nil
+}
+
+// adminContext returns the context to call the auth service with, authorized by the API key
+// of the config if there is one
+func (rp *RelyingParty) adminContext(ctx context.Context) (context.Context, error) {
+	if len(rp.config.APIKey) == 0 {
+		return ctx, nil
+	}
+	tok, err := rp.apiKeys.Token(namespace.FromContext(ctx), rp.config.APIKey, "")
+	if err != nil {
+		// the user can't do anything about the key, it's not a forbidden login
+		return nil, fmt.Errorf("error using the API key: %v", err)
+	}
+	return metadata.Set(ctx, "Authorization", auth.BearerScheme+tok), nil
+}
+
+// redirectURL returns the URL of CallbackPath given to the provider
+func (rp *RelyingParty) redirectURL(r *http.Request) string {
+	if len(rp.config.RedirectURL) > 0 {
+		return rp.config.RedirectURL
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + CallbackPath
+}
+
+// accountID of the account provisioned for a subject. Subjects are only unique for their
+// issuer, so the ID includes a hash of it and another issuer's subjects can't log in to it.
+func accountID(issuer, sub string) string {
+	sum := sha256.Sum256([]byte(issuer))
+	return "oidc-" + hex.EncodeToString(sum[:4]) + "-" + sub
+}
+
+// accountMetadata of a provisioned account, the issuer and the user's email and name if known
+func accountMetadata(claims map[string]interface{}, issuer string) map[string]string {
+	md := map[string]string{"issuer": issuer}
+	for _, k := range []string{"email", "name"} {
+		if v, ok := claims[k].(string); ok && len(v) > 0 {
+			md[k] = v
+		}
+	}
+	return md
+}
+
+// SafeRedirect only allows redirects to paths on the dashboard, anything else is replaced by /
+func SafeRedirect(to string) string {
+	if !strings.HasPrefix(to, "/") || strings.HasPrefix(to, "//") || strings.HasPrefix(to, "/\\") {
+		return "/"
+	}
+	return to
+}
+
+func parseError(err error) *errors.Error {
+	if verr, ok := err.(*errors.Error); ok {
+		return verr
+	}
+	return errors.Parse(err.Error())
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,319 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"c-z.dev/go-micro/auth"
+	"c-z.dev/go-micro/client"
+	"c-z.dev/go-micro/metadata"
+	"c-z.dev/go-micro/store/memory"
+	"c-z.dev/micro/client/web/oidc/oidctest"
+	inauth "c-z.dev/micro/internal/auth"
+	authHandler "c-z.dev/micro/service/auth/handler/auth"
+	pb "c-z.dev/micro/service/auth/proto"
+)
+
+// authClient calls the auth handler directly, with the account of the token the call is
+// authorized with as the auth wrapper of the service would
+type authClient struct {
+	h *authHandler.Auth
+}
+
+func (c *authClient) withAccount(ctx context.Context) context.Context {
+	header, ok := metadata.Get(ctx, "Authorization")
+	if !ok || !strings.HasPrefix(header, auth.BearerScheme) {
+		return ctx
+	}
+	rsp := &pb.InspectResponse{}
+	if err := c.h.Inspect(ctx, &pb.InspectRequest{Token: strings.TrimPrefix(header, auth.BearerScheme)}, rsp); err != nil {
+		return ctx
+	}
+	return auth.ContextWithAccount(ctx, &auth.Account{ID: rsp.Account.Id, Scopes: rsp.Account.Scopes})
+}
+
+func (c *authClient) Generate(ctx context.Context, in *pb.GenerateRequest, opts ...client.CallOption) (*pb.GenerateResponse, error) {
+	rsp := &pb.GenerateResponse{}
+	return rsp, c.h.Generate(c.withAccount(ctx), in, rsp)
+}
+
+func (c *authClient) Inspect(ctx context.Context, in *pb.InspectRequest, opts ...client.CallOption) (*pb.InspectResponse, error) {
+	rsp := &pb.InspectResponse{}
+	return rsp, c.h.Inspect(ctx, in, rsp)
+}
+
+func (c *authClient) Token(ctx context.Context, in *pb.TokenRequest, opts ...client.CallOption) (*pb.TokenResponse, error) {
+	rsp := &pb.TokenResponse{}
+	return rsp, c.h.Token(ctx, in, rsp)
+}
+
+func (c *authClient) Logout(ctx context.Context, in *pb.LogoutRequest, opts ...client.CallOption) (*pb.LogoutResponse, error) {
+	rsp := &pb.LogoutResponse{}
+	return rsp, c.h.Logout(ctx, in, rsp)
+}
+
+// accountsClient only calls the account handlers the relying party needs
+type accountsClient struct {
+	pb.AccountsService
+	*authClient
+}
+
+func (c *accountsClient) Update(ctx context.Context, in *pb.UpdateAccountRequest, opts ...client.CallOption) (*pb.UpdateAccountResponse, error) {
+	rsp := &pb.UpdateAccountResponse{}
+	return rsp, c.h.Update(c.withAccount(ctx), in, rsp)
+}
+
+func (c *accountsClient) IssueToken(ctx context.Context, in *pb.IssueTokenRequest, opts ...client.CallOption) (*pb.IssueTokenResponse, error) {
+	rsp := &pb.IssueTokenResponse{}
+	return rsp, c.h.IssueToken(c.withAccount(ctx), in, rsp)
+}
+
+// keysClient exchanges API keys with the keys handler
+type keysClient struct {
+	pb.KeysService
+	h *authHandler.Keys
+}
+
+func (c *keysClient) Token(ctx context.Context, in *pb.KeyTokenRequest, opts ...client.CallOption) (*pb.KeyTokenResponse, error) {
+	rsp := &pb.KeyTokenResponse{}
+	return rsp, c.h.Token(ctx, in, rsp)
+}
+
+type testSetup struct {
+	issuer *oidctest.Issuer
+	auth   *authClient
+	web    *httptest.Server
+	client *http.Client
+}
+
+func newTestSetup(t *testing.T, mappings ...string) *testSetup {
+	issuer := oidctest.NewIssuer("dashboard", "client-secret")
+	t.Cleanup(issuer.Close)
+
+	h := &authHandler.Auth{}
+	h.Init(auth.Store(memory.NewStore()))
+	ac := &authClient{h: h}
+	keys := &authHandler.Keys{Auth: h}
+
+	// accounts are provisioned with the API key of an admin
+	admin := auth.ContextWithAccount(context.TODO(), &auth.Account{ID: "provisioner", Scopes: []string{"admin"}})
+	if err := h.Generate(admin, &pb.GenerateRequest{Id: "provisioner", Scopes: []string{"admin"}}, &pb.GenerateResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	key := &pb.CreateKeyResponse{}
+	if err := keys.Create(admin, &pb.CreateKeyRequest{Name: "oidc"}, key); err != nil {
+		t.Fatal(err)
+	}
+
+	config := Config{
+		Issuer:       issuer.URL,
+		ClientID:     "dashboard",
+		ClientSecret: "client-secret",
+		Scopes:       []string{"email", "groups"},
+		APIKey:       key.Secret,
+	}
+	for _, m := range mappings {
+		cs, err := ParseClaimScope(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.ClaimScopes = append(config.ClaimScopes, cs)
+	}
+	rp := New(config, ac, &accountsClient{authClient: ac}, inauth.NewAPIKeys(&keysClient{h: keys}))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(LoginPath, rp.LoginHandler)
+	mux.HandleFunc(CallbackPath, rp.CallbackHandler)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("home"))
+	})
+	web := httptest.NewServer(mux)
+	t.Cleanup(web.Close)
+
+	jar, _ := cookiejar.New(nil)
+	return &testSetup{issuer: issuer, auth: ac, web: web, client: &http.Client{Jar: jar}}
+}
+
+// login follows the flow, returning the final response and the account of the token cookie
+func (ts *testSetup) login(t *testing.T, path string) (*http.Response, *pb.Account) {
+	rsp, err := ts.client.Get(ts.web.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+
+	u, _ := url.Parse(ts.web.URL)
+	for _, c := range ts.client.Jar.Cookies(u) {
+		if c.Name != inauth.TokenCookieName {
+			continue
+		}
+		acc, err := ts.auth.Inspect(context.TODO(), &pb.InspectRequest{Token: c.Value})
+		if err != nil {
+			t.Fatalf("Expected the token cookie to be valid, got %v", err)
+		}
+		return rsp, acc.Account
+	}
+	return rsp, nil
+}
+
+func TestLogin(t *testing.T) {
+	ts := newTestSetup(t, "groups=admins:admin", "email_verified=true:user")
+	ts.issuer.Login(map[string]interface{}{
+		"sub":            "1234",
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"groups":         []string{"admins", "devs"},
+	})
+
+	// the account is provisioned on the first login and the user sent where they were going
+	rsp, acc := ts.login(t, LoginPath+"?redirect_to=/services")
+	if rsp.StatusCode != http.StatusOK || rsp.Request.URL.Path != "/services" {
+		t.Fatalf("Expected to be redirected to /services, got %v %v", rsp.Status, rsp.Request.URL)
+	}
+	if acc == nil || acc.Id != accountID(ts.issuer.URL, "1234") {
+		t.Fatalf("Expected a token for the account, got %v", acc)
+	}
+	if len(acc.Scopes) != 2 || acc.Scopes[0] != "admin" || acc.Scopes[1] != "user" {
+		t.Fatalf("Expected the scopes to be mapped from the claims, got %v", acc.Scopes)
+	}
+	if acc.Metadata["email"] != "alice@example.com" {
+		t.Fatalf("Expected the email to be kept, got %v", acc.Metadata)
+	}
+
+	// later logins use the same account, redirects off the dashboard aren't followed
+	ts.client.Jar, _ = cookiejar.New(nil)
+	rsp, acc = ts.login(t, LoginPath+"?redirect_to=//evil.example.com")
+	if rsp.StatusCode != http.StatusOK || rsp.Request.URL.String() != ts.web.URL+"/" {
+		t.Fatalf("Expected to be redirected to /, got %v", rsp.Request.URL)
+	}
+	if acc == nil || acc.Id != accountID(ts.issuer.URL, "1234") {
+		t.Fatalf("Expected a token for the account, got %v", acc)
+	}
+
+	// the account can't get a token without the provider
+	if _, err := ts.auth.Token(context.TODO(), &pb.TokenRequest{Id: accountID(ts.issuer.URL, "1234"), Secret: "secret"}); err == nil {
+		t.Fatal("Expected a provisioned account not to get a token with a secret")
+	}
+
+	// the scopes follow the claims on every login
+	ts.issuer.Login(map[string]interface{}{"sub": "1234", "email": "alice@example.com", "email_verified": true})
+	ts.client.Jar, _ = cookiejar.New(nil)
+	if _, acc = ts.login(t, LoginPath); acc == nil || len(acc.Scopes) != 1 || acc.Scopes[0] != "user" {
+		t.Fatalf("Expected the scopes to be updated from the claims, got %v", acc)
+	}
+}
+
+func TestLoginExistingAccount(t *testing.T) {
+	ts := newTestSetup(t)
+	admin := auth.ContextWithAccount(context.TODO(), &auth.Account{ID: "provisioner", Scopes: []string{"admin"}})
+	req := &pb.GenerateRequest{Id: accountID(ts.issuer.URL, "1234"), Scopes: []string{"admin"}, Secret: "secret"}
+	if _, err := ts.auth.Generate(admin, req); err != nil {
+		t.Fatal(err)
+	}
+
+	// a subject can't take over an account which wasn't provisioned for it
+	ts.issuer.Login(map[string]interface{}{"sub": "1234"})
+	rsp, acc := ts.login(t, LoginPath)
+	if rsp.StatusCode != http.StatusForbidden || acc != nil {
+		t.Fatalf("Expected the login to be forbidden, got %v %v", rsp.Status, acc)
+	}
+
+	// nor can it log in as an account with its bare subject as the ID
+	ts.client.Jar, _ = cookiejar.New(nil)
+	req = &pb.GenerateRequest{Id: "5678", Scopes: []string{"admin"}, Secret: "secret"}
+	if _, err := ts.auth.Generate(admin, req); err != nil {
+		t.Fatal(err)
+	}
+	ts.issuer.Login(map[string]interface{}{"sub": "5678"})
+	if _, acc = ts.login(t, LoginPath); acc == nil || acc.Id != accountID(ts.issuer.URL, "5678") {
+		t.Fatalf("Expected an account of the issuer to be provisioned, got %v", acc)
+	}
+}
+
+func TestLoginNotAllowed(t *testing.T) {
+	ts := newTestSetup(t, "groups=admins:admin")
+	ts.issuer.Login(map[string]interface{}{"sub": "5678", "groups": []string{"devs"}})
+
+	rsp, acc := ts.login(t, LoginPath)
+	if rsp.StatusCode != http.StatusForbidden || acc != nil {
+		t.Fatalf("Expected the login to be forbidden, got %v %v", rsp.Status, acc)
+	}
+}
+
+func TestCallback(t *testing.T) {
+	ts := newTestSetup(t)
+
+	// a callback without a login, or with someone else's state, is refused
+	rsp, err := ts.client.Get(ts.web.URL + CallbackPath + "?code=abc&state=xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected a callback without a login to fail, got %v", rsp.Status)
+	}
+
+	// stop at the provider's redirect back to swap the state
+	ts.client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if req.URL.Path == CallbackPath {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}
+	rsp, err = ts.client.Get(ts.web.URL + LoginPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback, _ := url.Parse(rsp.Header.Get("Location"))
+	q := callback.Query()
+	q.Set("state", "forged")
+	callback.RawQuery = q.Encode()
+
+	rsp, err = ts.client.Get(callback.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected a forged state to fail, got %v", rsp.Status)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ts := newTestSetup(t)
+	rp := New(Config{Issuer: ts.issuer.URL, ClientID: "dashboard"}, ts.auth, nil, nil)
+	provider, err := rp.discover(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{"iss": ts.issuer.URL, "aud": "dashboard", "sub": "1234", "exp": 4102444800}
+	}
+	if _, err := rp.verify(context.TODO(), provider, ts.issuer.Sign(valid())); err != nil {
+		t.Fatalf("Expected the token to be valid, got %v", err)
+	}
+
+	tt := map[string]func(c map[string]interface{}){
+		"Issuer":   func(c map[string]interface{}) { c["iss"] = "https://other.example.com" },
+		"Audience": func(c map[string]interface{}) { c["aud"] = []string{"other"} },
+		"Expired":  func(c map[string]interface{}) { c["exp"] = 946684800 },
+	}
+	for name, modify := range tt {
+		claims := valid()
+		modify(claims)
+		if _, err := rp.verify(context.TODO(), provider, ts.issuer.Sign(claims)); err == nil {
+			t.Errorf("%s: expected the token to be invalid", name)
+		}
+	}
+
+	// tokens signed by another key are refused
+	other := oidctest.NewIssuer("dashboard", "client-secret")
+	defer other.Close()
+	if _, err := rp.verify(context.TODO(), provider, other.Sign(valid())); err == nil {
+		t.Error("Expected a token signed by another key to be invalid")
+	}
+}
@@ -0,0 +1,243 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// providerConfig is the discovered configuration of a provider
+type providerConfig struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// jsonWebKey is an RSA key of a provider
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// discover the configuration of the provider
+func (rp *RelyingParty) discover(ctx context.Context) (*providerConfig, error) {
+	rp.Lock()
+	defer rp.Unlock()
+
+	if rp.provider != nil {
+		return rp.provider, nil
+	}
+
+	var pc providerConfig
+	wellKnown := strings.TrimSuffix(rp.config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := rp.get(ctx, wellKnown, &pc); err != nil {
+		return nil, err
+	}
+	// the issuer must be the one configured, or its tokens would be trusted for another
+	if pc.Issuer != rp.config.Issuer {
+		return nil, fmt.Errorf("provider issuer %s doesn't match %s", pc.Issuer, rp.config.Issuer)
+	}
+	if len(pc.AuthorizationEndpoint) == 0 || len(pc.TokenEndpoint) == 0 || len(pc.JWKSURI) == 0 {
+		return nil, fmt.Errorf("provider configuration is incomplete")
+	}
+	rp.provider = &pc
+	return rp.provider, nil
+}
+
+// key returns the key an ID token is signed with, the keys are fetched again when the
+// token is signed with one which isn't known so providers can rotate them
+func (rp *RelyingParty) key(ctx context.Context, provider *providerConfig, kid string) (*rsa.PublicKey, error) {
+	rp.Lock()
+	jwk, ok := rp.keys[kid]
+	rp.Unlock()
+
+	if !ok {
+		var set struct {
+			Keys []*jsonWebKey `json:"keys"`
+		}
+		if err := rp.get(ctx, provider.JWKSURI, &set); err != nil {
+			return nil, err
+		}
+		keys := make(map[string]*jsonWebKey, len(set.Keys))
+		for _, k := range set.Keys {
+			keys[k.Kid] = k
+		}
+		rp.Lock()
+		rp.keys = keys
+		rp.Unlock()
+
+		if jwk, ok = keys[kid]; !ok {
+			return nil, fmt.Errorf("unknown signing key %s", kid)
+		}
+	}
+
+	if jwk.Kty != "RSA" {
+		return nil, fmt.Errorf("unsupported key type %s", jwk.Kty)
+	}
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %v", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %v", err)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+}
+
+// exchange an authorization code for the claims of the user's verified ID token
+func (rp *RelyingParty) exchange(ctx context.Context, code, verifier, redirectURL string) (map[string]interface{}, error) {
+	provider, err := rp.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(code) == 0 {
+		return nil, fmt.Errorf("no code")
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(rp.config.ClientID), url.QueryEscape(rp.config.ClientSecret))
+
+	var tok struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := rp.do(req, &tok); err != nil {
+		return nil, err
+	}
+	if len(tok.Error) > 0 {
+		return nil, fmt.Errorf("%s %s", tok.Error, tok.ErrorDescription)
+	}
+	if len(tok.IDToken) == 0 {
+		return nil, fmt.Errorf("no ID token")
+	}
+	return rp.verify(ctx, provider, tok.IDToken)
+}
+
+// verify the signature and claims of an ID token, returning its claims. The nonce is left
+// to the caller.
+func (rp *RelyingParty) verify(ctx context.Context, provider *providerConfig, idToken string) (map[string]interface{}, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed ID token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported ID token algorithm %s", header.Alg)
+	}
+	key, err := rp.key(ctx, provider, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed ID token signature")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return nil, fmt.Errorf("invalid ID token signature")
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if claims["iss"] != provider.Issuer {
+		return nil, fmt.Errorf("ID token issuer doesn't match")
+	}
+	if !audience(claims["aud"], rp.config.ClientID) {
+		return nil, fmt.Errorf("ID token audience doesn't match")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok || time.Now().After(time.Unix(int64(exp), 0)) {
+		return nil, fmt.Errorf("ID token expired")
+	}
+	return claims, nil
+}
+
+// audience returns true if the aud claim, a string or a list, includes the client
+func audience(aud interface{}, clientID string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == clientID
+	case []interface{}:
+		for _, v := range a {
+			if v == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return fmt.Errorf("malformed ID token")
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("malformed ID token")
+	}
+	return nil
+}
+
+func (rp *RelyingParty) get(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	return rp.do(req, v)
+}
+
+// do a request to the provider, decoding the json response. Token errors are decoded too.
+func (rp *RelyingParty) do(req *http.Request, v interface{}) error {
+	rsp, err := rp.config.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(rsp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if rsp.StatusCode != http.StatusOK && rsp.StatusCode != http.StatusBadRequest {
+		return fmt.Errorf("%s returned %s", req.URL.Path, rsp.Status)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%s returned invalid json: %v", req.URL.Path, err)
+	}
+	return nil
+}
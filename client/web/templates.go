@@ -504,5 +504,39 @@ pre {padding: 20px;}
 	{{end}}
 {{end}}
 
+`
+
+	loginTemplate = `
+{{define "title"}}Login{{end}}
+{{define "heading"}}<h3>Login</h3>{{end}}
+{{define "style"}}
+	.form-control {
+		border: 1px solid whitesmoke;
+	}
+{{end}}
+{{define "content"}}
+<div class="row">
+	<div class="col-sm-5">
+		{{with .Results}}{{if .Error}}<div class="alert alert-danger">{{.Error}}</div>{{end}}{{end}}
+		<form method="post" action="/login">
+			<input type=hidden name=redirect_to value="{{with .Results}}{{.RedirectTo}}{{end}}">
+			<div class="form-group">
+				<label for="id">ID</label>
+				<input class="form-control" type=text name=id id=id value="{{with .Results}}{{.ID}}{{end}}" {{with .Results}}{{if not .MFA}}autofocus{{end}}{{else}}autofocus{{end}}>
+			</div>
+			<div class="form-group">
+				<label for="secret">Secret</label>
+				<input class="form-control" type=password name=secret id=secret>
+			</div>
+			<div class="form-group">
+				<label for="code">MFA code</label>
+				<input class="form-control" type=text name=code id=code autocomplete="one-time-code" placeholder="Only needed if you've enrolled in MFA" {{with .Results}}{{if .MFA}}autofocus{{end}}{{end}}>
+			</div>
+			<button class="btn btn-default" type="submit">Login</button>
+		</form>
+		{{with .Results}}{{if .OIDC}}<p><a href="{{.OIDC}}">Login with single sign-on</a></p>{{end}}{{end}}
+	</div>
+</div>
+{{end}}
 `
 )
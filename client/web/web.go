@@ -8,6 +8,7 @@ import (
 	"net"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
 	"os"
 	"sort"
 	"strings"
@@ -22,15 +23,19 @@ import (
 	"c-z.dev/go-micro/auth"
 	"c-z.dev/go-micro/client/selector"
 	"c-z.dev/go-micro/config/cmd"
+	"c-z.dev/go-micro/errors"
 	log "c-z.dev/go-micro/logger"
 	"c-z.dev/go-micro/registry"
 	apiAuth "c-z.dev/micro/client/api/auth"
+	"c-z.dev/micro/client/web/oidc"
 	inauth "c-z.dev/micro/internal/auth"
 	"c-z.dev/micro/internal/handler"
 	"c-z.dev/micro/internal/helper"
 	"c-z.dev/micro/internal/namespace"
 	"c-z.dev/micro/internal/resolver/web"
 	"c-z.dev/micro/internal/stats"
+	authHandler "c-z.dev/micro/service/auth/handler/auth"
+	authpb "c-z.dev/micro/service/auth/proto"
 
 	"github.com/gorilla/mux"
 	"github.com/serenize/snaker"
@@ -74,6 +79,10 @@ type srv struct {
 	prx *proxy
 	// auth service
 	auth auth.Auth
+	// authService gets tokens for the login page
+	authService authpb.AuthService
+	// oidc logs users in with an identity provider, nil unless one is configured
+	oidc *oidc.RelyingParty
 }
 
 type reg struct {
@@ -395,6 +404,58 @@ func (s *srv) callHandler(w http.ResponseWriter, r *http.Request) {
 	s.render(w, r, callTemplate, serviceMap)
 }
 
+// loginHandler serves the login page and exchanges the credentials posted to it for a
+// token cookie. Accounts enrolled in MFA are asked for a code if they didn't give one.
+func (s *srv) loginHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error occurred:"+err.Error(), 400)
+		return
+	}
+	// users are sent back to the page which asked them to login
+	redirectTo := oidc.SafeRedirect(r.Form.Get("redirect_to"))
+
+	// link to the identity provider if there is one
+	var oidcURL string
+	if s.oidc != nil {
+		oidcURL = oidc.LoginPath + "?" + url.Values{"redirect_to": {redirectTo}}.Encode()
+	}
+
+	if r.Method != http.MethodPost {
+		s.render(w, r, loginTemplate, map[string]interface{}{"OIDC": oidcURL, "RedirectTo": redirectTo})
+		return
+	}
+
+	id := r.PostForm.Get("id")
+	rsp, err := s.authService.Token(r.Context(), &authpb.TokenRequest{
+		Id:          id,
+		Secret:      r.PostForm.Get("secret"),
+		Code:        r.PostForm.Get("code"),
+		TokenExpiry: int64((time.Hour * 24).Seconds()),
+	})
+	if err != nil {
+		detail := errors.Parse(err.Error()).Detail
+		s.render(w, r, loginTemplate, map[string]interface{}{
+			"ID":         id,
+			"Error":      detail,
+			"MFA":        detail == authHandler.MFARequired,
+			"OIDC":       oidcURL,
+			"RedirectTo": redirectTo,
+		})
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     inauth.TokenCookieName,
+		Value:    rsp.Token.AccessToken,
+		Path:     "/",
+		Expires:  time.Unix(rsp.Token.Expiry, 0),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, redirectTo, http.StatusFound)
+}
+
 func (s *srv) render(w http.ResponseWriter, r *http.Request, tmpl string, data interface{}) {
 	t, err := template.New("template").Funcs(template.FuncMap{
 		"format": format,
@@ -480,7 +541,8 @@ func Run(ctx *cli.Context, srvOpts ...micro.Option) {
 				selector.Registry(reg),
 			),
 		},
-		auth: *cmd.DefaultOptions().Auth,
+		auth:        *cmd.DefaultOptions().Auth,
+		authService: authpb.NewAuthService("go.micro.auth", service.Client()),
 	}
 
 	var h http.Handler
@@ -505,6 +567,30 @@ func Run(ctx *cli.Context, srvOpts ...micro.Option) {
 	s.HandleFunc("/services", s.registryHandler)
 	s.HandleFunc("/service/{name}", s.registryHandler)
 	s.HandleFunc("/rpc", handler.RPC)
+	s.HandleFunc("/login", s.loginHandler)
+	if len(ctx.String("oidc_issuer")) > 0 {
+		config := oidc.Config{
+			Issuer:       ctx.String("oidc_issuer"),
+			ClientID:     ctx.String("oidc_client_id"),
+			ClientSecret: ctx.String("oidc_client_secret"),
+			RedirectURL:  ctx.String("oidc_redirect_url"),
+			Scopes:       ctx.StringSlice("oidc_scopes"),
+			APIKey:       ctx.String("oidc_api_key"),
+		}
+		for _, m := range ctx.StringSlice("oidc_claim_scopes") {
+			cs, err := oidc.ParseClaimScope(m)
+			if err != nil {
+				log.Fatal(err)
+			}
+			config.ClaimScopes = append(config.ClaimScopes, cs)
+		}
+		s.oidc = oidc.New(config, s.authService,
+			authpb.NewAccountsService("go.micro.auth", service.Client()),
+			inauth.NewAPIKeys(authpb.NewKeysService("go.micro.auth", service.Client())),
+		)
+		s.HandleFunc(oidc.LoginPath, s.oidc.LoginHandler)
+		s.HandleFunc(oidc.CallbackPath, s.oidc.CallbackHandler)
+	}
 	s.PathPrefix("/{service:[a-zA-Z0-9]+}").Handler(p)
 	s.HandleFunc("/", s.indexHandler)
 
@@ -582,7 +668,42 @@ func Commands(options ...micro.Option) []*cli.Command {
 			&cli.StringFlag{
 				Name:    "auth_login_url",
 				EnvVars: []string{"MICRO_AUTH_LOGIN_URL"},
-				Usage:   "The relative URL where a user can login",
+				Usage:   "The relative URL where a user can login, e.g. /login for the dashboard's login page or /oidc/login to go straight to the identity provider. It's served without checking the rules",
+			},
+			&cli.StringFlag{
+				Name:    "oidc_issuer",
+				EnvVars: []string{"MICRO_WEB_OIDC_ISSUER"},
+				Usage:   "Login with an OpenID Connect provider, e.g. https://accounts.example.com. The /oidc/ paths need a public rule",
+			},
+			&cli.StringFlag{
+				Name:    "oidc_client_id",
+				EnvVars: []string{"MICRO_WEB_OIDC_CLIENT_ID"},
+				Usage:   "The client ID of the dashboard with the OpenID Connect provider",
+			},
+			&cli.StringFlag{
+				Name:    "oidc_client_secret",
+				EnvVars: []string{"MICRO_WEB_OIDC_CLIENT_SECRET"},
+				Usage:   "The client secret of the dashboard with the OpenID Connect provider",
+			},
+			&cli.StringFlag{
+				Name:    "oidc_redirect_url",
+				EnvVars: []string{"MICRO_WEB_OIDC_REDIRECT_URL"},
+				Usage:   "The URL of /oidc/callback registered with the provider, derived from the request if not set",
+			},
+			&cli.StringSliceFlag{
+				Name:    "oidc_scopes",
+				EnvVars: []string{"MICRO_WEB_OIDC_SCOPES"},
+				Usage:   "OAuth2 scopes to request as well as openid, e.g. email,groups",
+			},
+			&cli.StringSliceFlag{
+				Name:    "oidc_claim_scopes",
+				EnvVars: []string{"MICRO_WEB_OIDC_CLAIM_SCOPES"},
+				Usage:   "Give accounts scopes from the claims of their ID token in the format claim=value:scope, e.g. groups=admins:admin. When set only users with a matching claim can login",
+			},
+			&cli.StringFlag{
+				Name:    "oidc_api_key",
+				EnvVars: []string{"MICRO_WEB_OIDC_API_KEY"},
+				Usage:   "An API key of an account with the admin scope, the accounts of users are provisioned and their tokens issued with it",
 			},
 		},
 	}
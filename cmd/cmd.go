@@ -8,6 +8,7 @@ import (
 	"sort"
 
 	"c-z.dev/go-micro"
+	goauth "c-z.dev/go-micro/auth"
 	"c-z.dev/go-micro/config/cmd"
 	gostore "c-z.dev/go-micro/store"
 	"c-z.dev/micro/server"
@@ -227,6 +228,11 @@ func setup(app *ccli.App) {
 			}
 		}
 
+		// evaluate the rules the same way in every service as at the api and proxy, so the
+		// patterns micro auth verify explains are enforced wherever a request is checked
+		*cmd.DefaultCmd.Options().Auth = inauth.Verifier(*cmd.DefaultCmd.Options().Auth)
+		goauth.DefaultAuth = *cmd.DefaultCmd.Options().Auth
+
 		return nil
 	}
 }
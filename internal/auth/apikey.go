@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"c-z.dev/go-micro/auth"
+	"c-z.dev/go-micro/errors"
+	"c-z.dev/go-micro/metadata"
+	"c-z.dev/go-micro/server"
+	"c-z.dev/micro/internal/namespace"
+	pb "c-z.dev/micro/service/auth/proto"
+)
+
+// APIKeyScheme is the scheme of an Authorization header carrying an API key
+const APIKeyScheme = "ApiKey "
+
+// tokenRefreshMargin is how long before it expires the token of a key is replaced
+const tokenRefreshMargin = time.Minute
+
+// APIKeys exchanges API keys for access tokens with the auth service, so services behind
+// the api and proxy only ever see bearer tokens. Tokens are cached by key and source
+// address until shortly before they expire, so the allowlist and last use of a key are
+// checked every few minutes rather than on every request.
+type APIKeys struct {
+	keys pb.KeysService
+
+	sync.Mutex
+	tokens map[string]*pb.Token
+}
+
+// NewAPIKeys returns an APIKeys exchanging keys with the keys service
+func NewAPIKeys(keys pb.KeysService) *APIKeys {
+	return &APIKeys{keys: keys, tokens: make(map[string]*pb.Token)}
+}
+
+// Token returns an access token for an API key used from a source address, any port of the
+// address is ignored. The error is an *errors.Error with the status to respond with, 401
+// for keys which aren't valid and 403 for those used from an address they're not allowed
+// from.
+func (k *APIKeys) Token(ns, key, source string) (string, error) {
+	if host, _, err := net.SplitHostPort(source); err == nil {
+		source = host
+	}
+	sum := sha256.Sum256([]byte(ns + "|" + key + "|" + source))
+	id := hex.EncodeToString(sum[:])
+
+	k.Lock()
+	tok, ok := k.tokens[id]
+	k.Unlock()
+	if ok && time.Now().Add(tokenRefreshMargin).Before(time.Unix(tok.Expiry, 0)) {
+		return tok.AccessToken, nil
+	}
+
+	// the caller's metadata isn't passed on, its authorization header is the key
+	ctx := namespace.ContextWithNamespace(context.TODO(), ns)
+	rsp, err := k.keys.Token(ctx, &pb.KeyTokenRequest{Key: key, Source: source})
+	if err != nil {
+		verr, ok := err.(*errors.Error)
+		if !ok {
+			verr = errors.Parse(err.Error())
+		}
+		switch {
+		case verr.Code == 403:
+			return "", errors.Forbidden("go.micro.auth", "%s", verr.Detail)
+		case verr.Code >= 400 && verr.Code < 500:
+			return "", errors.Unauthorized("go.micro.auth", "Invalid API key")
+		default:
+			return "", errors.InternalServerError("go.micro.auth", "Error exchanging API key: %v", err)
+		}
+	}
+
+	k.Lock()
+	defer k.Unlock()
+	for i, t := range k.tokens {
+		if time.Now().After(time.Unix(t.Expiry, 0)) {
+			delete(k.tokens, i)
+		}
+	}
+	k.tokens[id] = rsp.Token
+	return rsp.Token.AccessToken, nil
+}
+
+// HandlerWrapper swaps the API key of a call for an access token before it reaches
+// wrapper.AuthHandler, which only accepts bearer tokens. It must wrap the auth handler.
+func (k *APIKeys) HandlerWrapper() server.HandlerWrapper {
+	return func(h server.HandlerFunc) server.HandlerFunc {
+		return func(ctx context.Context, req server.Request, rsp interface{}) error {
+			header, ok := metadata.Get(ctx, "Authorization")
+			if !ok || !strings.HasPrefix(header, APIKeyScheme) {
+				return h(ctx, req, rsp)
+			}
+
+			ns := namespace.FromContext(ctx)
+			if len(ns) == 0 {
+				ns = namespace.DefaultNamespace
+			}
+			remote, _ := metadata.Get(ctx, "Remote")
+			tok, err := k.Token(ns, strings.TrimPrefix(header, APIKeyScheme), remote)
+			if err != nil {
+				verr := err.(*errors.Error)
+				return errors.New(req.Service(), verr.Detail, verr.Code)
+			}
+			return h(metadata.Set(ctx, "Authorization", auth.BearerScheme+tok), req, rsp)
+		}
+	}
+}
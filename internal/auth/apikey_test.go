@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"c-z.dev/go-micro/client"
+	"c-z.dev/go-micro/errors"
+	"c-z.dev/go-micro/metadata"
+	"c-z.dev/go-micro/server"
+	pb "c-z.dev/micro/service/auth/proto"
+)
+
+// testKeys exchanges the key "valid" from 10.0.0.1
+type testKeys struct {
+	pb.KeysService
+	exchanges int
+}
+
+func (k *testKeys) Token(ctx context.Context, in *pb.KeyTokenRequest, opts ...client.CallOption) (*pb.KeyTokenResponse, error) {
+	k.exchanges++
+	if in.Key != "valid" {
+		return nil, errors.Unauthorized("go.micro.auth", "Invalid API key")
+	}
+	if in.Source != "10.0.0.1" {
+		return nil, errors.Forbidden("go.micro.auth", "API key can't be used from %s", in.Source)
+	}
+	return &pb.KeyTokenResponse{Token: &pb.Token{
+		AccessToken: "token",
+		Expiry:      time.Now().Add(time.Minute * 5).Unix(),
+	}}, nil
+}
+
+type testRequest struct {
+	server.Request
+}
+
+func (testRequest) Service() string { return "go.micro.service.foo" }
+
+func TestAPIKeys(t *testing.T) {
+	tk := &testKeys{}
+	keys := NewAPIKeys(tk)
+
+	// tokens are cached by key and address
+	for i := 0; i < 2; i++ {
+		tok, err := keys.Token("go.micro", "valid", "10.0.0.1:1234")
+		if err != nil || tok != "token" {
+			t.Fatalf("Expected a token, got %v %v", tok, err)
+		}
+	}
+	if tk.exchanges != 1 {
+		t.Fatalf("Expected the key to be exchanged once, got %v", tk.exchanges)
+	}
+
+	_, err := keys.Token("go.micro", "valid", "10.0.0.2")
+	if verr, ok := err.(*errors.Error); !ok || verr.Code != 403 {
+		t.Fatalf("Expected the address to be refused, got %v", err)
+	}
+	_, err = keys.Token("go.micro", "invalid", "10.0.0.1")
+	if verr, ok := err.(*errors.Error); !ok || verr.Code != 401 {
+		t.Fatalf("Expected the key to be refused, got %v", err)
+	}
+
+	// the handler wrapper swaps the key for a bearer token
+	var header string
+	h := keys.HandlerWrapper()(func(ctx context.Context, req server.Request, rsp interface{}) error {
+		header, _ = metadata.Get(ctx, "Authorization")
+		return nil
+	})
+	ctx := metadata.NewContext(context.TODO(), metadata.Metadata{
+		"Authorization": APIKeyScheme + "valid",
+		"Remote":        "10.0.0.1:4321",
+	})
+	if err := h(ctx, testRequest{}, nil); err != nil || header != "Bearer token" {
+		t.Fatalf("Expected the key to be swapped for a token, got %q %v", header, err)
+	}
+
+	ctx = metadata.Set(ctx, "Authorization", APIKeyScheme+"invalid")
+	err = h(ctx, testRequest{}, nil)
+	if verr, ok := err.(*errors.Error); !ok || verr.Code != 401 || verr.Id != "go.micro.service.foo" {
+		t.Fatalf("Expected the call to be unauthorized, got %v", err)
+	}
+}
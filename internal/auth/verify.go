@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"sort"
+	"strings"
+
+	"c-z.dev/go-micro/auth"
+)
+
+// Evaluation is the outcome of evaluating the rules for an account accessing a resource
+type Evaluation struct {
+	// Matches are the rules which match the resource, in the order they're evaluated
+	Matches []*auth.Rule
+	// Winner is the first match which applies to the account, it decides the request. A
+	// request is denied when no rule applies.
+	Winner *auth.Rule
+}
+
+// Granted returns true if the account has access to the resource
+func (e *Evaluation) Granted() bool {
+	return e.Winner != nil && e.Winner.Access == auth.AccessGranted
+}
+
+// Evaluate the rules for an account accessing a resource, keeping every rule which matched
+// so the decision can be explained. A nil account is an unauthenticated request.
+//
+// The type, name and endpoint of a rule's resource are glob patterns, see Match. Endpoints
+// of web resources can be limited to HTTP methods, e.g. "GET,HEAD /static/*". The matches
+// are evaluated by priority, highest first, then by specificity, most specific first, with
+// the ID breaking ties so the order never depends on how the rules are stored.
+func Evaluate(rules []*auth.Rule, acc *auth.Account, res *auth.Resource) *Evaluation {
+	eval := &Evaluation{}
+	for _, rule := range rules {
+		if rule.Resource == nil {
+			continue
+		}
+		if !Match(rule.Resource.Type, res.Type) {
+			continue
+		}
+		if !Match(rule.Resource.Name, res.Name) {
+			continue
+		}
+		if !matchEndpoint(rule.Resource.Endpoint, res.Endpoint) {
+			continue
+		}
+		eval.Matches = append(eval.Matches, rule)
+	}
+
+	SortRules(eval.Matches)
+	for _, rule := range eval.Matches {
+		if Applies(rule, acc) {
+			eval.Winner = rule
+			break
+		}
+	}
+	return eval
+}
+
+// Verifier wraps an auth so Verify evaluates its rules with Evaluate, enforcing the same
+// glob patterns, methods and order micro auth verify explains. The micro command installs
+// it as the default auth, so services check requests as the api and proxy do. Wrapping an
+// auth which is already a verifier returns it as is.
+func Verifier(a auth.Auth) auth.Auth {
+	if v, ok := a.(*verifier); ok {
+		return v
+	}
+	return &verifier{a}
+}
+
+type verifier struct {
+	auth.Auth
+}
+
+func (v *verifier) Verify(acc *auth.Account, res *auth.Resource, opts ...auth.VerifyOption) error {
+	var options auth.VerifyOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	var rulesOpts []auth.RulesOption
+	if options.Context != nil {
+		rulesOpts = append(rulesOpts, auth.RulesContext(options.Context))
+	}
+	if len(options.Namespace) > 0 {
+		rulesOpts = append(rulesOpts, auth.RulesNamespace(options.Namespace))
+	}
+	rules, err := v.Auth.Rules(rulesOpts...)
+	if err != nil {
+		return err
+	}
+	if Evaluate(rules, acc, res).Granted() {
+		return nil
+	}
+	return auth.ErrForbidden
+}
+
+// Applies returns true if the scope of a rule covers the account
+func Applies(rule *auth.Rule, acc *auth.Account) bool {
+	// a blank scope indicates the rule applies to everyone, even nil accounts
+	if rule.Scope == auth.ScopePublic {
+		return true
+	}
+	// all further checks require an account
+	if acc == nil {
+		return false
+	}
+	return rule.Scope == auth.ScopeAccount || include(acc.Scopes, rule.Scope)
+}
+
+// SortRules sorts rules into the order they're evaluated in
+func SortRules(rules []*auth.Rule) {
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].Priority != rules[j].Priority {
+			return rules[i].Priority > rules[j].Priority
+		}
+		si, sj := Specificity(rules[i].Resource), Specificity(rules[j].Resource)
+		if si != sj {
+			return si > sj
+		}
+		return rules[i].ID < rules[j].ID
+	})
+}
+
+// Specificity ranks how specific the resource of a rule is, the greater the more specific.
+// The name counts most, then the endpoint, then the type. Within a field a literal beats a
+// pattern and a pattern with more literal characters beats one with fewer, e.g.
+// go.micro.api.billing beats go.micro.api.billing.* which beats go.micro.*. An endpoint
+// limited to HTTP methods beats the same endpoint for any method.
+func Specificity(res *auth.Resource) int64 {
+	if res == nil {
+		return 0
+	}
+	methods, endpoint := splitMethods(res.Endpoint)
+	spec := (patternSpecificity(res.Name)*1000+patternSpecificity(endpoint))*1000 + patternSpecificity(res.Type)
+	spec *= 2
+	if len(methods) > 0 {
+		spec++
+	}
+	return spec
+}
+
+// patternSpecificity scores a pattern from 0 for * to 999 for a long literal
+func patternSpecificity(p string) int64 {
+	if p == "*" {
+		return 0
+	}
+	literals := int64(len(p) - strings.Count(p, "*") - strings.Count(p, "?"))
+	if literals > 498 {
+		literals = 498
+	}
+	if strings.ContainsAny(p, "*?") {
+		return 1 + literals
+	}
+	return 500 + literals
+}
+
+// Match returns true if a value matches a glob pattern. Case matters, services and paths can
+// differ only in case. A * matches any run of characters, including none, and a ? matches any
+// one character. A pattern ending in /* matches the path before it too, so /foo/* matches /foo
+// as well as /foo/bar/baz.
+func Match(pattern, value string) bool {
+	if strings.HasSuffix(pattern, "/*") && value == strings.TrimSuffix(pattern, "/*") {
+		return true
+	}
+
+	// match greedily, backtracking to the last * when a literal doesn't match
+	var p, v int
+	star, next := -1, 0
+	for v < len(value) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == value[v]):
+			p++
+			v++
+		case p < len(pattern) && pattern[p] == '*':
+			star, next = p, v
+			p++
+		case star >= 0:
+			next++
+			p, v = star+1, next
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchEndpoint matches the endpoint of a resource against that of a rule. Web resources
+// have the HTTP method before the path, e.g. "GET /foo", rules without methods match any.
+// Methods are the only part matched ignoring case.
+func matchEndpoint(pattern, endpoint string) bool {
+	methods, pattern := splitMethods(pattern)
+	method, endpoint := splitMethods(endpoint)
+	if len(methods) > 0 && (len(method) != 1 || !include(methods, method[0])) {
+		return false
+	}
+	return Match(pattern, endpoint)
+}
+
+// splitMethods splits the HTTP methods off an endpoint, e.g. "GET,HEAD /foo"
+func splitMethods(endpoint string) ([]string, string) {
+	idx := strings.Index(endpoint, " ")
+	if idx <= 0 {
+		return nil, endpoint
+	}
+	for _, c := range endpoint[:idx] {
+		if (c < 'A' || c > 'Z') && (c < 'a' || c > 'z') && c != ',' {
+			return nil, endpoint
+		}
+	}
+	return strings.Split(endpoint[:idx], ","), strings.TrimSpace(endpoint[idx+1:])
+}
+
+func include(slice []string, val string) bool {
+	for _, s := range slice {
+		if strings.EqualFold(s, val) {
+			return true
+		}
+	}
+	return false
+}
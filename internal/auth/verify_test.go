@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"testing"
+
+	"c-z.dev/go-micro/auth"
+)
+
+func TestEvaluate(t *testing.T) {
+	rules := []*auth.Rule{
+		{ID: "default", Scope: auth.ScopeAccount, Resource: &auth.Resource{Type: "*", Name: "*", Endpoint: "*"}},
+		{ID: "public-foo", Scope: auth.ScopePublic, Resource: &auth.Resource{Type: "service", Name: "go.micro.api.foo", Endpoint: "Foo.Bar"}},
+		{ID: "deny-admin", Scope: "admin", Access: auth.AccessDenied, Priority: 10, Resource: &auth.Resource{Type: "service", Name: "go.micro.api.foo", Endpoint: "*"}},
+		{ID: "web-path", Scope: "user", Resource: &auth.Resource{Type: "service", Name: "go.micro.web.home", Endpoint: "/foo/*"}},
+		{ID: "other", Scope: auth.ScopePublic, Resource: &auth.Resource{Type: "service", Name: "go.micro.api.other", Endpoint: "*"}},
+	}
+	foo := &auth.Resource{Type: "service", Name: "go.micro.api.foo", Endpoint: "Foo.Bar"}
+
+	tt := []struct {
+		Name    string
+		Account *auth.Account
+		Res     *auth.Resource
+		Matches []string
+		Winner  string
+		Granted bool
+	}{
+		{
+			Name:    "HighestPriorityFirst",
+			Account: &auth.Account{ID: "alice", Scopes: []string{"admin"}},
+			Res:     foo,
+			Matches: []string{"deny-admin", "public-foo", "default"},
+			Winner:  "deny-admin",
+		},
+		{
+			Name:    "ScopeDoesNotApply",
+			Account: &auth.Account{ID: "bob", Scopes: []string{"user"}},
+			Res:     foo,
+			Matches: []string{"deny-admin", "public-foo", "default"},
+			Winner:  "public-foo",
+			Granted: true,
+		},
+		{
+			Name:    "Unauthenticated",
+			Res:     foo,
+			Matches: []string{"deny-admin", "public-foo", "default"},
+			Winner:  "public-foo",
+			Granted: true,
+		},
+		{
+			Name:    "PathWildcard",
+			Account: &auth.Account{ID: "bob", Scopes: []string{"user"}},
+			Res:     &auth.Resource{Type: "service", Name: "go.micro.web.home", Endpoint: "GET /foo/bar"},
+			Matches: []string{"web-path", "default"},
+			Winner:  "web-path",
+			Granted: true,
+		},
+		{
+			Name:    "NoRuleApplies",
+			Res:     &auth.Resource{Type: "service", Name: "go.micro.web.home", Endpoint: "/foo/bar"},
+			Matches: []string{"web-path", "default"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			eval := Evaluate(rules, tc.Account, tc.Res)
+
+			var matches []string
+			for _, r := range eval.Matches {
+				matches = append(matches, r.ID)
+			}
+			if len(matches) != len(tc.Matches) {
+				t.Fatalf("Expected matches %v, got %v", tc.Matches, matches)
+			}
+			for i := range matches {
+				if matches[i] != tc.Matches[i] {
+					t.Fatalf("Expected matches %v, got %v", tc.Matches, matches)
+				}
+			}
+
+			var winner string
+			if eval.Winner != nil {
+				winner = eval.Winner.ID
+			}
+			if winner != tc.Winner {
+				t.Errorf("Expected winner %q, got %q", tc.Winner, winner)
+			}
+			if eval.Granted() != tc.Granted {
+				t.Errorf("Expected granted %v, got %v", tc.Granted, eval.Granted())
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tt := []struct {
+		Pattern string
+		Value   string
+		Match   bool
+	}{
+		{"*", "anything", true},
+		{"*", "", true},
+		{"go.micro.api.billing", "go.micro.api.billing", true},
+		{"go.micro.api.billing", "GO.MICRO.API.BILLING", false},
+		{"go.micro.api.billing", "go.micro.api.billing.invoices", false},
+		{"go.micro.api.billing.*", "go.micro.api.billing.invoices", true},
+		{"go.micro.api.billing.*", "go.micro.api.billing", false},
+		{"go.micro.api.billing.*", "go.micro.api.payments", false},
+		{"Invoices.*", "Invoices.Create", true},
+		{"Invoices.*", "Payments.Create", false},
+		{"*.Create", "Invoices.Create", true},
+		{"*.Create", "Invoices.Delete", false},
+		{"go.micro.*.billing", "go.micro.api.billing", true},
+		{"go.micro.*.billing", "go.micro.api.payments", false},
+		{"v?/*", "v1/foo", true},
+		{"v?/*", "v10/foo", false},
+		{"/foo/*", "/foo/bar/baz", true},
+		{"/foo/*", "/foo", true},
+		{"/foo/*", "/foobar", false},
+		{"/foo/*", "/Foo/bar", false},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+	}
+
+	for _, tc := range tt {
+		if got := Match(tc.Pattern, tc.Value); got != tc.Match {
+			t.Errorf("Match(%q, %q) = %v, expected %v", tc.Pattern, tc.Value, got, tc.Match)
+		}
+	}
+}
+
+func TestMethods(t *testing.T) {
+	rules := []*auth.Rule{
+		{ID: "read", Scope: auth.ScopePublic, Resource: &auth.Resource{Type: "service", Name: "go.micro.web.home", Endpoint: "GET,HEAD /docs/*"}},
+		{ID: "write", Scope: "admin", Resource: &auth.Resource{Type: "service", Name: "go.micro.web.home", Endpoint: "/docs/*"}},
+	}
+
+	get := &auth.Resource{Type: "service", Name: "go.micro.web.home", Endpoint: "GET /docs/intro"}
+	if eval := Evaluate(rules, nil, get); !eval.Granted() || eval.Winner.ID != "read" {
+		t.Fatalf("Expected GET to be public, got %v", eval.Winner)
+	}
+	get.Endpoint = "get /docs/intro"
+	if eval := Evaluate(rules, nil, get); !eval.Granted() || eval.Winner.ID != "read" {
+		t.Fatalf("Expected methods to match ignoring case, got %v", eval.Winner)
+	}
+	get.Endpoint = "GET /Docs/intro"
+	if eval := Evaluate(rules, nil, get); eval.Granted() {
+		t.Fatalf("Expected paths to match with case, got %v", eval.Winner)
+	}
+
+	post := &auth.Resource{Type: "service", Name: "go.micro.web.home", Endpoint: "POST /docs/intro"}
+	if eval := Evaluate(rules, nil, post); eval.Granted() || len(eval.Matches) != 1 {
+		t.Fatalf("Expected POST to only match the admin rule, got %v", eval.Matches)
+	}
+	admin := &auth.Account{ID: "alice", Scopes: []string{"admin"}}
+	if eval := Evaluate(rules, admin, post); !eval.Granted() {
+		t.Fatal("Expected an admin to be able to POST")
+	}
+
+	// rpc endpoints have no method so they never match a rule limited to methods
+	rpc := &auth.Resource{Type: "service", Name: "go.micro.web.home", Endpoint: "/docs/intro"}
+	if eval := Evaluate(rules, nil, rpc); len(eval.Matches) != 1 {
+		t.Fatalf("Expected an endpoint without a method to only match the rule for any method, got %v", eval.Matches)
+	}
+}
+
+func TestSpecificity(t *testing.T) {
+	// from most to least specific
+	resources := []*auth.Resource{
+		{Type: "service", Name: "go.micro.api.billing", Endpoint: "GET /invoices"},
+		{Type: "service", Name: "go.micro.api.billing", Endpoint: "/invoices"},
+		{Type: "service", Name: "go.micro.api.billing", Endpoint: "Invoices.*"},
+		{Type: "*", Name: "go.micro.api.billing", Endpoint: "Invoices.*"},
+		{Type: "service", Name: "go.micro.api.billing", Endpoint: "*"},
+		{Type: "service", Name: "go.micro.api.billing.*", Endpoint: "Invoices.Create"},
+		{Type: "service", Name: "go.micro.*", Endpoint: "Invoices.Create"},
+		{Type: "*", Name: "*", Endpoint: "*"},
+	}
+	for i := 1; i < len(resources); i++ {
+		if Specificity(resources[i-1]) <= Specificity(resources[i]) {
+			t.Errorf("Expected %v to be more specific than %v", resources[i-1], resources[i])
+		}
+	}
+
+	// the most specific rule wins at the same priority, whatever the order
+	rules := []*auth.Rule{
+		{ID: "a-any", Scope: auth.ScopePublic, Access: auth.AccessGranted, Resource: &auth.Resource{Type: "service", Name: "go.micro.api.billing.*", Endpoint: "*"}},
+		{ID: "b-invoices", Scope: auth.ScopePublic, Access: auth.AccessDenied, Resource: &auth.Resource{Type: "service", Name: "go.micro.api.billing.*", Endpoint: "Invoices.*"}},
+	}
+	res := &auth.Resource{Type: "service", Name: "go.micro.api.billing.v1", Endpoint: "Invoices.Create"}
+	for i := 0; i < 2; i++ {
+		if eval := Evaluate(rules, nil, res); eval.Winner.ID != "b-invoices" {
+			t.Fatalf("Expected the most specific rule to win, got %v", eval.Winner.ID)
+		}
+		rules[0], rules[1] = rules[1], rules[0]
+	}
+}
@@ -9,8 +9,11 @@ import (
 	"text/tabwriter"
 
 	"c-z.dev/go-micro/auth"
-	pb "c-z.dev/go-micro/auth/service/proto"
+	"c-z.dev/go-micro/errors"
 	"c-z.dev/micro/internal/client"
+	authHandler "c-z.dev/micro/service/auth/handler/auth"
+	pb "c-z.dev/micro/service/auth/proto"
+	"github.com/chzyer/readline"
 	"github.com/urfave/cli/v2"
 )
 
@@ -69,6 +72,193 @@ func createAccount(ctx *cli.Context) {
 	fmt.Printf("Account created: %v\n", string(json))
 }
 
+func updateAccount(ctx *cli.Context) {
+	if ctx.Args().Len() != 1 {
+		fmt.Println("Expected one argument: ID")
+		os.Exit(1)
+	}
+
+	metadata := make(map[string]string)
+	for _, md := range ctx.StringSlice("metadata") {
+		comps := strings.SplitN(md, "=", 2)
+		if len(comps) != 2 {
+			fmt.Printf("Invalid metadata: %v, must be in the format key=value\n", md)
+			os.Exit(1)
+		}
+		metadata[comps[0]] = comps[1]
+	}
+
+	rsp, err := accountsFromContext(ctx).Update(context.TODO(), &pb.UpdateAccountRequest{
+		Id:       ctx.Args().First(),
+		Scopes:   ctx.StringSlice("scopes"),
+		Metadata: metadata,
+	})
+	if err != nil {
+		fmt.Printf("Error updating account: %v\n", err)
+		os.Exit(1)
+	}
+
+	json, _ := json.Marshal(rsp.Account)
+	fmt.Printf("Account updated: %v\n", string(json))
+}
+
+func deleteAccount(ctx *cli.Context) {
+	if ctx.Args().Len() != 1 {
+		fmt.Println("Expected one argument: ID")
+		os.Exit(1)
+	}
+
+	_, err := accountsFromContext(ctx).Delete(context.TODO(), &pb.DeleteAccountRequest{
+		Id: ctx.Args().First(),
+	})
+	if err != nil {
+		fmt.Printf("Error deleting account: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Account deleted")
+}
+
+func disableAccount(ctx *cli.Context) {
+	if ctx.Args().Len() != 1 {
+		fmt.Println("Expected one argument: ID")
+		os.Exit(1)
+	}
+
+	_, err := accountsFromContext(ctx).Disable(context.TODO(), &pb.DisableAccountRequest{
+		Id: ctx.Args().First(),
+	})
+	if err != nil {
+		fmt.Printf("Error disabling account: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Account disabled")
+}
+
+func enableAccount(ctx *cli.Context) {
+	if ctx.Args().Len() != 1 {
+		fmt.Println("Expected one argument: ID")
+		os.Exit(1)
+	}
+
+	_, err := accountsFromContext(ctx).Enable(context.TODO(), &pb.EnableAccountRequest{
+		Id: ctx.Args().First(),
+	})
+	if err != nil {
+		fmt.Printf("Error enabling account: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Account enabled")
+}
+
+// unlockAccount ends the backoff or lockout of an account, or of a source address
+func unlockAccount(ctx *cli.Context) {
+	if ctx.Args().Len() > 1 || (ctx.Args().Len() == 0 && len(ctx.String("source")) == 0) {
+		fmt.Println("Expected one argument: ID, or the --source flag")
+		os.Exit(1)
+	}
+
+	_, err := accountsFromContext(ctx).Unlock(context.TODO(), &pb.UnlockAccountRequest{
+		Id:     ctx.Args().First(),
+		Source: ctx.String("source"),
+	})
+	if err != nil {
+		fmt.Printf("Error unlocking account: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Unlocked")
+}
+
+// changeSecret changes the secret of an account, prompting for the secrets which aren't
+// passed as flags
+func changeSecret(ctx *cli.Context) {
+	if ctx.Args().Len() != 1 {
+		fmt.Println("Expected one argument: ID")
+		os.Exit(1)
+	}
+
+	oldSecret := ctx.String("old-secret")
+	if len(oldSecret) == 0 && !ctx.Bool("admin") {
+		oldSecret = readSecret("Current secret: ")
+	}
+	newSecret := ctx.String("secret")
+	if len(newSecret) == 0 {
+		newSecret = readSecret("New secret: ")
+		if readSecret("Repeat new secret: ") != newSecret {
+			fmt.Println("The secrets don't match")
+			os.Exit(1)
+		}
+	}
+
+	// accounts enrolled in MFA are asked for a code
+	req := &pb.ChangeSecretRequest{
+		Id:        ctx.Args().First(),
+		OldSecret: oldSecret,
+		NewSecret: newSecret,
+		Code:      ctx.String("code"),
+	}
+	_, err := accountsFromContext(ctx).ChangeSecret(context.TODO(), req)
+	if verr, ok := err.(*errors.Error); ok && verr.Detail == authHandler.MFARequired && len(req.Code) == 0 {
+		req.Code = readSecret("MFA code: ")
+		_, err = accountsFromContext(ctx).ChangeSecret(context.TODO(), req)
+	}
+	if err != nil {
+		fmt.Printf("Error changing secret: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Secret changed, the refresh tokens of the account have been revoked")
+}
+
+// enrolMFA enrols an account in MFA, confirming the enrolment with a code from the
+// authenticator it's added to
+func enrolMFA(ctx *cli.Context) {
+	if ctx.Args().Len() != 1 {
+		fmt.Println("Expected one argument: ID")
+		os.Exit(1)
+	}
+	client := accountsFromContext(ctx)
+
+	// replacing an enrolment needs a code from the current authenticator
+	req := &pb.EnrolMFARequest{Id: ctx.Args().First()}
+	rsp, err := client.EnrolMFA(context.TODO(), req)
+	if verr, ok := err.(*errors.Error); ok && verr.Detail == authHandler.MFARequired {
+		req.CurrentCode = readSecret("Code from your current authenticator: ")
+		rsp, err = client.EnrolMFA(context.TODO(), req)
+	}
+	if err != nil {
+		fmt.Printf("Error enrolling in MFA: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Add this URI to your authenticator app, or enter the secret by hand:")
+	fmt.Printf("\n  %v\n  %v\n\n", rsp.Uri, rsp.Secret)
+	fmt.Println("Keep these recovery codes somewhere safe, each can be used once instead of a code:")
+	fmt.Printf("\n  %v\n\n", strings.Join(rsp.RecoveryCodes, "\n  "))
+
+	code := readSecret("Code from your authenticator: ")
+	_, err = client.EnrolMFA(context.TODO(), &pb.EnrolMFARequest{Id: ctx.Args().First(), Code: code})
+	if err != nil {
+		fmt.Printf("Error confirming MFA: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("MFA enabled, logins now need a code")
+}
+
+// readSecret prompts for a secret without echoing it
+func readSecret(prompt string) string {
+	secret, err := readline.Password(prompt)
+	if err != nil {
+		fmt.Printf("Error reading secret: %v\n", err)
+		os.Exit(1)
+	}
+	return string(secret)
+}
+
 func accountsFromContext(ctx *cli.Context) pb.AccountsService {
 	return pb.NewAccountsService("go.micro.auth", client.New(ctx))
 }
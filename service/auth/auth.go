@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
@@ -9,7 +10,6 @@ import (
 	"c-z.dev/go-micro"
 	"c-z.dev/go-micro/auth"
 	srvAuth "c-z.dev/go-micro/auth/service"
-	pb "c-z.dev/go-micro/auth/service/proto"
 	"c-z.dev/go-micro/config/cmd"
 	"c-z.dev/go-micro/errors"
 	log "c-z.dev/go-micro/logger"
@@ -20,6 +20,7 @@ import (
 	"c-z.dev/micro/service/auth/api"
 	authHandler "c-z.dev/micro/service/auth/handler/auth"
 	rulesHandler "c-z.dev/micro/service/auth/handler/rules"
+	pb "c-z.dev/micro/service/auth/proto"
 	"github.com/urfave/cli/v2"
 )
 
@@ -40,6 +41,21 @@ var (
 			EnvVars: []string{"MICRO_AUTH_PROVIDER"},
 			Usage:   "Auth provider enables account generation",
 		},
+		&cli.DurationFlag{
+			Name:    "refresh_token_expiry",
+			EnvVars: []string{"MICRO_AUTH_REFRESH_TOKEN_EXPIRY"},
+			Usage:   "How long a refresh token can be used for, e.g. 720h. Every use issues a new one",
+		},
+		&cli.StringSliceFlag{
+			Name:    "bootstrap_namespaces",
+			EnvVars: []string{"MICRO_AUTH_BOOTSTRAP_NAMESPACES"},
+			Usage:   "Namespaces which get an admin account while they have no user accounts, * for all. Defaults to the default namespace",
+		},
+		&cli.StringFlag{
+			Name:    "bootstrap_secret",
+			EnvVars: []string{"MICRO_AUTH_BOOTSTRAP_SECRET"},
+			Usage:   "Secret of the bootstrap admin accounts, a random one is logged if not set. It must be changed on first login",
+		},
 	}
 	// RuleFlags are provided to commands which create or delete rules
 	RuleFlags = []cli.Flag{
@@ -49,7 +65,7 @@ var (
 		},
 		&cli.StringFlag{
 			Name:  "resource",
-			Usage: "The resource to amend in the format type:name:endpoint, e.g. service:go.micro.auth:*",
+			Usage: "The resource to amend in the format type:name:endpoint, e.g. service:go.micro.auth:*. Fields are globs such as go.micro.api.billing.* and web endpoints can be limited to methods, e.g. 'GET,HEAD /docs/*'",
 		},
 		&cli.StringFlag{
 			Name:  "access",
@@ -73,6 +89,55 @@ var (
 			Usage: "Comma seperated list of scopes to give the account",
 		},
 	}
+	// UpdateAccountFlags are provided to the update account command
+	UpdateAccountFlags = []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "scopes",
+			Usage: "Comma seperated list of scopes to replace the account's scopes with",
+		},
+		&cli.StringSliceFlag{
+			Name:  "metadata",
+			Usage: "Metadata to set in the format key=value, an empty value removes the key",
+		},
+	}
+	// SecretFlags are provided to the passwd command
+	SecretFlags = []cli.Flag{
+		&cli.StringFlag{
+			Name:  "secret",
+			Usage: "The new secret, prompted for if not set",
+		},
+		&cli.StringFlag{
+			Name:  "old-secret",
+			Usage: "The current secret, prompted for if not set",
+		},
+		&cli.BoolFlag{
+			Name:  "admin",
+			Usage: "Change the secret as an admin without the current secret",
+		},
+		&cli.StringFlag{
+			Name:  "code",
+			Usage: "The MFA code of an account enrolled in MFA, prompted for if needed",
+		},
+	}
+	// KeyFlags are provided to the create key command
+	KeyFlags = []cli.Flag{
+		&cli.StringFlag{
+			Name:  "account",
+			Usage: "The account the key acts as, defaults to yours. Only admins can create keys for other accounts",
+		},
+		&cli.StringSliceFlag{
+			Name:  "scopes",
+			Usage: "Comma seperated list of the account's scopes to limit the key to, defaults to all of them",
+		},
+		&cli.StringSliceFlag{
+			Name:  "allowed-ips",
+			Usage: "Comma seperated list of the addresses and CIDR ranges the key can be used from, e.g. 10.0.0.0/8. Defaults to any",
+		},
+		&cli.DurationFlag{
+			Name:  "expiry",
+			Usage: "How long until the key expires, e.g. 2160h. Defaults to never",
+		},
+	}
 )
 
 // run the auth service
@@ -88,7 +153,15 @@ func Run(ctx *cli.Context, srvOpts ...micro.Option) {
 
 	// setup the handlers
 	ruleH := &rulesHandler.Rules{}
-	authH := &authHandler.Auth{}
+	authH := &authHandler.Auth{
+		RefreshTokenExpiry: ctx.Duration("refresh_token_expiry"),
+		BootstrapSecret:    ctx.String("bootstrap_secret"),
+		// without auth every call arrives without an account
+		Open: (*cmd.DefaultCmd.Options().Auth).String() == "noop",
+	}
+	if ctx.IsSet("bootstrap_namespaces") {
+		authH.Bootstrap = ctx.StringSlice("bootstrap_namespaces")
+	}
 
 	st := *cmd.DefaultCmd.Options().Store
 
@@ -104,6 +177,7 @@ func Run(ctx *cli.Context, srvOpts ...micro.Option) {
 	pb.RegisterAuthHandler(service.Server(), authH)
 	pb.RegisterRulesHandler(service.Server(), ruleH)
 	pb.RegisterAccountsHandler(service.Server(), authH)
+	pb.RegisterKeysHandler(service.Server(), &authHandler.Keys{Auth: authH})
 
 	// run service
 	if err := service.Run(); err != nil {
@@ -146,12 +220,45 @@ func login(ctx *cli.Context) {
 	id := ctx.Args().Get(0)
 	secret := ctx.Args().Get(1)
 
-	// Execute the request
-	tok, err := authFromContext(ctx).Token(auth.WithCredentials(id, secret), auth.WithExpiry(time.Hour*24))
+	// Execute the request, accounts enrolled in MFA are asked for a code
+	req := &pb.TokenRequest{
+		Id:          id,
+		Secret:      secret,
+		Code:        ctx.String("code"),
+		TokenExpiry: int64((time.Hour * 24).Seconds()),
+	}
+	rsp, err := authServiceFromContext(ctx).Token(context.TODO(), req)
+	if verr, ok := err.(*errors.Error); ok && verr.Detail == authHandler.MFARequired && len(req.Code) == 0 {
+		req.Code = readSecret("MFA code: ")
+		rsp, err = authServiceFromContext(ctx).Token(context.TODO(), req)
+	}
+	// accounts such as the bootstrap admin have to change their secret first
+	if verr, ok := err.(*errors.Error); ok && verr.Detail == authHandler.SecretChangeRequired {
+		fmt.Println("Your secret must be changed before logging in")
+		newSecret := readSecret("New secret: ")
+		if newSecret != readSecret("Confirm new secret: ") {
+			fmt.Println("Secrets don't match")
+			os.Exit(1)
+		}
+		// the code given to Token is used up, enrolled accounts need the next one
+		creq := &pb.ChangeSecretRequest{Id: id, OldSecret: secret, NewSecret: newSecret}
+		_, err = accountsFromContext(ctx).ChangeSecret(context.TODO(), creq)
+		if verr, ok := err.(*errors.Error); ok && verr.Detail == authHandler.MFARequired {
+			creq.Code = readSecret("Next MFA code: ")
+			_, err = accountsFromContext(ctx).ChangeSecret(context.TODO(), creq)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		req.Secret = newSecret
+		rsp, err = authServiceFromContext(ctx).Token(context.TODO(), req)
+	}
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	tok := rsp.Token
 
 	// Store the access token in micro config
 	if err := config.Set(tok.AccessToken, "micro", "auth", env.Name, "token"); err != nil {
@@ -168,6 +275,38 @@ func login(ctx *cli.Context) {
 	fmt.Println("You have been logged in")
 }
 
+// logout revokes the session of the logged in user and forgets their tokens
+func logout(ctx *cli.Context) {
+	env := cliutil.GetEnv(ctx)
+	refresh, _ := config.Get("micro", "auth", env.Name, "refresh-token")
+	if len(refresh) == 0 && !ctx.Bool("all") {
+		fmt.Println("You are not logged in")
+		os.Exit(1)
+	}
+
+	_, err := authServiceFromContext(ctx).Logout(context.TODO(), &pb.LogoutRequest{
+		RefreshToken: refresh,
+		All:          ctx.Bool("all"),
+	})
+	if err != nil {
+		fmt.Printf("Error logging out: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, key := range []string{"token", "refresh-token"} {
+		if err := config.Set("", "micro", "auth", env.Name, key); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Println("You have been logged out")
+}
+
+func authServiceFromContext(ctx *cli.Context) pb.AuthService {
+	return pb.NewAuthService("go.micro.auth", client.New(ctx))
+}
+
 // whoami returns info about the logged in user
 func whoami(ctx *cli.Context) {
 	// Get the token from micro config
@@ -225,6 +364,20 @@ func Commands(srvOpts ...micro.Option) []*cli.Command {
 								return nil
 							},
 						},
+						{
+							Name:  "keys",
+							Usage: "List API keys, yours or every key if you're an admin",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:  "account",
+									Usage: "Only list the keys of an account",
+								},
+							},
+							Action: func(ctx *cli.Context) error {
+								listKeys(ctx)
+								return nil
+							},
+						},
 					}),
 				},
 				{
@@ -249,6 +402,16 @@ func Commands(srvOpts ...micro.Option) []*cli.Command {
 								return nil
 							},
 						},
+						{
+							Name:      "key",
+							Usage:     "Create an API key, sent in the Authorization header as 'ApiKey <key>'",
+							ArgsUsage: "{name}",
+							Flags:     KeyFlags,
+							Action: func(ctx *cli.Context) error {
+								createKey(ctx)
+								return nil
+							},
+						},
 					}),
 				},
 				{
@@ -264,8 +427,161 @@ func Commands(srvOpts ...micro.Option) []*cli.Command {
 								return nil
 							},
 						},
+						{
+							Name:  "account",
+							Usage: "Delete an auth account and revoke its refresh tokens and API keys",
+							Action: func(ctx *cli.Context) error {
+								deleteAccount(ctx)
+								return nil
+							},
+						},
+						{
+							Name:      "key",
+							Usage:     "Revoke an API key, the tokens it was exchanged for last until they expire",
+							ArgsUsage: "{id}",
+							Action: func(ctx *cli.Context) error {
+								deleteKey(ctx)
+								return nil
+							},
+						},
 					}),
 				},
+				{
+					Name:      "update",
+					Usage:     "Update the scopes and metadata of an auth account",
+					ArgsUsage: "{id}",
+					Flags:     UpdateAccountFlags,
+					Action: func(ctx *cli.Context) error {
+						updateAccount(ctx)
+						return nil
+					},
+				},
+				{
+					Name:      "disable",
+					Usage:     "Disable an auth account and revoke its refresh tokens",
+					ArgsUsage: "{id}",
+					Action: func(ctx *cli.Context) error {
+						disableAccount(ctx)
+						return nil
+					},
+				},
+				{
+					Name:      "enable",
+					Usage:     "Enable a disabled auth account",
+					ArgsUsage: "{id}",
+					Action: func(ctx *cli.Context) error {
+						enableAccount(ctx)
+						return nil
+					},
+				},
+				{
+					Name:      "unlock",
+					Usage:     "Forget the failed logins of an auth account, ending its lockout",
+					ArgsUsage: "{id}",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "source",
+							Usage: "Unlock a source address instead of, or as well as, an account",
+						},
+					},
+					Action: func(ctx *cli.Context) error {
+						unlockAccount(ctx)
+						return nil
+					},
+				},
+				{
+					Name:      "mfa",
+					Usage:     "Enrol an auth account in TOTP MFA",
+					ArgsUsage: "{id}",
+					Action: func(ctx *cli.Context) error {
+						enrolMFA(ctx)
+						return nil
+					},
+				},
+				{
+					Name:      "passwd",
+					Usage:     "Change the secret of an auth account",
+					ArgsUsage: "{id}",
+					Flags:     SecretFlags,
+					Action: func(ctx *cli.Context) error {
+						changeSecret(ctx)
+						return nil
+					},
+				},
+				{
+					Name:  "logout",
+					Usage: "Logout, revoking the refresh token of the session",
+					Action: func(ctx *cli.Context) error {
+						logout(ctx)
+						return nil
+					},
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:  "all",
+							Usage: "Revoke the refresh tokens of every session of the account",
+						},
+					},
+				},
+				{
+					Name:  "verify",
+					Usage: "Explain whether an account has access to a resource, listing the rules which match in evaluation order",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "account",
+							Usage: "The ID of the account, leave blank for an unauthenticated request",
+						},
+						&cli.StringFlag{
+							Name:  "resource",
+							Usage: "The resource in the format type:name:endpoint, e.g. service:go.micro.api.foo:Foo.Bar",
+						},
+					},
+					Action: func(ctx *cli.Context) error {
+						verifyRules(ctx)
+						return nil
+					},
+				},
+				{
+					Name:  "apply",
+					Usage: "Apply a policy file, creating, updating and deleting rules and accounts to match it",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "file",
+							Aliases: []string{"f"},
+							Usage:   "The yaml or json policy file",
+						},
+						&cli.BoolFlag{
+							Name:  "dry-run",
+							Usage: "Only print the changes which would be made",
+						},
+						&cli.BoolFlag{
+							Name:  "prune",
+							Usage: "Delete the rules and accounts which aren't in the policy, service accounts are never deleted",
+						},
+					},
+					Action: func(ctx *cli.Context) error {
+						applyPolicy(ctx)
+						return nil
+					},
+				},
+				{
+					Name:  "export",
+					Usage: "Export the rules and accounts as a policy file",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "file",
+							Aliases: []string{"f"},
+							Usage:   "The file to write, the policy is printed if not set",
+						},
+						&cli.StringFlag{
+							Name:  "format",
+							Usage: "yaml or json, defaults to json for .json files and yaml otherwise",
+						},
+					},
+					Action: func(ctx *cli.Context) error {
+						exportPolicy(ctx)
+						return nil
+					},
+				},
 				{
 					Name:        "api",
 					Usage:       "Run the auth api",
@@ -290,6 +606,10 @@ func Commands(srvOpts ...micro.Option) []*cli.Command {
 					Name:  "token",
 					Usage: "The token to set",
 				},
+				&cli.StringFlag{
+					Name:  "code",
+					Usage: "The MFA code, prompted for if the account needs one",
+				},
 			},
 		},
 		{
@@ -4,18 +4,40 @@ import (
 	"context"
 	"encoding/json"
 	"strings"
+	"time"
 
 	"c-z.dev/go-micro/auth"
-	pb "c-z.dev/go-micro/auth/service/proto"
+	"c-z.dev/go-micro/auth/token"
 	"c-z.dev/go-micro/errors"
 	"c-z.dev/go-micro/store"
 	"c-z.dev/micro/internal/namespace"
+	pb "c-z.dev/micro/service/auth/proto"
 )
 
+// adminScope is the scope of the accounts which can manage other accounts
+const adminScope = "admin"
+
+// account is an account as it's stored, the auth.Account along with the state only the
+// auth service needs
+type account struct {
+	auth.Account
+	// Disabled accounts can't get tokens
+	Disabled bool `json:"disabled,omitempty"`
+	// MFA is set once an account has enrolled in MFA, Token needs a code from then on
+	MFA *mfa `json:"mfa,omitempty"`
+	// PendingMFA is an enrolment which hasn't been confirmed with a code yet
+	PendingMFA *mfa `json:"pending_mfa,omitempty"`
+	// ChangeSecret is set when the secret has to be changed before getting a token
+	ChangeSecret bool `json:"change_secret,omitempty"`
+	// Provider is the identity provider the account logs in with, such accounts only get
+	// tokens issued by an admin
+	Provider string `json:"provider,omitempty"`
+}
+
 // List returns all auth accounts
 func (a *Auth) List(ctx context.Context, req *pb.ListAccountsRequest, rsp *pb.ListAccountsResponse) error {
-	// setup the defaults incase none exist
-	a.setupDefaultAccount(namespace.FromContext(ctx))
+	// bootstrap the namespace incase it has no accounts
+	a.bootstrap(namespace.FromContext(ctx))
 
 	// get the records from the store
 	key := strings.Join([]string{storePrefixAccounts, namespace.FromContext(ctx), ""}, joinKey)
@@ -25,9 +47,9 @@ func (a *Auth) List(ctx context.Context, req *pb.ListAccountsRequest, rsp *pb.Li
 	}
 
 	// unmarshal the records
-	var accounts = make([]*auth.Account, 0, len(recs))
+	var accounts = make([]*account, 0, len(recs))
 	for _, rec := range recs {
-		var r *auth.Account
+		var r *account
 		if err := json.Unmarshal(rec.Value, &r); err != nil {
 			return errors.InternalServerError("go.micro.auth", "Error to unmarshaling json: %v. Value: %v", err, string(rec.Value))
 		}
@@ -37,9 +59,270 @@ func (a *Auth) List(ctx context.Context, req *pb.ListAccountsRequest, rsp *pb.Li
 	// serialize the accounts
 	rsp.Accounts = make([]*pb.Account, 0, len(recs))
 	for _, a := range accounts {
-		rsp.Accounts = append(rsp.Accounts, serializeAccount(a))
+		acc := serializeAccount(&a.Account)
+		acc.Disabled = a.Disabled
+		rsp.Accounts = append(rsp.Accounts, acc)
+	}
+
+	return nil
+}
+
+// Update the scopes and metadata of an account
+func (a *Auth) Update(ctx context.Context, req *pb.UpdateAccountRequest, rsp *pb.UpdateAccountResponse) error {
+	if err := a.checkAdmin(ctx); err != nil {
+		return err
 	}
 
+	a.accountLock.Lock()
+	defer a.accountLock.Unlock()
+
+	acc, err := a.readAccount(ctx, req.Id)
+	if err != nil {
+		return err
+	}
+	if len(req.Provider) > 0 && acc.Provider != req.Provider {
+		return errors.Forbidden("go.micro.auth", "Account wasn't provisioned by %s", req.Provider)
+	}
+
+	if len(req.Scopes) > 0 {
+		acc.Scopes = req.Scopes
+	}
+	for k, v := range req.Metadata {
+		if acc.Metadata == nil {
+			acc.Metadata = make(map[string]string)
+		}
+		if len(v) == 0 {
+			delete(acc.Metadata, k)
+		} else {
+			acc.Metadata[k] = v
+		}
+	}
+
+	if err := a.writeAccount(ctx, acc); err != nil {
+		return err
+	}
+
+	rsp.Account = serializeAccount(&acc.Account)
+	rsp.Account.Disabled = acc.Disabled
+	return nil
+}
+
+// Delete an account and revoke its refresh tokens and API keys
+func (a *Auth) Delete(ctx context.Context, req *pb.DeleteAccountRequest, rsp *pb.DeleteAccountResponse) error {
+	if err := a.checkAdmin(ctx); err != nil {
+		return err
+	}
+	if err := checkNotSelf(ctx, req.Id, "delete"); err != nil {
+		return err
+	}
+
+	a.accountLock.Lock()
+	defer a.accountLock.Unlock()
+
+	if _, err := a.readAccount(ctx, req.Id); err != nil {
+		return err
+	}
+
+	// revoke the refresh tokens first so a failure doesn't leave them usable
+	if err := a.revokeRefreshTokens(ctx, req.Id); err != nil {
+		return errors.InternalServerError("go.micro.auth", "Unable to revoke refresh tokens: %v", err)
+	}
+	if err := a.revokeKeys(ctx, req.Id); err != nil {
+		return errors.InternalServerError("go.micro.auth", "Unable to revoke API keys: %v", err)
+	}
+
+	key := strings.Join([]string{storePrefixAccounts, namespace.FromContext(ctx), req.Id}, joinKey)
+	if err := a.Options.Store.Delete(key); err != nil {
+		return errors.InternalServerError("go.micro.auth", "Unable to delete account from store: %v", err)
+	}
+	return nil
+}
+
+// Disable an account and revoke its refresh tokens
+func (a *Auth) Disable(ctx context.Context, req *pb.DisableAccountRequest, rsp *pb.DisableAccountResponse) error {
+	if err := a.checkAdmin(ctx); err != nil {
+		return err
+	}
+	if err := checkNotSelf(ctx, req.Id, "disable"); err != nil {
+		return err
+	}
+	return a.setDisabled(ctx, req.Id, true)
+}
+
+// Enable an account which was disabled
+func (a *Auth) Enable(ctx context.Context, req *pb.EnableAccountRequest, rsp *pb.EnableAccountResponse) error {
+	if err := a.checkAdmin(ctx); err != nil {
+		return err
+	}
+	return a.setDisabled(ctx, req.Id, false)
+}
+
+func (a *Auth) setDisabled(ctx context.Context, id string, disabled bool) error {
+	a.accountLock.Lock()
+	defer a.accountLock.Unlock()
+
+	acc, err := a.readAccount(ctx, id)
+	if err != nil {
+		return err
+	}
+	acc.Disabled = disabled
+	if err := a.writeAccount(ctx, acc); err != nil {
+		return err
+	}
+
+	if !disabled {
+		return nil
+	}
+	if err := a.revokeRefreshTokens(ctx, id); err != nil {
+		return errors.InternalServerError("go.micro.auth", "Unable to revoke refresh tokens: %v", err)
+	}
+	return nil
+}
+
+// ChangeSecret sets the secret of an account and revokes its refresh tokens. Admins can
+// change any secret, anyone else has to provide the old secret.
+func (a *Auth) ChangeSecret(ctx context.Context, req *pb.ChangeSecretRequest, rsp *pb.ChangeSecretResponse) error {
+	if len(req.NewSecret) == 0 {
+		return errors.BadRequest("go.micro.auth", "New secret required")
+	}
+
+	a.accountLock.Lock()
+	defer a.accountLock.Unlock()
+
+	admin := a.checkAdmin(ctx) == nil
+	acc, err := a.readAccount(ctx, req.Id)
+	if verr, ok := err.(*errors.Error); ok && verr.Code == 404 && !admin {
+		// unknown accounts fail like a wrong secret
+		acc, err = nil, nil
+	}
+	if err != nil {
+		return err
+	}
+	if !admin {
+		// the old secret can be guessed here as well as through Token
+		source := a.sourceFromContext(ctx)
+		if err := a.checkAttempts(ctx, req.Id, source); err != nil {
+			return err
+		}
+		if !checkSecret(acc, req.OldSecret) {
+			a.recordFailure(ctx, req.Id, source)
+			return errInvalidCredentials
+		}
+		// the secret alone mustn't be enough to take over an account enrolled in MFA
+		if acc.MFA != nil {
+			if err := acc.MFA.verify(req.Code); err != nil {
+				if verr, ok := err.(*errors.Error); !ok || verr.Detail != MFARequired {
+					a.recordFailure(ctx, req.Id, source)
+				}
+				return err
+			}
+		}
+	}
+
+	secret, err := hashSecret(req.NewSecret)
+	if err != nil {
+		return errors.InternalServerError("go.micro.auth", "Unable to hash password: %v", err)
+	}
+	acc.Secret = secret
+	acc.ChangeSecret = false
+	if err := a.writeAccount(ctx, acc); err != nil {
+		return err
+	}
+
+	if err := a.revokeRefreshTokens(ctx, req.Id); err != nil {
+		return errors.InternalServerError("go.micro.auth", "Unable to revoke refresh tokens: %v", err)
+	}
+	return nil
+}
+
+// IssueToken issues an access token for an account provisioned by an identity provider,
+// once the provider has logged the user in
+func (a *Auth) IssueToken(ctx context.Context, req *pb.IssueTokenRequest, rsp *pb.IssueTokenResponse) error {
+	if err := a.checkAdmin(ctx); err != nil {
+		return err
+	}
+	if len(req.Provider) == 0 {
+		return errors.BadRequest("go.micro.auth", "Provider required")
+	}
+
+	acc, err := a.readAccount(ctx, req.Id)
+	if err != nil {
+		return err
+	}
+	if acc.Provider != req.Provider {
+		return errors.Forbidden("go.micro.auth", "Account wasn't provisioned by %s", req.Provider)
+	}
+	if acc.Disabled {
+		return errors.Forbidden("go.micro.auth", "Account is disabled")
+	}
+
+	duration := time.Duration(req.TokenExpiry) * time.Second
+	tok, err := a.TokenProvider.Generate(&acc.Account, token.WithExpiry(duration))
+	if err != nil {
+		return errors.InternalServerError("go.micro.auth", "Unable to generate token: %v", err)
+	}
+	rsp.Token = serializeToken(tok, "")
+	return nil
+}
+
+// readAccount reads an account of the namespace
+func (a *Auth) readAccount(ctx context.Context, id string) (*account, error) {
+	if len(id) == 0 {
+		return nil, errors.BadRequest("go.micro.auth", "ID required")
+	}
+
+	key := strings.Join([]string{storePrefixAccounts, namespace.FromContext(ctx), id}, joinKey)
+	recs, err := a.Options.Store.Read(key)
+	if err == store.ErrNotFound {
+		return nil, errors.NotFound("go.micro.auth", "Account not found with this ID")
+	} else if err != nil {
+		return nil, errors.InternalServerError("go.micro.auth", "Unable to read from store: %v", err)
+	}
+
+	var acc *account
+	if err := json.Unmarshal(recs[0].Value, &acc); err != nil {
+		return nil, errors.InternalServerError("go.micro.auth", "Unable to unmarshal account: %v", err)
+	}
+	return acc, nil
+}
+
+// writeAccount writes an account to the namespace
+func (a *Auth) writeAccount(ctx context.Context, acc *account) error {
+	bytes, err := json.Marshal(acc)
+	if err != nil {
+		return errors.InternalServerError("go.micro.auth", "Unable to marshal json: %v", err)
+	}
+
+	key := strings.Join([]string{storePrefixAccounts, namespace.FromContext(ctx), acc.ID}, joinKey)
+	if err := a.Options.Store.Write(&store.Record{Key: key, Value: bytes}); err != nil {
+		return errors.InternalServerError("go.micro.auth", "Unable to write account to store: %v", err)
+	}
+	return nil
+}
+
+// checkAdmin returns an errors.Forbidden error unless the caller has the admin scope.
+// The auth service is public so calls without an account are only trusted when the
+// service is open.
+func (a *Auth) checkAdmin(ctx context.Context) error {
+	acc, ok := auth.AccountFromContext(ctx)
+	if !ok && a.Open {
+		return nil
+	} else if !ok {
+		return errors.Forbidden("go.micro.auth", "Managing accounts requires an account with the %s scope", adminScope)
+	}
+	for _, s := range acc.Scopes {
+		if s == adminScope {
+			return nil
+		}
+	}
+	return errors.Forbidden("go.micro.auth", "Managing accounts requires the %s scope", adminScope)
+}
+
+// checkNotSelf stops callers locking themselves out
+func checkNotSelf(ctx context.Context, id, action string) error {
+	if acc, ok := auth.AccountFromContext(ctx); ok && acc.ID == id {
+		return errors.BadRequest("go.micro.auth", "You can't %s the account you're using", action)
+	}
 	return nil
 }
 
@@ -10,7 +10,6 @@ import (
 	"github.com/google/uuid"
 
 	"c-z.dev/go-micro/auth"
-	pb "c-z.dev/go-micro/auth/service/proto"
 	"c-z.dev/go-micro/auth/token"
 	"c-z.dev/go-micro/auth/token/basic"
 	"c-z.dev/go-micro/errors"
@@ -18,29 +17,60 @@ import (
 	"c-z.dev/go-micro/store"
 	memStore "c-z.dev/go-micro/store/memory"
 	"c-z.dev/micro/internal/namespace"
+	pb "c-z.dev/micro/service/auth/proto"
 	"golang.org/x/crypto/bcrypt"
 )
 
 const (
+	// SecretChangeRequired is the detail of the error returned by Token when the
+	// credentials are correct but the secret has to be changed first
+	SecretChangeRequired = "Secret must be changed before logging in"
+
 	joinKey                  = "/"
 	storePrefixAccounts      = "account"
 	storePrefixRefreshTokens = "refresh"
+	storePrefixRefreshIndex  = "refreshindex"
+
+	// DefaultRefreshTokenExpiry is how long a refresh token can be used for
+	DefaultRefreshTokenExpiry = time.Hour * 24 * 30
 )
 
-var defaultAccount = &auth.Account{
-	ID:     "default",
-	Type:   "user",
-	Scopes: []string{"admin"},
-	Secret: "password",
-}
+// BootstrapAccount is the ID of the admin account a namespace is bootstrapped with
+const BootstrapAccount = "admin"
 
 // Auth processes RPC calls
 type Auth struct {
 	Options       auth.Options
 	TokenProvider token.Provider
+	// Bootstrap is the namespaces which get an admin account while they have no user
+	// accounts, "*" bootstraps every namespace
+	Bootstrap []string
+	// BootstrapSecret is the secret of the bootstrap accounts, a random one is generated
+	// and logged if it's empty
+	BootstrapSecret string
+	// Open trusts calls without an account to manage accounts, it's only set when auth
+	// is disabled
+	Open bool
+	// RefreshTokenExpiry is how long a refresh token can be used for, every use issues
+	// a new one
+	RefreshTokenExpiry time.Duration
+	// AccountLockout and SourceLockout limit the failed attempts to get a token for an
+	// account and from an address
+	AccountLockout LockoutPolicy
+	SourceLockout  LockoutPolicy
 
 	namespaces map[string]bool
-	sync.Mutex
+	// namespaceLock guards namespaces, it isn't embedded as Unlock is an RPC
+	namespaceLock sync.Mutex
+
+	// accountLock serialises the read-modify-write of accounts
+	accountLock sync.Mutex
+	// refreshLock serialises the rotation of refresh tokens
+	refreshLock sync.Mutex
+	// attemptLock serialises counting failed attempts
+	attemptLock sync.Mutex
+	// keyLock serialises the read-modify-write of API keys
+	keyLock sync.Mutex
 }
 
 // Init the auth
@@ -59,22 +89,39 @@ func (a *Auth) Init(opts ...auth.Option) {
 		a.Options.Store = memStore.NewStore()
 	}
 
+	// only the default namespace is bootstrapped unless the operator says otherwise, calls
+	// without a namespace are in the default one too
+	if a.Bootstrap == nil {
+		a.Bootstrap = []string{"", namespace.DefaultNamespace}
+	}
+	if a.RefreshTokenExpiry == 0 {
+		a.RefreshTokenExpiry = DefaultRefreshTokenExpiry
+	}
+	if a.AccountLockout == (LockoutPolicy{}) {
+		a.AccountLockout = DefaultAccountLockout
+	}
+	if a.SourceLockout == (LockoutPolicy{}) {
+		a.SourceLockout = DefaultSourceLockout
+	}
+
 	// setup a token provider
 	if a.TokenProvider == nil {
 		a.TokenProvider = basic.NewTokenProvider(token.WithStore(a.Options.Store))
 	}
 }
 
-func (a *Auth) setupDefaultAccount(ns string) error {
-	a.Lock()
-	defer a.Unlock()
+// bootstrap creates the admin account of a namespace which has no user accounts, if the
+// namespace is one of those to bootstrap. Its secret has to be changed on first login.
+func (a *Auth) bootstrap(ns string) error {
+	a.namespaceLock.Lock()
+	defer a.namespaceLock.Unlock()
 
 	// setup the namespace cache if not yet done
 	if a.namespaces == nil {
 		a.namespaces = make(map[string]bool)
 	}
 
-	// check to see if the default account has already been verified
+	// check to see if the namespace has already been bootstrapped
 	if _, ok := a.namespaces[ns]; ok {
 		return nil
 	}
@@ -82,7 +129,7 @@ func (a *Auth) setupDefaultAccount(ns string) error {
 	// setup a context with the namespace
 	ctx := namespace.ContextWithNamespace(context.TODO(), ns)
 
-	// check to see if we need to create the default account
+	// check the accounts of the namespace before creating the admin account
 	key := strings.Join([]string{storePrefixAccounts, ns, ""}, joinKey)
 	recs, err := a.Options.Store.Read(key, store.ReadPrefix())
 	if err != nil {
@@ -91,30 +138,50 @@ func (a *Auth) setupDefaultAccount(ns string) error {
 
 	hasUser := false
 	for _, rec := range recs {
-		acc := &auth.Account{}
+		acc := &account{}
 		err := json.Unmarshal(rec.Value, acc)
 		if err != nil {
 			return err
 		}
+		// namespaces used to get a default account with a well known secret, which has
+		// to be changed before it can be used again
+		if acc.ID == "default" && !acc.ChangeSecret && secretsMatch(acc.Secret, "password") {
+			if err := a.requireSecretChange(ctx, acc.ID); err != nil {
+				return err
+			}
+			logger.Warnf("The default account of namespace %q still had the secret \"password\", it must be changed before the account can be used", ns)
+		}
 		if acc.Type == "user" {
 			hasUser = true
-			break
 		}
 	}
+	if !a.bootstraps(ns) {
+		a.namespaces[ns] = true
+		return nil
+	}
 
 	// create the account if none exist in the namespace
 	if !hasUser {
+		secret := a.BootstrapSecret
+		if len(secret) == 0 {
+			if secret, err = randomBase32(15); err != nil {
+				return err
+			}
+		}
 		req := &pb.GenerateRequest{
-			Id:     defaultAccount.ID,
-			Type:   defaultAccount.Type,
-			Scopes: defaultAccount.Scopes,
-			Secret: defaultAccount.Secret,
+			Id:     BootstrapAccount,
+			Type:   "user",
+			Scopes: []string{adminScope},
+			Secret: secret,
 		}
-		logger.Info("Generating default account")
-		err = a.Generate(ctx, req, &pb.GenerateResponse{})
-		if err != nil {
+		if err := a.generate(ctx, req, &pb.GenerateResponse{}, true); err != nil {
 			return err
 		}
+		if len(a.BootstrapSecret) > 0 {
+			logger.Infof("Bootstrapped namespace %q with the %s account, its secret must be changed on first login", ns, BootstrapAccount)
+		} else {
+			logger.Infof("Bootstrapped namespace %q with the %s account and the one-time secret %s, it must be changed on first login", ns, BootstrapAccount, secret)
+		}
 	}
 
 	// set the namespace in the cache
@@ -122,8 +189,46 @@ func (a *Auth) setupDefaultAccount(ns string) error {
 	return nil
 }
 
-// Generate an account
+// bootstraps returns true if a namespace is bootstrapped with an admin account
+func (a *Auth) bootstraps(ns string) bool {
+	for _, b := range a.Bootstrap {
+		if b == "*" || b == ns {
+			return true
+		}
+	}
+	return false
+}
+
+// requireSecretChange makes an account change its secret before it can get a token
+func (a *Auth) requireSecretChange(ctx context.Context, id string) error {
+	a.accountLock.Lock()
+	defer a.accountLock.Unlock()
+
+	acc, err := a.readAccount(ctx, id)
+	if err != nil {
+		return err
+	}
+	acc.ChangeSecret = true
+	return a.writeAccount(ctx, acc)
+}
+
+// Generate an account. Anyone can generate a user account with the scope of the namespace,
+// other types and scopes can only be given by admins.
 func (a *Auth) Generate(ctx context.Context, req *pb.GenerateRequest, rsp *pb.GenerateResponse) error {
+	defaultScopes := len(req.Scopes) == 0 ||
+		(len(req.Scopes) == 1 && req.Scopes[0] == "namespace."+namespace.FromContext(ctx))
+	// accounts of a provider get tokens without a secret, only admins can provision them
+	if !defaultScopes || (len(req.Type) > 0 && req.Type != "user") || len(req.Provider) > 0 {
+		if err := a.checkAdmin(ctx); err != nil {
+			return err
+		}
+	}
+	return a.generate(ctx, req, rsp, false)
+}
+
+// generate creates an account without checking the caller. If changeSecret is set the
+// secret has to be changed before the account can get a token.
+func (a *Auth) generate(ctx context.Context, req *pb.GenerateRequest, rsp *pb.GenerateResponse, changeSecret bool) error {
 	// validate the request
 	if len(req.Id) == 0 {
 		return errors.BadRequest("go.micro.auth", "ID required")
@@ -156,13 +261,17 @@ func (a *Auth) Generate(ctx context.Context, req *pb.GenerateRequest, rsp *pb.Ge
 	}
 
 	// construct the account
-	acc := &auth.Account{
-		ID:       req.Id,
-		Type:     req.Type,
-		Scopes:   req.Scopes,
-		Metadata: req.Metadata,
-		Issuer:   namespace.FromContext(ctx),
-		Secret:   secret,
+	acc := &account{
+		Account: auth.Account{
+			ID:       req.Id,
+			Type:     req.Type,
+			Scopes:   req.Scopes,
+			Metadata: req.Metadata,
+			Issuer:   namespace.FromContext(ctx),
+			Secret:   secret,
+		},
+		ChangeSecret: changeSecret,
+		Provider:     req.Provider,
 	}
 
 	// marshal to json
@@ -176,13 +285,8 @@ func (a *Auth) Generate(ctx context.Context, req *pb.GenerateRequest, rsp *pb.Ge
 		return errors.InternalServerError("go.micro.auth", "Unable to write account to store: %v", err)
 	}
 
-	// set a refresh token
-	if err := a.setRefreshToken(ctx, acc.ID, uuid.New().String()); err != nil {
-		return errors.InternalServerError("go.micro.auth", "Unable to set a refresh token: %v", err)
-	}
-
 	// return the account
-	rsp.Account = serializeAccount(acc)
+	rsp.Account = serializeAccount(&acc.Account)
 	rsp.Account.Secret = req.Secret // return unhashed secret
 	return nil
 }
@@ -196,17 +300,33 @@ func (a *Auth) Inspect(ctx context.Context, req *pb.InspectRequest, rsp *pb.Insp
 		return errors.InternalServerError("go.micro.auth", "Unable to inspect token: %v", err)
 	}
 
+	// tokens outlive the accounts they were issued to, so check it still exists and is enabled
+	key := strings.Join([]string{storePrefixAccounts, acc.Issuer, acc.ID}, joinKey)
+	recs, err := a.Options.Store.Read(key)
+	if err == store.ErrNotFound {
+		return errors.BadRequest("go.micro.auth", "Invalid token")
+	} else if err != nil {
+		return errors.InternalServerError("go.micro.auth", "Unable to read from store: %v", err)
+	}
+	var stored *account
+	if err := json.Unmarshal(recs[0].Value, &stored); err != nil {
+		return errors.InternalServerError("go.micro.auth", "Unable to unmarshal account: %v", err)
+	}
+	if stored.Disabled {
+		return errors.Forbidden("go.micro.auth", "Account is disabled")
+	}
+
 	rsp.Account = serializeAccount(acc)
 	return nil
 }
 
 // Token generation using an account ID and secret
 func (a *Auth) Token(ctx context.Context, req *pb.TokenRequest, rsp *pb.TokenResponse) error {
-	// setup the defaults incase none exist
-	err := a.setupDefaultAccount(namespace.FromContext(ctx))
+	// bootstrap the namespace incase it has no accounts
+	err := a.bootstrap(namespace.FromContext(ctx))
 	if err != nil {
 		// failing gracefully here
-		logger.Errorf("Error setting up default accounts: %v", err)
+		logger.Errorf("Error bootstrapping namespace: %v", err)
 	}
 
 	// validate the request
@@ -216,50 +336,92 @@ func (a *Auth) Token(ctx context.Context, req *pb.TokenRequest, rsp *pb.TokenRes
 
 	// Declare the account id and refresh token
 	accountID := req.Id
-	refreshToken := req.RefreshToken
+	var refreshToken string
 
-	// If the refresh token is set, check this
+	// If the refresh token is set, rotate it. A token which has already been used has
+	// leaked, so the session it belongs to is revoked.
 	if len(req.RefreshToken) > 0 {
-		accID, err := a.accountIDForRefreshToken(ctx, req.RefreshToken)
-		if err == store.ErrNotFound {
+		accID, next, err := a.rotateRefreshToken(ctx, req.RefreshToken)
+		if err == errRefreshTokenReused {
+			return errors.Unauthorized("go.micro.auth", "Refresh token has already been used, the session has been revoked")
+		} else if err == store.ErrNotFound {
 			return errors.BadRequest("go.micro.auth", "Invalid token")
 		} else if err != nil {
-			return errors.InternalServerError("go.micro.auth", "Unable to lookup token: %v", err)
+			return errors.InternalServerError("go.micro.auth", "Unable to rotate token: %v", err)
 		}
 		accountID = accID
+		refreshToken = next
+	}
+
+	// Refuse credentials while the account or the source is locked out, failures are
+	// counted for unknown accounts too so they can't be told apart
+	source := a.sourceFromContext(ctx)
+	if len(req.RefreshToken) == 0 {
+		if err := a.checkAttempts(ctx, accountID, source); err != nil {
+			return err
+		}
 	}
 
 	// Lookup the account in the store
 	key := strings.Join([]string{storePrefixAccounts, namespace.FromContext(ctx), accountID}, joinKey)
 	recs, err := a.Options.Store.Read(key)
-	if err == store.ErrNotFound {
-		return errors.BadRequest("go.micro.auth", "Account not found with this ID")
+	if err == store.ErrNotFound && len(req.RefreshToken) == 0 {
+		// unknown accounts fail like a wrong secret, in about the same time
+		checkSecret(nil, req.Secret)
+		a.recordFailure(ctx, accountID, source)
+		return errInvalidCredentials
+	} else if err == store.ErrNotFound {
+		return errors.BadRequest("go.micro.auth", "Invalid token")
 	} else if err != nil {
 		return errors.InternalServerError("go.micro.auth", "Unable to read from store: %v", err)
 	}
 
 	// Unmarshal the record
-	var acc *auth.Account
+	var acc *account
 	if err := json.Unmarshal(recs[0].Value, &acc); err != nil {
 		return errors.InternalServerError("go.micro.auth", "Unable to unmarshal account: %v", err)
 	}
 
-	// If the refresh token was not used, validate the secrets match and then set the refresh token
+	// If the refresh token was not used, validate the secrets match and then issue a refresh token
 	// so it can be returned to the user
 	if len(req.RefreshToken) == 0 {
-		if !secretsMatch(acc.Secret, req.Secret) {
-			return errors.BadRequest("go.micro.auth", "Secret not correct")
+		if !checkSecret(acc, req.Secret) {
+			a.recordFailure(ctx, acc.ID, source)
+			return errInvalidCredentials
+		}
+		if len(acc.Provider) > 0 {
+			return errors.Forbidden("go.micro.auth", "Account logs in with %s", acc.Provider)
+		}
+		if err := a.verifyMFA(ctx, acc.ID, req.Code); err != nil {
+			// a missing code isn't a failure, the client asks for one and tries again
+			if verr, ok := err.(*errors.Error); !ok || verr.Detail != MFARequired {
+				a.recordFailure(ctx, acc.ID, source)
+			}
+			return err
+		}
+		if err := a.resetFailures(ctx, acc.ID); err != nil {
+			return errors.InternalServerError("go.micro.auth", "Unable to reset failed attempts: %v", err)
+		}
+
+		if acc.Disabled {
+			return errors.Forbidden("go.micro.auth", "Account is disabled")
+		}
+		if acc.ChangeSecret {
+			return errors.Forbidden("go.micro.auth", SecretChangeRequired)
 		}
 
-		refreshToken, err = a.refreshTokenForAccount(ctx, acc.ID)
+		// every login starts a new session
+		refreshToken, err = a.issueRefreshToken(ctx, acc.ID, "")
 		if err != nil {
-			return errors.InternalServerError("go.micro.auth", "Unable to get refresh token: %v", err)
+			return errors.InternalServerError("go.micro.auth", "Unable to issue refresh token: %v", err)
 		}
+	} else if acc.Disabled {
+		return errors.Forbidden("go.micro.auth", "Account is disabled")
 	}
 
 	// Generate a new access token
 	duration := time.Duration(req.TokenExpiry) * time.Second
-	tok, err := a.TokenProvider.Generate(acc, token.WithExpiry(duration))
+	tok, err := a.TokenProvider.Generate(&acc.Account, token.WithExpiry(duration))
 	if err != nil {
 		return errors.InternalServerError("go.micro.auth", "Unable to generate token: %v", err)
 	}
@@ -268,52 +430,6 @@ func (a *Auth) Token(ctx context.Context, req *pb.TokenRequest, rsp *pb.TokenRes
 	return nil
 }
 
-// set the refresh token for an account
-func (a *Auth) setRefreshToken(ctx context.Context, id, token string) error {
-	key := strings.Join([]string{storePrefixRefreshTokens, namespace.FromContext(ctx), id, token}, joinKey)
-	return a.Options.Store.Write(&store.Record{Key: key})
-}
-
-// get the refresh token for an accutn
-func (a *Auth) refreshTokenForAccount(ctx context.Context, id string) (string, error) {
-	ns := namespace.FromContext(ctx)
-	prefix := strings.Join([]string{storePrefixRefreshTokens, ns, id, ""}, joinKey)
-
-	recs, err := a.Options.Store.Read(prefix, store.ReadPrefix())
-	if err != nil {
-		return "", err
-	} else if len(recs) == 0 {
-		return "", store.ErrNotFound
-	}
-
-	comps := strings.Split(recs[0].Key, "/")
-	if len(comps) != 4 {
-		return "", store.ErrNotFound
-	}
-	return comps[3], nil
-}
-
-// get the account ID for the given refresh token
-func (a *Auth) accountIDForRefreshToken(ctx context.Context, token string) (string, error) {
-	prefix := strings.Join([]string{storePrefixRefreshTokens, namespace.FromContext(ctx)}, joinKey)
-	keys, err := a.Options.Store.List(store.ListPrefix(prefix))
-	if err != nil {
-		return "", err
-	}
-
-	for _, k := range keys {
-		if strings.HasSuffix(k, "/"+token) {
-			comps := strings.Split(k, "/")
-			if len(comps) != 4 {
-				return "", store.ErrNotFound
-			}
-			return comps[2], nil
-		}
-	}
-
-	return "", store.ErrNotFound
-}
-
 func serializeToken(t *token.Token, refresh string) *pb.Token {
 	return &pb.Token{
 		Created:      t.Created.Unix(),
@@ -334,6 +450,23 @@ func hashSecret(s string) (string, error) {
 	return hash, nil
 }
 
+// errInvalidCredentials is returned for an unknown account and a wrong secret alike, so
+// the accounts of a namespace can't be discovered by trying to log in
+var errInvalidCredentials = errors.BadRequest("go.micro.auth", "Invalid credentials")
+
+// dummyHash is compared against when an account doesn't exist
+const dummyHash = "$2a$10$eqds4aR3PGglXpRELHg36eIN.IaPekBKgJJhKUbiE5I79XXNTolNm"
+
+// checkSecret compares a secret with the one of an account. If the account is nil it's
+// compared with a dummy hash, so unknown accounts take as long to reject as known ones.
+func checkSecret(acc *account, secret string) bool {
+	if acc == nil {
+		secretsMatch(dummyHash, secret)
+		return false
+	}
+	return secretsMatch(acc.Secret, secret)
+}
+
 func secretsMatch(hash string, s string) bool {
 	incoming := []byte(s)
 	existing := []byte(hash)
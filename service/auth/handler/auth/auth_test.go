@@ -0,0 +1,805 @@
+package auth
+
+import (
+	"context"
+	"encoding/base32"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"c-z.dev/go-micro/auth"
+	"c-z.dev/go-micro/errors"
+	"c-z.dev/go-micro/metadata"
+	"c-z.dev/go-micro/store/memory"
+	"c-z.dev/micro/internal/namespace"
+	pb "c-z.dev/micro/service/auth/proto"
+)
+
+func newTestAuth(t *testing.T) (*Auth, context.Context) {
+	a := &Auth{Open: true}
+	a.Init(auth.Store(memory.NewStore()))
+	ctx := namespace.ContextWithNamespace(context.TODO(), "test")
+
+	for _, id := range []string{"alice", "bob"} {
+		req := &pb.GenerateRequest{Id: id, Secret: "secret-" + id, Scopes: []string{"user"}}
+		if err := a.Generate(ctx, req, &pb.GenerateResponse{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return a, ctx
+}
+
+func login(a *Auth, ctx context.Context, id, secret string) (*pb.Token, error) {
+	rsp := &pb.TokenResponse{}
+	err := a.Token(ctx, &pb.TokenRequest{Id: id, Secret: secret}, rsp)
+	return rsp.Token, err
+}
+
+func refresh(a *Auth, ctx context.Context, refreshToken string) error {
+	return a.Token(ctx, &pb.TokenRequest{RefreshToken: refreshToken}, &pb.TokenResponse{})
+}
+
+func TestGenerate(t *testing.T) {
+	a := &Auth{}
+	a.Init(auth.Store(memory.NewStore()))
+	ctx := namespace.ContextWithNamespace(context.TODO(), "test")
+	forbidden := func(err error) bool {
+		verr, ok := err.(*errors.Error)
+		return ok && verr.Code == 403
+	}
+
+	// anyone can sign up with the scope of the namespace
+	if err := a.Generate(ctx, &pb.GenerateRequest{Id: "alice"}, &pb.GenerateResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	err := a.Generate(ctx, &pb.GenerateRequest{Id: "bob", Scopes: []string{"namespace.test"}}, &pb.GenerateResponse{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// other scopes and types need an admin
+	err = a.Generate(ctx, &pb.GenerateRequest{Id: "eve", Scopes: []string{adminScope}}, &pb.GenerateResponse{})
+	if !forbidden(err) {
+		t.Fatalf("Expected generating an admin to be forbidden, got %v", err)
+	}
+	err = a.Generate(ctx, &pb.GenerateRequest{Id: "eve", Type: "service"}, &pb.GenerateResponse{})
+	if !forbidden(err) {
+		t.Fatalf("Expected generating a service account to be forbidden, got %v", err)
+	}
+	admin := auth.ContextWithAccount(ctx, &auth.Account{ID: "admin", Scopes: []string{adminScope}})
+	err = a.Generate(admin, &pb.GenerateRequest{Id: "carol", Scopes: []string{adminScope}}, &pb.GenerateResponse{})
+	if err != nil {
+		t.Fatalf("Expected an admin to generate an admin, got %v", err)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	a, ctx := newTestAuth(t)
+
+	rsp := &pb.UpdateAccountResponse{}
+	err := a.Update(ctx, &pb.UpdateAccountRequest{
+		Id:       "alice",
+		Scopes:   []string{"user", "billing"},
+		Metadata: map[string]string{"team": "payments"},
+	}, rsp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rsp.Account.Scopes) != 2 || rsp.Account.Metadata["team"] != "payments" {
+		t.Fatalf("Expected the scopes and metadata to be updated, got %v", rsp.Account)
+	}
+
+	// an empty value removes the key and no scopes leaves them alone
+	err = a.Update(ctx, &pb.UpdateAccountRequest{Id: "alice", Metadata: map[string]string{"team": ""}}, rsp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rsp.Account.Scopes) != 2 || len(rsp.Account.Metadata) != 0 {
+		t.Fatalf("Expected the metadata key to be removed, got %v", rsp.Account)
+	}
+
+	// the secret is kept
+	if _, err := login(a, ctx, "alice", "secret-alice"); err != nil {
+		t.Fatalf("Expected the secret to be kept, got %v", err)
+	}
+
+	err = a.Update(ctx, &pb.UpdateAccountRequest{Id: "carol"}, rsp)
+	if verr, ok := err.(*errors.Error); !ok || verr.Code != 404 {
+		t.Fatalf("Expected a not found error, got %v", err)
+	}
+
+	// only admins can manage accounts
+	userCtx := auth.ContextWithAccount(ctx, &auth.Account{ID: "bob", Scopes: []string{"user"}})
+	err = a.Update(userCtx, &pb.UpdateAccountRequest{Id: "alice", Scopes: []string{"admin"}}, rsp)
+	if verr, ok := err.(*errors.Error); !ok || verr.Code != 403 {
+		t.Fatalf("Expected a forbidden error, got %v", err)
+	}
+
+	// calls without an account are only trusted when the service is open
+	a.Open = false
+	err = a.Update(ctx, &pb.UpdateAccountRequest{Id: "alice", Scopes: []string{"admin"}}, rsp)
+	if verr, ok := err.(*errors.Error); !ok || verr.Code != 403 {
+		t.Fatalf("Expected a forbidden error without an account, got %v", err)
+	}
+}
+
+func TestIssueToken(t *testing.T) {
+	a, ctx := newTestAuth(t)
+	greq := &pb.GenerateRequest{Id: "carol", Secret: "secret-carol", Scopes: []string{"user"}, Provider: "oidc"}
+	if err := a.Generate(ctx, greq, &pb.GenerateResponse{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// only admins can provision accounts for a provider
+	userCtx := auth.ContextWithAccount(ctx, &auth.Account{ID: "bob", Scopes: []string{"user"}})
+	err := a.Generate(userCtx, &pb.GenerateRequest{Id: "dave", Secret: "secret-dave", Provider: "oidc"}, &pb.GenerateResponse{})
+	if verr, ok := err.(*errors.Error); !ok || verr.Code != 403 {
+		t.Fatalf("Expected provisioning without the admin scope to be forbidden, got %v", err)
+	}
+
+	// accounts of a provider can't get a token with a secret
+	_, err = login(a, ctx, "carol", "secret-carol")
+	if verr, ok := err.(*errors.Error); !ok || verr.Code != 403 {
+		t.Fatalf("Expected a forbidden error, got %v", err)
+	}
+
+	rsp := &pb.IssueTokenResponse{}
+	if err := a.IssueToken(ctx, &pb.IssueTokenRequest{Id: "carol", Provider: "oidc"}, rsp); err != nil {
+		t.Fatal(err)
+	}
+	if len(rsp.Token.RefreshToken) > 0 {
+		t.Fatal("Expected no refresh token")
+	}
+	irsp := &pb.InspectResponse{}
+	if err := a.Inspect(ctx, &pb.InspectRequest{Token: rsp.Token.AccessToken}, irsp); err != nil {
+		t.Fatal(err)
+	}
+	if irsp.Account.Id != "carol" {
+		t.Fatalf("Expected a token for carol, got %v", irsp.Account)
+	}
+
+	// the provider keeps the account in step, but can't touch other accounts
+	ureq := &pb.UpdateAccountRequest{Id: "carol", Scopes: []string{"admin"}, Provider: "oidc"}
+	if err := a.Update(ctx, ureq, &pb.UpdateAccountResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	ureq.Id = "alice"
+	err = a.Update(ctx, ureq, &pb.UpdateAccountResponse{})
+	if verr, ok := err.(*errors.Error); !ok || verr.Code != 403 {
+		t.Fatalf("Expected updating another account to be forbidden, got %v", err)
+	}
+	err = a.IssueToken(ctx, &pb.IssueTokenRequest{Id: "alice", Provider: "oidc"}, rsp)
+	if verr, ok := err.(*errors.Error); !ok || verr.Code != 403 {
+		t.Fatalf("Expected a token for another account to be forbidden, got %v", err)
+	}
+
+	// only admins can issue tokens
+	err = a.IssueToken(userCtx, &pb.IssueTokenRequest{Id: "carol", Provider: "oidc"}, rsp)
+	if verr, ok := err.(*errors.Error); !ok || verr.Code != 403 {
+		t.Fatalf("Expected a forbidden error, got %v", err)
+	}
+}
+
+func TestDisable(t *testing.T) {
+	a, ctx := newTestAuth(t)
+
+	tok, err := login(a, ctx, "alice", "secret-alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Disable(ctx, &pb.DisableAccountRequest{Id: "alice"}, &pb.DisableAccountResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := login(a, ctx, "alice", "secret-alice"); err == nil {
+		t.Fatal("Expected a disabled account to be refused a token")
+	}
+	if err := refresh(a, ctx, tok.RefreshToken); err == nil {
+		t.Fatal("Expected the refresh token of a disabled account to be revoked")
+	}
+	if err := a.Inspect(ctx, &pb.InspectRequest{Token: tok.AccessToken}, &pb.InspectResponse{}); err == nil {
+		t.Fatal("Expected the access token of a disabled account to be refused")
+	}
+
+	list := &pb.ListAccountsResponse{}
+	if err := a.List(ctx, &pb.ListAccountsRequest{}, list); err != nil {
+		t.Fatal(err)
+	}
+	for _, acc := range list.Accounts {
+		if acc.Disabled != (acc.Id == "alice") {
+			t.Fatalf("Expected only alice to be disabled, got %v", acc)
+		}
+	}
+
+	if err := a.Enable(ctx, &pb.EnableAccountRequest{Id: "alice"}, &pb.EnableAccountResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	tok, err = login(a, ctx, "alice", "secret-alice")
+	if err != nil {
+		t.Fatalf("Expected an enabled account to get a token, got %v", err)
+	}
+	if err := refresh(a, ctx, tok.RefreshToken); err != nil {
+		t.Fatalf("Expected a new refresh token to be issued, got %v", err)
+	}
+
+	// accounts can't lock themselves out
+	selfCtx := auth.ContextWithAccount(ctx, &auth.Account{ID: "alice", Scopes: []string{adminScope}})
+	if err := a.Disable(selfCtx, &pb.DisableAccountRequest{Id: "alice"}, &pb.DisableAccountResponse{}); err == nil {
+		t.Fatal("Expected disabling your own account to be refused")
+	}
+}
+
+func TestDelete(t *testing.T) {
+	a, ctx := newTestAuth(t)
+
+	tok, err := login(a, ctx, "bob", "secret-bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Delete(ctx, &pb.DeleteAccountRequest{Id: "bob"}, &pb.DeleteAccountResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := login(a, ctx, "bob", "secret-bob"); err == nil {
+		t.Fatal("Expected a deleted account to be refused a token")
+	}
+	if err := refresh(a, ctx, tok.RefreshToken); err == nil {
+		t.Fatal("Expected the refresh token of a deleted account to be revoked")
+	}
+	if err := a.Inspect(ctx, &pb.InspectRequest{Token: tok.AccessToken}, &pb.InspectResponse{}); err == nil {
+		t.Fatal("Expected the access token of a deleted account to be refused")
+	}
+
+	// the other accounts are untouched
+	if _, err := login(a, ctx, "alice", "secret-alice"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChangeSecret(t *testing.T) {
+	a, ctx := newTestAuth(t)
+
+	tok, err := login(a, ctx, "alice", "secret-alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// users have to provide their current secret
+	userCtx := auth.ContextWithAccount(ctx, &auth.Account{ID: "alice", Scopes: []string{"user"}})
+	err = a.ChangeSecret(userCtx, &pb.ChangeSecretRequest{Id: "alice", OldSecret: "wrong", NewSecret: "new"}, &pb.ChangeSecretResponse{})
+	if err == nil {
+		t.Fatal("Expected the wrong secret to be refused")
+	}
+	err = a.ChangeSecret(userCtx, &pb.ChangeSecretRequest{Id: "alice", OldSecret: "secret-alice", NewSecret: "new"}, &pb.ChangeSecretResponse{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := login(a, ctx, "alice", "secret-alice"); err == nil {
+		t.Fatal("Expected the old secret to be refused")
+	}
+	if err := refresh(a, ctx, tok.RefreshToken); err == nil {
+		t.Fatal("Expected the refresh tokens to be revoked")
+	}
+	if _, err := login(a, ctx, "alice", "new"); err != nil {
+		t.Fatalf("Expected the new secret to be accepted, got %v", err)
+	}
+
+	// admins don't need the current secret
+	adminCtx := auth.ContextWithAccount(ctx, &auth.Account{ID: "bob", Scopes: []string{adminScope}})
+	err = a.ChangeSecret(adminCtx, &pb.ChangeSecretRequest{Id: "alice", NewSecret: "reset"}, &pb.ChangeSecretResponse{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := login(a, ctx, "alice", "reset"); err != nil {
+		t.Fatalf("Expected the reset secret to be accepted, got %v", err)
+	}
+}
+
+func TestRefreshTokens(t *testing.T) {
+	a, ctx := newTestAuth(t)
+
+	tok, err := login(a, ctx, "alice", "secret-alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := login(a, ctx, "alice", "secret-alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.RefreshToken == other.RefreshToken {
+		t.Fatal("Expected every login to get its own refresh token")
+	}
+
+	// every use rotates the token
+	rsp := &pb.TokenResponse{}
+	if err := a.Token(ctx, &pb.TokenRequest{RefreshToken: tok.RefreshToken}, rsp); err != nil {
+		t.Fatal(err)
+	}
+	next := rsp.Token.RefreshToken
+	if next == tok.RefreshToken {
+		t.Fatal("Expected the refresh token to be rotated")
+	}
+	if err := refresh(a, ctx, next); err != nil {
+		t.Fatalf("Expected the rotated token to be usable, got %v", err)
+	}
+
+	// reusing a token revokes the whole session but not the others
+	if err := a.Token(ctx, &pb.TokenRequest{RefreshToken: other.RefreshToken}, rsp); err != nil {
+		t.Fatal(err)
+	}
+	err = refresh(a, ctx, other.RefreshToken)
+	if verr, ok := err.(*errors.Error); !ok || verr.Code != 401 {
+		t.Fatalf("Expected reuse to be refused, got %v", err)
+	}
+	if err := refresh(a, ctx, rsp.Token.RefreshToken); err == nil {
+		t.Fatal("Expected the session to be revoked after reuse")
+	}
+
+	// logout revokes the session
+	tok, err = login(a, ctx, "alice", "secret-alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Logout(ctx, &pb.LogoutRequest{RefreshToken: tok.RefreshToken}, &pb.LogoutResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := refresh(a, ctx, tok.RefreshToken); err == nil {
+		t.Fatal("Expected the refresh token to be revoked on logout")
+	}
+
+	// logging out of everything needs an account
+	tok, err = login(a, ctx, "alice", "secret-alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Logout(ctx, &pb.LogoutRequest{All: true}, &pb.LogoutResponse{}); err == nil {
+		t.Fatal("Expected logging out of every session without an account to be refused")
+	}
+	accCtx := auth.ContextWithAccount(ctx, &auth.Account{ID: "alice"})
+	if err := a.Logout(accCtx, &pb.LogoutRequest{All: true}, &pb.LogoutResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := refresh(a, ctx, tok.RefreshToken); err == nil {
+		t.Fatal("Expected every refresh token to be revoked")
+	}
+}
+
+func TestRefreshTokenExpiry(t *testing.T) {
+	a, ctx := newTestAuth(t)
+	a.RefreshTokenExpiry = time.Millisecond * 50
+
+	tok, err := login(a, ctx, "bob", "secret-bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if err := refresh(a, ctx, tok.RefreshToken); err == nil {
+		t.Fatal("Expected an expired refresh token to be refused")
+	}
+}
+
+func TestLockout(t *testing.T) {
+	a, ctx := newTestAuth(t)
+	a.AccountLockout = LockoutPolicy{Free: 2, Backoff: time.Hour, Threshold: 4, Lockout: time.Hour, Window: time.Hour}
+	a.SourceLockout = LockoutPolicy{Free: 3, Backoff: time.Hour, Threshold: 5, Lockout: time.Hour, Window: time.Hour}
+	srcCtx := metadata.Set(ctx, "Remote", "10.0.0.1:5000")
+
+	// the free failures are refused for the wrong secret
+	for i := 0; i < 3; i++ {
+		_, err := login(a, srcCtx, "alice", "wrong")
+		if verr, ok := err.(*errors.Error); !ok || verr.Code != 400 {
+			t.Fatalf("Expected a bad request for failure %d, got %v", i, err)
+		}
+	}
+
+	// then the account backs off, even with the right secret
+	_, err := login(a, ctx, "alice", "secret-alice")
+	if verr, ok := err.(*errors.Error); !ok || verr.Code != 429 {
+		t.Fatalf("Expected the account to back off, got %v", err)
+	}
+
+	// other accounts from the same source are fine until the source backs off too
+	if _, err := login(a, srcCtx, "bob", "wrong"); err == nil {
+		t.Fatal("Expected the wrong secret to be refused")
+	}
+	_, err = login(a, srcCtx, "bob", "secret-bob")
+	if verr, ok := err.(*errors.Error); !ok || verr.Code != 429 {
+		t.Fatalf("Expected the source to back off, got %v", err)
+	}
+	if _, err := login(a, ctx, "bob", "secret-bob"); err != nil {
+		t.Fatalf("Expected bob to get a token from another source, got %v", err)
+	}
+
+	// unknown accounts are counted the same way
+	for i := 0; i < 3; i++ {
+		login(a, ctx, "carol", "wrong")
+	}
+	_, err = login(a, ctx, "carol", "wrong")
+	if verr, ok := err.(*errors.Error); !ok || verr.Code != 429 {
+		t.Fatalf("Expected an unknown account to back off, got %v", err)
+	}
+
+	// admins can unlock accounts and sources
+	userCtx := auth.ContextWithAccount(ctx, &auth.Account{ID: "bob", Scopes: []string{"user"}})
+	if err := a.Unlock(userCtx, &pb.UnlockAccountRequest{Id: "alice"}, &pb.UnlockAccountResponse{}); err == nil {
+		t.Fatal("Expected unlocking to require the admin scope")
+	}
+	if err := a.Unlock(ctx, &pb.UnlockAccountRequest{Id: "alice", Source: "10.0.0.1"}, &pb.UnlockAccountResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := login(a, srcCtx, "alice", "secret-alice"); err != nil {
+		t.Fatalf("Expected alice to be unlocked, got %v", err)
+	}
+}
+
+func TestRetryAt(t *testing.T) {
+	p := LockoutPolicy{Free: 2, Backoff: time.Second, Threshold: 6, Lockout: time.Minute, Window: time.Hour}
+	now := time.Now()
+
+	tt := []struct {
+		failures int
+		wait     time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, time.Second * 2},
+		{5, time.Second * 4},
+		{6, time.Minute},
+		{10, time.Minute},
+	}
+	for _, tc := range tt {
+		at := &attempts{Failures: tc.failures, LastFailure: now}
+		var want time.Time
+		if tc.wait > 0 {
+			want = now.Add(tc.wait)
+		}
+		if got := at.retryAt(p); !got.Equal(want) {
+			t.Errorf("Expected %d failures to wait %v, got %v", tc.failures, tc.wait, got.Sub(now))
+		}
+	}
+}
+
+func TestSourceFromContext(t *testing.T) {
+	a := &Auth{}
+	ctx := metadata.NewContext(context.TODO(), metadata.Metadata{
+		"Remote":          "10.0.0.1:1234",
+		"X-Forwarded-For": "192.0.2.1, 10.0.0.2",
+	})
+
+	tt := map[string]struct {
+		account *auth.Account
+		source  string
+	}{
+		"anonymous": {nil, "10.0.0.1"},
+		"user":      {&auth.Account{ID: "alice", Type: "user"}, "10.0.0.1"},
+		"service":   {&auth.Account{ID: "web", Type: "service"}, "192.0.2.1"},
+	}
+	for name, tc := range tt {
+		ctx := ctx
+		if tc.account != nil {
+			ctx = auth.ContextWithAccount(ctx, tc.account)
+		}
+		if got := a.sourceFromContext(ctx); got != tc.source {
+			t.Errorf("Expected the source of a %s caller to be %s, got %s", name, tc.source, got)
+		}
+	}
+}
+
+func TestUnknownAccount(t *testing.T) {
+	a, ctx := newTestAuth(t)
+	_, unknown := login(a, ctx, "nobody", "secret")
+	_, wrong := login(a, ctx, "alice", "wrong")
+	if unknown == nil || wrong == nil || unknown.Error() != wrong.Error() {
+		t.Errorf("Expected an unknown account and a wrong secret to fail alike, got %v and %v", unknown, wrong)
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	// the SHA1 vectors of RFC 6238, truncated to 6 digits
+	secret := []byte("12345678901234567890")
+	tt := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, code := range tt {
+		if got := totp(secret, unix/totpPeriod); got != code {
+			t.Errorf("Expected %v at %v, got %v", code, unix, got)
+		}
+	}
+}
+
+func TestMFA(t *testing.T) {
+	a, ctx := newTestAuth(t)
+	aliceCtx := auth.ContextWithAccount(ctx, &auth.Account{ID: "alice", Scopes: []string{"user"}})
+
+	// accounts can only enrol themselves unless they're an admin
+	err := a.EnrolMFA(aliceCtx, &pb.EnrolMFARequest{Id: "bob"}, &pb.EnrolMFAResponse{})
+	if verr, ok := err.(*errors.Error); !ok || verr.Code != 403 {
+		t.Fatalf("Expected enrolling another account to be forbidden, got %v", err)
+	}
+
+	enrol := &pb.EnrolMFAResponse{}
+	if err := a.EnrolMFA(aliceCtx, &pb.EnrolMFARequest{Id: "alice"}, enrol); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(enrol.Uri, "otpauth://totp/test:alice?secret="+enrol.Secret) {
+		t.Fatalf("Unexpected URI %v", enrol.Uri)
+	}
+	if len(enrol.RecoveryCodes) != recoveryCodes {
+		t.Fatalf("Expected %v recovery codes, got %v", recoveryCodes, len(enrol.RecoveryCodes))
+	}
+
+	// codes aren't needed until the enrolment is confirmed
+	if _, err := login(a, ctx, "alice", "secret-alice"); err != nil {
+		t.Fatalf("Expected a pending enrolment not to need a code, got %v", err)
+	}
+	secret, _ := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrol.Secret)
+	step := time.Now().Unix() / totpPeriod
+	if err := a.EnrolMFA(aliceCtx, &pb.EnrolMFARequest{Id: "alice", Code: "000000x"}, &pb.EnrolMFAResponse{}); err == nil {
+		t.Fatal("Expected the wrong code to be refused")
+	}
+	if err := a.EnrolMFA(aliceCtx, &pb.EnrolMFARequest{Id: "alice", Code: totp(secret, step-1)}, &pb.EnrolMFAResponse{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// then a code is required
+	_, err = login(a, ctx, "alice", "secret-alice")
+	if verr, ok := err.(*errors.Error); !ok || verr.Detail != MFARequired {
+		t.Fatalf("Expected a code to be required, got %v", err)
+	}
+	rsp := &pb.TokenResponse{}
+	if err := a.Token(ctx, &pb.TokenRequest{Id: "alice", Secret: "secret-alice", Code: totp(secret, step)}, rsp); err != nil {
+		t.Fatalf("Expected the code to be accepted, got %v", err)
+	}
+
+	// codes can't be replayed
+	err = a.Token(ctx, &pb.TokenRequest{Id: "alice", Secret: "secret-alice", Code: totp(secret, step)}, rsp)
+	if err == nil {
+		t.Fatal("Expected a code to be single use")
+	}
+
+	// refreshing doesn't need a code
+	if err := refresh(a, ctx, rsp.Token.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+
+	// recovery codes work once each
+	code := strings.ToUpper(enrol.RecoveryCodes[0])
+	if err := a.Token(ctx, &pb.TokenRequest{Id: "alice", Secret: "secret-alice", Code: code}, rsp); err != nil {
+		t.Fatalf("Expected the recovery code to be accepted, got %v", err)
+	}
+	if err := a.Token(ctx, &pb.TokenRequest{Id: "alice", Secret: "secret-alice", Code: code}, rsp); err == nil {
+		t.Fatal("Expected a recovery code to be single use")
+	}
+
+	// so does changing the secret
+	creq := &pb.ChangeSecretRequest{Id: "alice", OldSecret: "secret-alice", NewSecret: "new"}
+	err = a.ChangeSecret(aliceCtx, creq, &pb.ChangeSecretResponse{})
+	if verr, ok := err.(*errors.Error); !ok || verr.Detail != MFARequired {
+		t.Fatalf("Expected a code to be required to change the secret, got %v", err)
+	}
+	creq.Code = enrol.RecoveryCodes[1]
+	if err := a.ChangeSecret(aliceCtx, creq, &pb.ChangeSecretResponse{}); err != nil {
+		t.Fatalf("Expected the secret to be changed with a code, got %v", err)
+	}
+
+	// replacing the enrolment needs a code of the current one, unless an admin does it
+	err = a.EnrolMFA(aliceCtx, &pb.EnrolMFARequest{Id: "alice"}, &pb.EnrolMFAResponse{})
+	if verr, ok := err.(*errors.Error); !ok || verr.Detail != MFARequired {
+		t.Fatalf("Expected a code to be required to replace the enrolment, got %v", err)
+	}
+	err = a.EnrolMFA(aliceCtx, &pb.EnrolMFARequest{Id: "alice", CurrentCode: "000000"}, &pb.EnrolMFAResponse{})
+	if err == nil {
+		t.Fatal("Expected the wrong code to be refused")
+	}
+	ereq := &pb.EnrolMFARequest{Id: "alice", CurrentCode: enrol.RecoveryCodes[2]}
+	if err := a.EnrolMFA(aliceCtx, ereq, &pb.EnrolMFAResponse{}); err != nil {
+		t.Fatalf("Expected a recovery code to allow replacing the enrolment, got %v", err)
+	}
+	adminCtx := auth.ContextWithAccount(ctx, &auth.Account{ID: "bob", Scopes: []string{adminScope}})
+	if err := a.EnrolMFA(adminCtx, &pb.EnrolMFARequest{Id: "alice"}, &pb.EnrolMFAResponse{}); err != nil {
+		t.Fatalf("Expected an admin to enrol another account without a code, got %v", err)
+	}
+
+	// service accounts can't enrol
+	if err := a.Generate(ctx, &pb.GenerateRequest{Id: "svc", Type: "service"}, &pb.GenerateResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.EnrolMFA(ctx, &pb.EnrolMFARequest{Id: "svc"}, &pb.EnrolMFAResponse{}); err == nil {
+		t.Fatal("Expected a service account to be refused")
+	}
+}
+
+func TestBootstrap(t *testing.T) {
+	a := &Auth{Bootstrap: []string{"bootstrap"}, BootstrapSecret: "one-time"}
+	a.Init(auth.Store(memory.NewStore()))
+	ctx := namespace.ContextWithNamespace(context.TODO(), "bootstrap")
+
+	// the secret has to be changed before getting a token
+	_, err := login(a, ctx, BootstrapAccount, "one-time")
+	if verr, ok := err.(*errors.Error); !ok || verr.Code != 403 || verr.Detail != SecretChangeRequired {
+		t.Fatalf("Expected the secret to need changing, got %v", err)
+	}
+	req := &pb.ChangeSecretRequest{Id: BootstrapAccount, OldSecret: "one-time", NewSecret: "changed"}
+	if err := a.ChangeSecret(ctx, req, &pb.ChangeSecretResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := login(a, ctx, BootstrapAccount, "changed"); err != nil {
+		t.Fatalf("Expected a token once the secret is changed, got %v", err)
+	}
+
+	// other namespaces don't get an admin account
+	otherCtx := namespace.ContextWithNamespace(context.TODO(), "other")
+	rsp := &pb.ListAccountsResponse{}
+	if err := a.List(otherCtx, &pb.ListAccountsRequest{}, rsp); err != nil {
+		t.Fatal(err)
+	}
+	if len(rsp.Accounts) != 0 {
+		t.Fatalf("Expected no accounts in a namespace which isn't bootstrapped, got %v", rsp.Accounts)
+	}
+	if _, err := login(a, otherCtx, "default", "password"); err == nil {
+		t.Fatal("Expected the default account not to exist")
+	}
+
+	// legacy default accounts have to change their well known secret
+	st := memory.NewStore()
+	legacy := &Auth{}
+	legacy.Init(auth.Store(st))
+	legacyCtx := namespace.ContextWithNamespace(context.TODO(), "legacy")
+	greq := &pb.GenerateRequest{Id: "default", Type: "user", Secret: "password"}
+	if err := legacy.generate(legacyCtx, greq, &pb.GenerateResponse{}, false); err != nil {
+		t.Fatal(err)
+	}
+	a = &Auth{}
+	a.Init(auth.Store(st))
+	_, err = login(a, legacyCtx, "default", "password")
+	if verr, ok := err.(*errors.Error); !ok || verr.Code != 403 || verr.Detail != SecretChangeRequired {
+		t.Fatalf("Expected the legacy secret to need changing, got %v", err)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	a, ctx := newTestAuth(t)
+	k := &Keys{Auth: a}
+	aliceCtx := auth.ContextWithAccount(ctx, &auth.Account{ID: "alice", Scopes: []string{"user"}})
+
+	exchange := func(key, source string) (*auth.Account, error) {
+		rsp := &pb.KeyTokenResponse{}
+		if err := k.Token(ctx, &pb.KeyTokenRequest{Key: key, Source: source}, rsp); err != nil {
+			return nil, err
+		}
+		irsp := &pb.InspectResponse{}
+		if err := a.Inspect(ctx, &pb.InspectRequest{Token: rsp.Token.AccessToken}, irsp); err != nil {
+			return nil, err
+		}
+		return &auth.Account{ID: irsp.Account.Id, Scopes: irsp.Account.Scopes, Metadata: irsp.Account.Metadata}, nil
+	}
+
+	// keys are limited to the scopes of the account
+	err := k.Create(aliceCtx, &pb.CreateKeyRequest{Name: "ci", Scopes: []string{"admin"}}, &pb.CreateKeyResponse{})
+	if verr, ok := err.(*errors.Error); !ok || verr.Code != 400 {
+		t.Fatalf("Expected a scope the account doesn't have to be refused, got %v", err)
+	}
+
+	rsp := &pb.CreateKeyResponse{}
+	req := &pb.CreateKeyRequest{Name: "ci", Scopes: []string{"user"}, AllowedIps: []string{"10.0.0.0/8", "192.168.1.1"}}
+	if err := k.Create(aliceCtx, req, rsp); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(rsp.Secret, KeyPrefix+rsp.Key.Id+"_") || rsp.Key.Account != "alice" {
+		t.Fatalf("Expected a key for alice identified by its prefix, got %v %v", rsp.Secret, rsp.Key)
+	}
+	id, secret := rsp.Key.Id, rsp.Secret
+
+	// the key is exchanged for a token from the allowed addresses
+	acc, err := exchange(secret, "10.1.2.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acc.ID != "alice" || len(acc.Scopes) != 1 || acc.Metadata["api_key"] != rsp.Key.Id {
+		t.Fatalf("Expected a token for alice with the key's scopes, got %v", acc)
+	}
+	_, err = exchange(secret, "172.16.0.1")
+	if verr, ok := err.(*errors.Error); !ok || verr.Code != 403 {
+		t.Fatalf("Expected an address outside the allowlist to be refused, got %v", err)
+	}
+	_, err = exchange(secret+"x", "10.1.2.3")
+	if verr, ok := err.(*errors.Error); !ok || verr.Code != 401 {
+		t.Fatalf("Expected a wrong key to be refused, got %v", err)
+	}
+
+	// the key is only stored hashed and its last use is shown
+	recs, _ := a.Options.Store.Read(keyStoreKey(ctx, rsp.Key.Id))
+	if len(recs) != 1 || strings.Contains(string(recs[0].Value), secret) {
+		t.Fatal("Expected the key to be stored hashed")
+	}
+	lrsp := &pb.ListKeysResponse{}
+	if err := k.List(aliceCtx, &pb.ListKeysRequest{}, lrsp); err != nil {
+		t.Fatal(err)
+	}
+	if len(lrsp.Keys) != 1 || lrsp.Keys[0].LastUsed == 0 {
+		t.Fatalf("Expected the key to have been used, got %v", lrsp.Keys)
+	}
+
+	// other users can't see or revoke the key
+	bobCtx := auth.ContextWithAccount(ctx, &auth.Account{ID: "bob", Scopes: []string{"user"}})
+	if err := k.List(bobCtx, &pb.ListKeysRequest{}, lrsp); err != nil || len(lrsp.Keys) != 0 {
+		t.Fatalf("Expected bob to have no keys, got %v %v", lrsp.Keys, err)
+	}
+	err = k.Revoke(bobCtx, &pb.RevokeKeyRequest{Id: rsp.Key.Id}, &pb.RevokeKeyResponse{})
+	if verr, ok := err.(*errors.Error); !ok || verr.Code != 403 {
+		t.Fatalf("Expected bob not to be able to revoke the key, got %v", err)
+	}
+
+	// expired keys are refused
+	if err := k.Create(aliceCtx, &pb.CreateKeyRequest{Name: "expiring", Expiry: 1}, rsp); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exchange(rsp.Secret, ""); err != nil {
+		t.Fatalf("Expected a key without an allowlist to be usable from anywhere, got %v", err)
+	}
+	key, _ := k.readKey(ctx, rsp.Key.Id)
+	key.Expiry = time.Now().Add(-time.Second)
+	k.writeKey(ctx, key)
+	if _, err := exchange(rsp.Secret, ""); err == nil {
+		t.Fatal("Expected an expired key to be refused")
+	}
+
+	// revoked keys and the keys of deleted accounts are refused
+	if err := k.Revoke(aliceCtx, &pb.RevokeKeyRequest{Id: id}, &pb.RevokeKeyResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exchange(secret, "10.1.2.3"); err == nil {
+		t.Fatal("Expected a revoked key to be refused")
+	}
+	if err := k.Create(aliceCtx, &pb.CreateKeyRequest{Name: "deleted"}, rsp); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Delete(ctx, &pb.DeleteAccountRequest{Id: "alice"}, &pb.DeleteAccountResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exchange(rsp.Secret, ""); err == nil {
+		t.Fatal("Expected the key of a deleted account to be refused")
+	}
+	if err := k.List(ctx, &pb.ListKeysRequest{}, lrsp); err != nil || len(lrsp.Keys) != 0 {
+		t.Fatalf("Expected the keys to be revoked, got %v %v", lrsp.Keys, err)
+	}
+}
+
+func TestKeysRevokeWhileExchanged(t *testing.T) {
+	a, ctx := newTestAuth(t)
+	k := &Keys{Auth: a}
+	aliceCtx := auth.ContextWithAccount(ctx, &auth.Account{ID: "alice", Scopes: []string{"user"}})
+
+	// the first exchange of a key records its last use, which mustn't write a revoked key back
+	for i := 0; i < 50; i++ {
+		rsp := &pb.CreateKeyResponse{}
+		if err := k.Create(aliceCtx, &pb.CreateKeyRequest{Name: "ci"}, rsp); err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			k.Token(ctx, &pb.KeyTokenRequest{Key: rsp.Secret}, &pb.KeyTokenResponse{})
+		}()
+		go func() {
+			defer wg.Done()
+			if err := k.Revoke(aliceCtx, &pb.RevokeKeyRequest{Id: rsp.Key.Id}, &pb.RevokeKeyResponse{}); err != nil {
+				t.Error(err)
+			}
+		}()
+		wg.Wait()
+
+		if err := k.Token(ctx, &pb.KeyTokenRequest{Key: rsp.Secret}, &pb.KeyTokenResponse{}); err == nil {
+			t.Fatal("Expected a key revoked while it was exchanged to be refused")
+		}
+	}
+}
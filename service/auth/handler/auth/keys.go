@@ -0,0 +1,459 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"c-z.dev/go-micro/auth"
+	"c-z.dev/go-micro/auth/token"
+	"c-z.dev/go-micro/errors"
+	"c-z.dev/go-micro/logger"
+	"c-z.dev/go-micro/metadata"
+	"c-z.dev/go-micro/store"
+	"c-z.dev/micro/internal/namespace"
+	pb "c-z.dev/micro/service/auth/proto"
+)
+
+const (
+	storePrefixKeys = "apikey"
+
+	// KeyPrefix starts every API key so they can be told apart from tokens and found by
+	// secret scanners
+	KeyPrefix = "micro_"
+	// KeyTokenExpiry is how long the access tokens API keys are exchanged for last, a
+	// revoked key can be used until its last token expires
+	KeyTokenExpiry = time.Minute * 5
+
+	// lastUsedResolution is how often the last use of a key is written
+	lastUsedResolution = time.Minute
+	// keyIDAttempts is how many random IDs a new key tries before giving up
+	keyIDAttempts = 5
+)
+
+// apiKey is an API key as it's stored at apikey/<ns>/<id>. Keys are random so a hash
+// which is quick to check is enough, bcrypt would slow down every exchange.
+type apiKey struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	AccountID  string    `json:"account_id"`
+	Hash       string    `json:"hash"`
+	Scopes     []string  `json:"scopes,omitempty"`
+	AllowedIPs []string  `json:"allowed_ips,omitempty"`
+	Created    time.Time `json:"created"`
+	Expiry     time.Time `json:"expiry"`
+	LastUsed   time.Time `json:"last_used"`
+}
+
+// Keys processes the RPC calls of the Keys service, using the accounts of the auth
+type Keys struct {
+	Auth *Auth
+}
+
+func keyStoreKey(ctx context.Context, id string) string {
+	return strings.Join([]string{storePrefixKeys, namespace.FromContext(ctx), id}, joinKey)
+}
+
+// Create an API key for an account
+func (k *Keys) Create(ctx context.Context, req *pb.CreateKeyRequest, rsp *pb.CreateKeyResponse) error {
+	if len(req.Name) == 0 {
+		return errors.BadRequest("go.micro.auth", "Name required")
+	}
+	if req.Expiry < 0 {
+		return errors.BadRequest("go.micro.auth", "Expiry can't be negative")
+	}
+	for _, ip := range req.AllowedIps {
+		if _, err := parseAllowedIP(ip); err != nil {
+			return errors.BadRequest("go.micro.auth", "Invalid allowed IP %s, must be an address or a CIDR range", ip)
+		}
+	}
+
+	id, err := k.checkOwner(ctx, req.Account)
+	if err != nil {
+		return err
+	}
+	acc, err := k.Auth.readAccount(ctx, id)
+	if err != nil {
+		return err
+	}
+	if acc.Disabled {
+		return errors.BadRequest("go.micro.auth", "Account is disabled")
+	}
+	for _, s := range req.Scopes {
+		if !include(acc.Scopes, s) {
+			return errors.BadRequest("go.micro.auth", "Scope %s isn't one of the account's", s)
+		}
+	}
+
+	// the ID is held until the key is written so no other key can take it
+	k.Auth.keyLock.Lock()
+	defer k.Auth.keyLock.Unlock()
+
+	prefix, err := k.newKeyID(ctx)
+	if err != nil {
+		return err
+	}
+	secret, err := randomBase32(20)
+	if err != nil {
+		return errors.InternalServerError("go.micro.auth", "Unable to generate key: %v", err)
+	}
+	secret = KeyPrefix + prefix + "_" + strings.ToLower(secret)
+
+	key := &apiKey{
+		ID:         prefix,
+		Name:       req.Name,
+		AccountID:  acc.ID,
+		Hash:       hashKey(secret),
+		Scopes:     req.Scopes,
+		AllowedIPs: req.AllowedIps,
+		Created:    time.Now(),
+	}
+	if req.Expiry > 0 {
+		key.Expiry = key.Created.Add(time.Duration(req.Expiry) * time.Second)
+	}
+	if err := k.writeKey(ctx, key); err != nil {
+		return errors.InternalServerError("go.micro.auth", "Unable to write key to store: %v", err)
+	}
+
+	rsp.Key = serializeKey(key)
+	rsp.Secret = secret
+	return nil
+}
+
+// List the API keys of an account, or every key for admins
+func (k *Keys) List(ctx context.Context, req *pb.ListKeysRequest, rsp *pb.ListKeysResponse) error {
+	// everyone can list their own keys, admins can list any
+	id := req.Account
+	if len(id) > 0 || k.Auth.checkAdmin(ctx) != nil {
+		var err error
+		if id, err = k.checkOwner(ctx, id); err != nil {
+			return err
+		}
+	}
+
+	prefix := strings.Join([]string{storePrefixKeys, namespace.FromContext(ctx), ""}, joinKey)
+	recs, err := k.Auth.Options.Store.Read(prefix, store.ReadPrefix())
+	if err != nil {
+		return errors.InternalServerError("go.micro.auth", "Unable to read from store: %v", err)
+	}
+
+	rsp.Keys = make([]*pb.Key, 0, len(recs))
+	for _, rec := range recs {
+		var key *apiKey
+		if err := json.Unmarshal(rec.Value, &key); err != nil {
+			return errors.InternalServerError("go.micro.auth", "Unable to unmarshal key: %v", err)
+		}
+		if len(id) > 0 && key.AccountID != id {
+			continue
+		}
+		rsp.Keys = append(rsp.Keys, serializeKey(key))
+	}
+	sort.Slice(rsp.Keys, func(i, j int) bool { return rsp.Keys[i].Created < rsp.Keys[j].Created })
+	return nil
+}
+
+// Revoke an API key, the access tokens it has been exchanged for last until they expire
+func (k *Keys) Revoke(ctx context.Context, req *pb.RevokeKeyRequest, rsp *pb.RevokeKeyResponse) error {
+	// held so an exchange recording the key's last use can't write it back
+	k.Auth.keyLock.Lock()
+	defer k.Auth.keyLock.Unlock()
+
+	key, err := k.readKey(ctx, req.Id)
+	if err == store.ErrNotFound {
+		return errors.NotFound("go.micro.auth", "Key not found with this ID")
+	} else if err != nil {
+		return errors.InternalServerError("go.micro.auth", "Unable to read from store: %v", err)
+	}
+	if _, err := k.checkOwner(ctx, key.AccountID); err != nil {
+		return err
+	}
+
+	if err := k.Auth.Options.Store.Delete(keyStoreKey(ctx, key.ID)); err != nil {
+		return errors.InternalServerError("go.micro.auth", "Unable to delete key from store: %v", err)
+	}
+	return nil
+}
+
+// Token exchanges an API key for an access token of its account, limited to the scopes of
+// the key. No refresh token is issued, the key is exchanged again once the token expires.
+func (k *Keys) Token(ctx context.Context, req *pb.KeyTokenRequest, rsp *pb.KeyTokenResponse) error {
+	invalid := errors.Unauthorized("go.micro.auth", "Invalid API key")
+
+	id, ok := keyID(req.Key)
+	if !ok {
+		return invalid
+	}
+	key, err := k.readKey(ctx, id)
+	if err == store.ErrNotFound {
+		return invalid
+	} else if err != nil {
+		return errors.InternalServerError("go.micro.auth", "Unable to read from store: %v", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashKey(req.Key))) != 1 {
+		return invalid
+	}
+	if !key.Expiry.IsZero() && time.Now().After(key.Expiry) {
+		return errors.Unauthorized("go.micro.auth", "API key has expired")
+	}
+	source := k.keySource(ctx, req.Source)
+	if len(key.AllowedIPs) > 0 && !allowedIP(key.AllowedIPs, source) {
+		return errors.Forbidden("go.micro.auth", "API key can't be used from %s", source)
+	}
+
+	acc, err := k.Auth.readAccount(ctx, key.AccountID)
+	if verr, ok := err.(*errors.Error); ok && verr.Code == 404 {
+		return invalid
+	} else if err != nil {
+		return err
+	}
+	if acc.Disabled {
+		return errors.Forbidden("go.micro.auth", "Account is disabled")
+	}
+
+	// the account's scopes may have been reduced since the key was created
+	scopes := acc.Scopes
+	if len(key.Scopes) > 0 {
+		scopes = nil
+		for _, s := range key.Scopes {
+			if include(acc.Scopes, s) {
+				scopes = append(scopes, s)
+			}
+		}
+	}
+	md := make(map[string]string, len(acc.Metadata)+1)
+	for name, val := range acc.Metadata {
+		md[name] = val
+	}
+	md["api_key"] = key.ID
+
+	tok, err := k.Auth.TokenProvider.Generate(&auth.Account{
+		ID:       acc.ID,
+		Type:     acc.Type,
+		Issuer:   acc.Issuer,
+		Scopes:   scopes,
+		Metadata: md,
+	}, token.WithExpiry(KeyTokenExpiry))
+	if err != nil {
+		return errors.InternalServerError("go.micro.auth", "Unable to generate token: %v", err)
+	}
+
+	if time.Since(key.LastUsed) > lastUsedResolution {
+		k.touchKey(ctx, key.ID)
+	}
+
+	rsp.Token = serializeToken(tok, "")
+	return nil
+}
+
+// touchKey sets the last use of a key, failing to isn't worth failing the exchange over
+func (k *Keys) touchKey(ctx context.Context, id string) {
+	k.Auth.keyLock.Lock()
+	defer k.Auth.keyLock.Unlock()
+
+	// the key may have been revoked since it was read
+	key, err := k.readKey(ctx, id)
+	if err == store.ErrNotFound {
+		return
+	} else if err != nil {
+		logger.Errorf("Error reading key %s: %v", id, err)
+		return
+	}
+	key.LastUsed = time.Now()
+	if err := k.writeKey(ctx, key); err != nil {
+		logger.Errorf("Error writing key %s: %v", id, err)
+	}
+}
+
+// checkOwner returns the account whose keys are being managed, the caller's unless another
+// is given. Managing the keys of another account requires the admin scope.
+func (k *Keys) checkOwner(ctx context.Context, id string) (string, error) {
+	caller, ok := callerID(ctx)
+	if len(id) == 0 {
+		if !ok {
+			return "", errors.BadRequest("go.micro.auth", "Account required")
+		}
+		return caller, nil
+	}
+	if ok && id == caller {
+		return id, nil
+	}
+	if err := k.Auth.checkAdmin(ctx); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// keySource returns the address an API key is used from. Gateways exchanging keys for
+// their callers pass the caller's address, which is only trusted from service accounts so
+// a key can't be taken outside its allowlist by calling the auth service directly.
+func (k *Keys) keySource(ctx context.Context, source string) string {
+	if len(source) > 0 && k.Auth.trustsForwarding(ctx) {
+		return source
+	}
+	remote, _ := metadata.Get(ctx, "Remote")
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		return host
+	}
+	return remote
+}
+
+// revokeKeys revokes every API key of an account, so they can't be used by another
+// account created with its ID
+func (a *Auth) revokeKeys(ctx context.Context, id string) error {
+	a.keyLock.Lock()
+	defer a.keyLock.Unlock()
+
+	prefix := strings.Join([]string{storePrefixKeys, namespace.FromContext(ctx), ""}, joinKey)
+	recs, err := a.Options.Store.Read(prefix, store.ReadPrefix())
+	if err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		var key *apiKey
+		if err := json.Unmarshal(rec.Value, &key); err != nil || key.AccountID != id {
+			continue
+		}
+		if err := a.Options.Store.Delete(rec.Key); err != nil && err != store.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+func (k *Keys) readKey(ctx context.Context, id string) (*apiKey, error) {
+	if len(id) == 0 {
+		return nil, store.ErrNotFound
+	}
+	recs, err := k.Auth.Options.Store.Read(keyStoreKey(ctx, id))
+	if err != nil {
+		return nil, err
+	}
+	var key *apiKey
+	if err := json.Unmarshal(recs[0].Value, &key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// writeKey writes a key, expiring the record with the key so the store cleans it up
+func (k *Keys) writeKey(ctx context.Context, key *apiKey) error {
+	bytes, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	rec := &store.Record{Key: keyStoreKey(ctx, key.ID), Value: bytes}
+	if !key.Expiry.IsZero() {
+		rec.Expiry = time.Until(key.Expiry)
+	}
+	return k.Auth.Options.Store.Write(rec)
+}
+
+// newKeyID returns a random ID which no key has, writing a key with the ID of another would
+// revoke it. It must be called with keyLock held.
+func (k *Keys) newKeyID(ctx context.Context) (string, error) {
+	for i := 0; i < keyIDAttempts; i++ {
+		id, err := randomBase32(5)
+		if err != nil {
+			return "", errors.InternalServerError("go.micro.auth", "Unable to generate key: %v", err)
+		}
+		id = strings.ToLower(id)
+
+		_, err = k.readKey(ctx, id)
+		if err == store.ErrNotFound {
+			return id, nil
+		} else if err != nil {
+			return "", errors.InternalServerError("go.micro.auth", "Unable to read from store: %v", err)
+		}
+	}
+	return "", errors.InternalServerError("go.micro.auth", "Unable to generate a unique key ID")
+}
+
+// keyID returns the ID of an API key, the part between the prefix and the secret
+func keyID(key string) (string, bool) {
+	if !strings.HasPrefix(key, KeyPrefix) {
+		return "", false
+	}
+	comps := strings.Split(strings.TrimPrefix(key, KeyPrefix), "_")
+	if len(comps) != 2 || len(comps[0]) == 0 || len(comps[1]) == 0 {
+		return "", false
+	}
+	return comps[0], true
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseAllowedIP parses an address or CIDR range of an allowlist
+func parseAllowedIP(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, &net.ParseError{Type: "IP address", Text: s}
+		}
+		bits := 8 * net.IPv4len
+		if ip.To4() == nil {
+			bits = 8 * net.IPv6len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, ipnet, err := net.ParseCIDR(s)
+	return ipnet, err
+}
+
+// allowedIP returns true if an address is in an allowlist
+func allowedIP(allowed []string, source string) bool {
+	ip := net.ParseIP(source)
+	if ip == nil {
+		return false
+	}
+	for _, a := range allowed {
+		if ipnet, err := parseAllowedIP(a); err == nil && ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// callerID returns the ID of the account making the call
+func callerID(ctx context.Context) (string, bool) {
+	acc, ok := auth.AccountFromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	return acc.ID, true
+}
+
+func include(slice []string, val string) bool {
+	for _, s := range slice {
+		if s == val {
+			return true
+		}
+	}
+	return false
+}
+
+func serializeKey(k *apiKey) *pb.Key {
+	key := &pb.Key{
+		Id:         k.ID,
+		Name:       k.Name,
+		Account:    k.AccountID,
+		Scopes:     k.Scopes,
+		AllowedIps: k.AllowedIPs,
+		Created:    k.Created.Unix(),
+	}
+	if !k.Expiry.IsZero() {
+		key.Expiry = k.Expiry.Unix()
+	}
+	if !k.LastUsed.IsZero() {
+		key.LastUsed = k.LastUsed.Unix()
+	}
+	return key
+}
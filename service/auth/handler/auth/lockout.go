@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"time"
+
+	"c-z.dev/go-micro/auth"
+	"c-z.dev/go-micro/errors"
+	"c-z.dev/go-micro/metadata"
+	"c-z.dev/go-micro/store"
+	"c-z.dev/micro/internal/namespace"
+	pb "c-z.dev/micro/service/auth/proto"
+)
+
+const storePrefixAttempts = "attempts"
+
+// LockoutPolicy limits the failed attempts to get a token
+type LockoutPolicy struct {
+	// Free is the number of failures allowed before backing off
+	Free int
+	// Backoff is how long to wait after the first failure over Free, it doubles with every
+	// failure after that
+	Backoff time.Duration
+	// Threshold is the number of failures which lock out
+	Threshold int
+	// Lockout is how long a lockout lasts
+	Lockout time.Duration
+	// Window is how long failures are remembered for after the last one
+	Window time.Duration
+}
+
+var (
+	// DefaultAccountLockout limits the failed attempts for an account
+	DefaultAccountLockout = LockoutPolicy{
+		Free:      5,
+		Backoff:   time.Second,
+		Threshold: 10,
+		Lockout:   time.Minute * 15,
+		Window:    time.Hour,
+	}
+	// DefaultSourceLockout limits the failed attempts from an address, it's looser than
+	// the account policy as many users can share an address
+	DefaultSourceLockout = LockoutPolicy{
+		Free:      20,
+		Backoff:   time.Second,
+		Threshold: 100,
+		Lockout:   time.Minute * 15,
+		Window:    time.Hour,
+	}
+)
+
+// attempts are the recent failed attempts of an account or source
+type attempts struct {
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+}
+
+// retryAt returns when the next attempt is allowed
+func (a *attempts) retryAt(p LockoutPolicy) time.Time {
+	if a.Failures >= p.Threshold {
+		return a.LastFailure.Add(p.Lockout)
+	}
+	if a.Failures <= p.Free {
+		return time.Time{}
+	}
+	delay := p.Backoff << uint(a.Failures-p.Free-1)
+	if delay > p.Lockout || delay <= 0 {
+		delay = p.Lockout
+	}
+	return a.LastFailure.Add(delay)
+}
+
+func attemptsKey(ctx context.Context, kind, id string) string {
+	return strings.Join([]string{storePrefixAttempts, namespace.FromContext(ctx), kind, id}, joinKey)
+}
+
+// sourceFromContext returns the address a request came from. The client address forwarded
+// in X-Forwarded-For is only used if the caller is trusted to forward it, anyone else could
+// set it to get around the lockout of their address.
+func (a *Auth) sourceFromContext(ctx context.Context) string {
+	if fwd, ok := metadata.Get(ctx, "X-Forwarded-For"); ok && len(fwd) > 0 && a.trustsForwarding(ctx) {
+		return strings.TrimSpace(strings.Split(fwd, ",")[0])
+	}
+	remote, ok := metadata.Get(ctx, "Remote")
+	if !ok {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		return host
+	}
+	return remote
+}
+
+// trustsForwarding returns true if the caller can pass on the address of its own caller,
+// which gateways like the web dashboard do with their service account
+func (a *Auth) trustsForwarding(ctx context.Context) bool {
+	acc, ok := auth.AccountFromContext(ctx)
+	return (ok && acc.Type == "service") || (!ok && a.Open)
+}
+
+func (a *Auth) readAttempts(key string) (*attempts, error) {
+	recs, err := a.Options.Store.Read(key)
+	if err == store.ErrNotFound {
+		return &attempts{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var at *attempts
+	if err := json.Unmarshal(recs[0].Value, &at); err != nil {
+		return nil, err
+	}
+	return at, nil
+}
+
+// checkAttempts refuses an attempt to get a token while the account or the source it
+// comes from is backing off or locked out, before any secret is compared
+func (a *Auth) checkAttempts(ctx context.Context, id, source string) error {
+	if err := a.checkAttempt(attemptsKey(ctx, "account", id), a.AccountLockout); err != nil {
+		return err
+	}
+	if len(source) == 0 {
+		return nil
+	}
+	return a.checkAttempt(attemptsKey(ctx, "source", source), a.SourceLockout)
+}
+
+func (a *Auth) checkAttempt(key string, p LockoutPolicy) error {
+	at, err := a.readAttempts(key)
+	if err != nil {
+		return errors.InternalServerError("go.micro.auth", "Unable to read failed attempts: %v", err)
+	}
+	if wait := time.Until(at.retryAt(p)); wait > 0 {
+		return errors.New("go.micro.auth", "Too many failed attempts, try again in "+wait.Round(time.Second).String(), 429)
+	}
+	return nil
+}
+
+// recordFailure counts a failed attempt against the account and the source
+func (a *Auth) recordFailure(ctx context.Context, id, source string) {
+	a.attemptLock.Lock()
+	defer a.attemptLock.Unlock()
+
+	a.countFailure(attemptsKey(ctx, "account", id), a.AccountLockout)
+	if len(source) > 0 {
+		a.countFailure(attemptsKey(ctx, "source", source), a.SourceLockout)
+	}
+}
+
+func (a *Auth) countFailure(key string, p LockoutPolicy) {
+	at, err := a.readAttempts(key)
+	if err != nil {
+		at = &attempts{}
+	}
+	// failures are forgotten once the window has passed
+	if time.Since(at.LastFailure) > p.Window {
+		at.Failures = 0
+	}
+	at.Failures++
+	at.LastFailure = time.Now()
+
+	expiry := p.Window
+	if p.Lockout > expiry {
+		expiry = p.Lockout
+	}
+	bytes, err := json.Marshal(at)
+	if err != nil {
+		return
+	}
+	a.Options.Store.Write(&store.Record{Key: key, Value: bytes, Expiry: expiry})
+}
+
+// resetFailures forgets the failed attempts of an account once it gets a token. The
+// failures of the source are kept, or an attacker could reset them with their own account.
+func (a *Auth) resetFailures(ctx context.Context, id string) error {
+	err := a.Options.Store.Delete(attemptsKey(ctx, "account", id))
+	if err != nil && err != store.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+// Unlock forgets the failed attempts of an account, or of a source address if one is given
+func (a *Auth) Unlock(ctx context.Context, req *pb.UnlockAccountRequest, rsp *pb.UnlockAccountResponse) error {
+	if err := a.checkAdmin(ctx); err != nil {
+		return err
+	}
+	if len(req.Id) == 0 && len(req.Source) == 0 {
+		return errors.BadRequest("go.micro.auth", "ID or source required")
+	}
+
+	a.attemptLock.Lock()
+	defer a.attemptLock.Unlock()
+
+	var keys []string
+	if len(req.Id) > 0 {
+		keys = append(keys, attemptsKey(ctx, "account", req.Id))
+	}
+	if len(req.Source) > 0 {
+		keys = append(keys, attemptsKey(ctx, "source", req.Source))
+	}
+	for _, k := range keys {
+		if err := a.Options.Store.Delete(k); err != nil && err != store.ErrNotFound {
+			return errors.InternalServerError("go.micro.auth", "Unable to delete failed attempts: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"c-z.dev/go-micro/auth"
+	"c-z.dev/go-micro/errors"
+	"c-z.dev/micro/internal/namespace"
+	pb "c-z.dev/micro/service/auth/proto"
+)
+
+const (
+	// MFARequired is the detail of the error returned by Token when the credentials are
+	// correct but the account needs a code too
+	MFARequired = "MFA code required"
+
+	// the TOTP parameters, the defaults of RFC 6238 which authenticator apps expect
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew is the number of periods either side of now a code is accepted for
+	totpSkew = 1
+
+	recoveryCodes = 10
+)
+
+// mfa is the second factor of an account
+type mfa struct {
+	// Secret is the base32 TOTP secret
+	Secret string `json:"secret"`
+	// LastStep is the time step of the last code accepted, codes can't be replayed
+	LastStep int64 `json:"last_step"`
+	// RecoveryCodes are the sha256 hashes of the unused recovery codes
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// totp returns the code for a time step as described by RFC 4226 and RFC 6238
+func totp(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	h := hmac.New(sha1.New, secret)
+	h.Write(msg[:])
+	sum := h.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0xf
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, code%1000000)
+}
+
+// checkCode checks a TOTP code, returning the time step it's for
+func (m *mfa) checkCode(code string, now time.Time) (int64, bool) {
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(m.Secret)
+	if err != nil {
+		return 0, false
+	}
+	step := now.Unix() / totpPeriod
+	for i := -totpSkew; i <= totpSkew; i++ {
+		s := step + int64(i)
+		if s <= m.LastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totp(secret, s)), []byte(code)) == 1 {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+// useRecoveryCode removes a recovery code, returning false if it isn't one
+func (m *mfa) useRecoveryCode(code string) bool {
+	hash := hashRecoveryCode(code)
+	for i, c := range m.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(c), []byte(hash)) == 1 {
+			m.RecoveryCodes = append(m.RecoveryCodes[:i], m.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomBase32 returns n random bytes encoded as base32 without padding
+func randomBase32(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf), nil
+}
+
+// verifyMFA checks the code of an account enrolled in MFA, it's either a TOTP code or
+// one of the recovery codes. Accounts which aren't enrolled don't need a code.
+func (a *Auth) verifyMFA(ctx context.Context, id, code string) error {
+	a.accountLock.Lock()
+	defer a.accountLock.Unlock()
+
+	acc, err := a.readAccount(ctx, id)
+	if err != nil {
+		return err
+	}
+	if acc.MFA == nil {
+		return nil
+	}
+	if err := acc.MFA.verify(code); err != nil {
+		return err
+	}
+	return a.writeAccount(ctx, acc)
+}
+
+// verify checks a TOTP or recovery code of the enrolment, using it up so it can't be used
+// again once the enrolment is written
+func (m *mfa) verify(code string) error {
+	if len(code) == 0 {
+		return errors.Unauthorized("go.micro.auth", MFARequired)
+	}
+	if step, ok := m.checkCode(code, time.Now()); ok {
+		m.LastStep = step
+	} else if !m.useRecoveryCode(code) {
+		return errors.BadRequest("go.micro.auth", "MFA code not correct")
+	}
+	return nil
+}
+
+// EnrolMFA enrols an account in TOTP MFA. Without a code a new secret and recovery codes
+// are generated, the enrolment is then confirmed by calling it again with a code from
+// the authenticator. Accounts can enrol themselves, admins can enrol anyone. An account
+// replacing its enrolment has to give a code of the current one.
+func (a *Auth) EnrolMFA(ctx context.Context, req *pb.EnrolMFARequest, rsp *pb.EnrolMFAResponse) error {
+	self := false
+	if caller, ok := auth.AccountFromContext(ctx); ok && caller.ID == req.Id {
+		self = true
+	} else if err := a.checkAdmin(ctx); err != nil {
+		return err
+	}
+
+	a.accountLock.Lock()
+	defer a.accountLock.Unlock()
+
+	acc, err := a.readAccount(ctx, req.Id)
+	if err != nil {
+		return err
+	}
+	if acc.Type != "user" {
+		return errors.BadRequest("go.micro.auth", "Only user accounts can enrol in MFA")
+	}
+
+	// confirm a pending enrolment, replacing any existing one
+	if len(req.Code) > 0 {
+		if acc.PendingMFA == nil {
+			return errors.BadRequest("go.micro.auth", "No MFA enrolment to confirm")
+		}
+		step, ok := acc.PendingMFA.checkCode(req.Code, time.Now())
+		if !ok {
+			return errors.BadRequest("go.micro.auth", "MFA code not correct")
+		}
+		acc.MFA = acc.PendingMFA
+		acc.MFA.LastStep = step
+		acc.PendingMFA = nil
+		return a.writeAccount(ctx, acc)
+	}
+
+	// a session alone mustn't be enough to replace the authenticator of an account
+	if acc.MFA != nil && self {
+		source := a.sourceFromContext(ctx)
+		if err := a.checkAttempts(ctx, req.Id, source); err != nil {
+			return err
+		}
+		if err := acc.MFA.verify(req.CurrentCode); err != nil {
+			if verr, ok := err.(*errors.Error); !ok || verr.Detail != MFARequired {
+				a.recordFailure(ctx, req.Id, source)
+			}
+			return err
+		}
+	}
+
+	secret, err := randomBase32(20)
+	if err != nil {
+		return errors.InternalServerError("go.micro.auth", "Unable to generate secret: %v", err)
+	}
+	m := &mfa{Secret: secret}
+	for i := 0; i < recoveryCodes; i++ {
+		code, err := randomBase32(5)
+		if err != nil {
+			return errors.InternalServerError("go.micro.auth", "Unable to generate recovery code: %v", err)
+		}
+		code = strings.ToLower(code[:4] + "-" + code[4:])
+		m.RecoveryCodes = append(m.RecoveryCodes, hashRecoveryCode(code))
+		rsp.RecoveryCodes = append(rsp.RecoveryCodes, code)
+	}
+
+	// an existing enrolment stays in force until the new one is confirmed
+	acc.PendingMFA = m
+	if err := a.writeAccount(ctx, acc); err != nil {
+		return err
+	}
+
+	issuer := namespace.FromContext(ctx)
+	label := url.PathEscape(issuer + ":" + acc.ID)
+	rsp.Secret = secret
+	rsp.Uri = fmt.Sprintf("otpauth://totp/%s?secret=%s&issuer=%s&algorithm=SHA1&digits=%d&period=%d",
+		label, secret, url.QueryEscape(issuer), totpDigits, totpPeriod)
+	return nil
+}
@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"c-z.dev/go-micro/auth"
+	microErrors "c-z.dev/go-micro/errors"
+	"c-z.dev/go-micro/store"
+	"c-z.dev/micro/internal/namespace"
+	pb "c-z.dev/micro/service/auth/proto"
+)
+
+// errRefreshTokenReused is returned when a refresh token which has already been
+// rotated is used again
+var errRefreshTokenReused = errors.New("refresh token reused")
+
+// refreshToken is a refresh token as it's stored. Every token belongs to a family, which
+// is started by a login and carried over each time the token is rotated, so a session
+// can be revoked as a whole.
+//
+// Tokens are stored twice, under the account at refresh/<ns>/<account>/<token> so the
+// tokens of an account can be revoked, and at refreshindex/<ns>/<token> so a token can
+// be looked up without scanning.
+type refreshToken struct {
+	AccountID string    `json:"account_id"`
+	Family    string    `json:"family"`
+	Created   time.Time `json:"created"`
+	Expiry    time.Time `json:"expiry"`
+	// Used is set once the token has been rotated, it's kept until it expires so reuse
+	// is noticed
+	Used bool `json:"used,omitempty"`
+}
+
+func refreshTokenKey(ctx context.Context, id, token string) string {
+	return strings.Join([]string{storePrefixRefreshTokens, namespace.FromContext(ctx), id, token}, joinKey)
+}
+
+func refreshIndexKey(ctx context.Context, token string) string {
+	return strings.Join([]string{storePrefixRefreshIndex, namespace.FromContext(ctx), token}, joinKey)
+}
+
+// issueRefreshToken issues a refresh token for an account, an empty family starts a new one
+func (a *Auth) issueRefreshToken(ctx context.Context, id, family string) (string, error) {
+	tok := uuid.New().String()
+	if len(family) == 0 {
+		family = tok
+	}
+	rt := &refreshToken{
+		AccountID: id,
+		Family:    family,
+		Created:   time.Now(),
+		Expiry:    time.Now().Add(a.RefreshTokenExpiry),
+	}
+	if err := a.writeRefreshToken(rt, refreshIndexKey(ctx, tok)); err != nil {
+		return "", err
+	}
+	if err := a.writeRefreshToken(rt, refreshTokenKey(ctx, id, tok)); err != nil {
+		return "", err
+	}
+	return tok, nil
+}
+
+func (a *Auth) writeRefreshToken(rt *refreshToken, key string) error {
+	bytes, err := json.Marshal(rt)
+	if err != nil {
+		return err
+	}
+	return a.Options.Store.Write(&store.Record{Key: key, Value: bytes, Expiry: time.Until(rt.Expiry)})
+}
+
+// readRefreshToken looks a refresh token up, store.ErrNotFound is returned if it doesn't
+// exist or has expired
+func (a *Auth) readRefreshToken(ctx context.Context, tok string) (*refreshToken, error) {
+	recs, err := a.Options.Store.Read(refreshIndexKey(ctx, tok))
+	if err != nil {
+		return nil, err
+	}
+	var rt *refreshToken
+	if err := json.Unmarshal(recs[0].Value, &rt); err != nil {
+		return nil, err
+	}
+	// not every store enforces expiry
+	if time.Now().After(rt.Expiry) {
+		return nil, store.ErrNotFound
+	}
+	return rt, nil
+}
+
+// rotateRefreshToken exchanges a refresh token for a new one in the same family, returning
+// the account it belongs to. If the token has already been used the family is revoked and
+// errRefreshTokenReused is returned.
+func (a *Auth) rotateRefreshToken(ctx context.Context, tok string) (string, string, error) {
+	a.refreshLock.Lock()
+	defer a.refreshLock.Unlock()
+
+	rt, err := a.readRefreshToken(ctx, tok)
+	if err != nil {
+		return "", "", err
+	}
+	if rt.Used {
+		if err := a.revokeRefreshFamily(ctx, rt.AccountID, rt.Family); err != nil {
+			return "", "", err
+		}
+		return "", "", errRefreshTokenReused
+	}
+
+	rt.Used = true
+	if err := a.writeRefreshToken(rt, refreshIndexKey(ctx, tok)); err != nil {
+		return "", "", err
+	}
+	if err := a.writeRefreshToken(rt, refreshTokenKey(ctx, rt.AccountID, tok)); err != nil {
+		return "", "", err
+	}
+
+	next, err := a.issueRefreshToken(ctx, rt.AccountID, rt.Family)
+	if err != nil {
+		return "", "", err
+	}
+	return rt.AccountID, next, nil
+}
+
+// revokeRefreshFamily revokes every refresh token of a family, including the used ones
+func (a *Auth) revokeRefreshFamily(ctx context.Context, id, family string) error {
+	return a.revokeRefreshTokensWhere(ctx, id, func(rt *refreshToken) bool { return rt.Family == family })
+}
+
+// revokeRefreshTokens revokes every refresh token of an account
+func (a *Auth) revokeRefreshTokens(ctx context.Context, id string) error {
+	return a.revokeRefreshTokensWhere(ctx, id, func(*refreshToken) bool { return true })
+}
+
+func (a *Auth) revokeRefreshTokensWhere(ctx context.Context, id string, match func(*refreshToken) bool) error {
+	prefix := strings.Join([]string{storePrefixRefreshTokens, namespace.FromContext(ctx), id, ""}, joinKey)
+	recs, err := a.Options.Store.Read(prefix, store.ReadPrefix())
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range recs {
+		var rt *refreshToken
+		// tokens issued before rotation have no value, they're revoked with the rest
+		if err := json.Unmarshal(rec.Value, &rt); err != nil || rt == nil {
+			rt = &refreshToken{AccountID: id}
+		}
+		if !match(rt) {
+			continue
+		}
+		tok := strings.TrimPrefix(rec.Key, prefix)
+		if err := a.Options.Store.Delete(refreshIndexKey(ctx, tok)); err != nil && err != store.ErrNotFound {
+			return err
+		}
+		if err := a.Options.Store.Delete(rec.Key); err != nil && err != store.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// Logout revokes the session a refresh token belongs to, or every session of the caller's
+// account. Access tokens which have been issued stay valid until they expire.
+func (a *Auth) Logout(ctx context.Context, req *pb.LogoutRequest, rsp *pb.LogoutResponse) error {
+	if req.All {
+		acc, ok := auth.AccountFromContext(ctx)
+		if !ok {
+			return microErrors.BadRequest("go.micro.auth", "Logging out of every session requires an account")
+		}
+		if err := a.revokeRefreshTokens(ctx, acc.ID); err != nil {
+			return microErrors.InternalServerError("go.micro.auth", "Unable to revoke refresh tokens: %v", err)
+		}
+		return nil
+	}
+
+	if len(req.RefreshToken) == 0 {
+		return microErrors.BadRequest("go.micro.auth", "Refresh token required")
+	}
+
+	a.refreshLock.Lock()
+	defer a.refreshLock.Unlock()
+
+	rt, err := a.readRefreshToken(ctx, req.RefreshToken)
+	if err == store.ErrNotFound {
+		// the session has already ended
+		return nil
+	} else if err != nil {
+		return microErrors.InternalServerError("go.micro.auth", "Unable to lookup token: %v", err)
+	}
+	if err := a.revokeRefreshFamily(ctx, rt.AccountID, rt.Family); err != nil {
+		return microErrors.InternalServerError("go.micro.auth", "Unable to revoke refresh tokens: %v", err)
+	}
+	return nil
+}
@@ -7,11 +7,12 @@ import (
 	"sync"
 
 	"c-z.dev/go-micro/auth"
-	pb "c-z.dev/go-micro/auth/service/proto"
 	"c-z.dev/go-micro/errors"
 	"c-z.dev/go-micro/store"
 	memStore "c-z.dev/go-micro/store/memory"
+	inauth "c-z.dev/micro/internal/auth"
 	"c-z.dev/micro/internal/namespace"
+	pb "c-z.dev/micro/service/auth/proto"
 )
 
 const (
@@ -103,17 +104,8 @@ func (r *Rules) setupDefaultRules(ns string) {
 // Create a rule giving a scope access to a resource
 func (r *Rules) Create(ctx context.Context, req *pb.CreateRequest, rsp *pb.CreateResponse) error {
 	// Validate the request
-	if req.Rule == nil {
-		return errors.BadRequest("go.micro.auth", "Rule missing")
-	}
-	if len(req.Rule.Id) == 0 {
-		return errors.BadRequest("go.micro.auth", "ID missing")
-	}
-	if req.Rule.Resource == nil {
-		return errors.BadRequest("go.micro.auth", "Resource missing")
-	}
-	if req.Rule.Access == pb.Access_UNKNOWN {
-		return errors.BadRequest("go.micro.auth", "Access missing")
+	if err := validateRule(req.Rule); err != nil {
+		return err
 	}
 
 	// Chck the rule doesn't exist
@@ -123,8 +115,47 @@ func (r *Rules) Create(ctx context.Context, req *pb.CreateRequest, rsp *pb.Creat
 		return errors.BadRequest("go.micro.auth", "A rule with this ID already exists")
 	}
 
+	return r.writeRule(key, req.Rule)
+}
+
+// Update replaces a rule in a single write, so there's no moment the rule is missing
+func (r *Rules) Update(ctx context.Context, req *pb.UpdateRequest, rsp *pb.UpdateResponse) error {
+	// Validate the request
+	if err := validateRule(req.Rule); err != nil {
+		return err
+	}
+
+	// Check the rule exists
+	ns := namespace.FromContext(ctx)
+	key := strings.Join([]string{storePrefixRules, ns, req.Rule.Id}, joinKey)
+	if _, err := r.Options.Store.Read(key); err == store.ErrNotFound {
+		return errors.BadRequest("go.micro.auth", "Rule not found")
+	} else if err != nil {
+		return errors.InternalServerError("go.micro.auth", "Unable to read from store: %v", err)
+	}
+
+	return r.writeRule(key, req.Rule)
+}
+
+func validateRule(rule *pb.Rule) error {
+	if rule == nil {
+		return errors.BadRequest("go.micro.auth", "Rule missing")
+	}
+	if len(rule.Id) == 0 {
+		return errors.BadRequest("go.micro.auth", "ID missing")
+	}
+	if rule.Resource == nil {
+		return errors.BadRequest("go.micro.auth", "Resource missing")
+	}
+	if rule.Access == pb.Access_UNKNOWN {
+		return errors.BadRequest("go.micro.auth", "Access missing")
+	}
+	return nil
+}
+
+func (r *Rules) writeRule(key string, rule *pb.Rule) error {
 	// Encode the rule
-	bytes, err := json.Marshal(req.Rule)
+	bytes, err := json.Marshal(rule)
 	if err != nil {
 		return errors.InternalServerError("go.micro.auth", "Unable to marshal rule: %v", err)
 	}
@@ -182,3 +213,56 @@ func (r *Rules) List(ctx context.Context, req *pb.ListRequest, rsp *pb.ListRespo
 
 	return nil
 }
+
+// Explain evaluates the rules of the namespace for an account accessing a resource, returning
+// the decision with every rule which matched the resource and the one which decided it
+func (r *Rules) Explain(ctx context.Context, req *pb.ExplainRequest, rsp *pb.ExplainResponse) error {
+	if req.Resource == nil {
+		return errors.BadRequest("go.micro.auth", "Resource missing")
+	}
+
+	list := &pb.ListResponse{}
+	if err := r.List(ctx, &pb.ListRequest{}, list); err != nil {
+		return err
+	}
+
+	// evaluate the rules as auth.Verify would, keeping track of the rules they came from
+	rules := make([]*auth.Rule, 0, len(list.Rules))
+	byRule := make(map[*auth.Rule]*pb.Rule, len(list.Rules))
+	for _, rule := range list.Rules {
+		ar := serializeRule(rule)
+		rules = append(rules, ar)
+		byRule[ar] = rule
+	}
+
+	var acc *auth.Account
+	if req.Account != nil {
+		acc = &auth.Account{ID: req.Account.Id, Type: req.Account.Type, Scopes: req.Account.Scopes}
+	}
+	res := &auth.Resource{Type: req.Resource.Type, Name: req.Resource.Name, Endpoint: req.Resource.Endpoint}
+	eval := inauth.Evaluate(rules, acc, res)
+
+	for _, ar := range eval.Matches {
+		rsp.Rules = append(rsp.Rules, byRule[ar])
+	}
+	if eval.Winner != nil {
+		rsp.Winner = byRule[eval.Winner]
+	}
+	if eval.Granted() {
+		rsp.Access = pb.Access_GRANTED
+	} else {
+		rsp.Access = pb.Access_DENIED
+	}
+	return nil
+}
+
+func serializeRule(r *pb.Rule) *auth.Rule {
+	rule := &auth.Rule{ID: r.Id, Scope: r.Scope, Priority: r.Priority, Access: auth.AccessGranted}
+	if r.Access == pb.Access_DENIED {
+		rule.Access = auth.AccessDenied
+	}
+	if r.Resource != nil {
+		rule.Resource = &auth.Resource{Type: r.Resource.Type, Name: r.Resource.Name, Endpoint: r.Resource.Endpoint}
+	}
+	return rule
+}
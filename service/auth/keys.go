@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"c-z.dev/micro/internal/client"
+	pb "c-z.dev/micro/service/auth/proto"
+	"github.com/urfave/cli/v2"
+)
+
+func listKeys(ctx *cli.Context) {
+	rsp, err := keysFromContext(ctx).List(context.TODO(), &pb.ListKeysRequest{
+		Account: ctx.String("account"),
+	})
+	if err != nil {
+		fmt.Printf("Error listing keys: %v\n", err)
+		os.Exit(1)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, '\t', 0)
+	defer w.Flush()
+
+	formatTime := func(t int64, none string) string {
+		if t == 0 {
+			return none
+		}
+		return time.Unix(t, 0).Format(time.RFC3339)
+	}
+	formatList := func(l []string, none string) string {
+		if len(l) == 0 {
+			return none
+		}
+		return strings.Join(l, ", ")
+	}
+
+	fmt.Fprintln(w, strings.Join([]string{"ID", "Name", "Account", "Scopes", "Allowed IPs", "Created", "Expires", "Last Used"}, "\t\t"))
+	for _, k := range rsp.Keys {
+		fmt.Fprintln(w, strings.Join([]string{
+			k.Id,
+			k.Name,
+			k.Account,
+			formatList(k.Scopes, "<account>"),
+			formatList(k.AllowedIps, "<any>"),
+			formatTime(k.Created, "n/a"),
+			formatTime(k.Expiry, "never"),
+			formatTime(k.LastUsed, "never"),
+		}, "\t\t"))
+	}
+}
+
+// createKey issues an API key, it's only shown once
+func createKey(ctx *cli.Context) {
+	if ctx.Args().Len() != 1 {
+		fmt.Println("Expected one argument: name")
+		os.Exit(1)
+	}
+	if ctx.Duration("expiry") < 0 {
+		fmt.Println("The expiry can't be negative")
+		os.Exit(1)
+	}
+
+	rsp, err := keysFromContext(ctx).Create(context.TODO(), &pb.CreateKeyRequest{
+		Name:       ctx.Args().First(),
+		Account:    ctx.String("account"),
+		Scopes:     ctx.StringSlice("scopes"),
+		AllowedIps: ctx.StringSlice("allowed-ips"),
+		Expiry:     int64(ctx.Duration("expiry").Seconds()),
+	})
+	if err != nil {
+		fmt.Printf("Error creating key: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Key %v created for %v, it can't be shown again:\n", rsp.Key.Id, rsp.Key.Account)
+	fmt.Printf("\n  %v\n\n", rsp.Secret)
+	fmt.Println("Send it in the Authorization header as 'ApiKey <key>'")
+}
+
+// deleteKey revokes an API key
+func deleteKey(ctx *cli.Context) {
+	if ctx.Args().Len() != 1 {
+		fmt.Println("Expected one argument: ID")
+		os.Exit(1)
+	}
+
+	_, err := keysFromContext(ctx).Revoke(context.TODO(), &pb.RevokeKeyRequest{
+		Id: ctx.Args().First(),
+	})
+	if err != nil {
+		fmt.Printf("Error revoking key: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Key revoked")
+}
+
+func keysFromContext(ctx *cli.Context) pb.KeysService {
+	return pb.NewKeysService("go.micro.auth", client.New(ctx))
+}
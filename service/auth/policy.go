@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	cliutil "c-z.dev/micro/client/cli/util"
+	"c-z.dev/micro/internal/config"
+	"c-z.dev/micro/service/auth/policy"
+	pb "c-z.dev/micro/service/auth/proto"
+	"github.com/google/uuid"
+	"github.com/urfave/cli/v2"
+)
+
+// currentPolicy reads the rules and accounts of the namespace
+func currentPolicy(ctx *cli.Context) *policy.Policy {
+	rules, err := rulesFromContext(ctx).List(context.TODO(), &pb.ListRequest{})
+	if err != nil {
+		fmt.Printf("Error listing rules: %v\n", err)
+		os.Exit(1)
+	}
+	accounts, err := accountsFromContext(ctx).List(context.TODO(), &pb.ListAccountsRequest{})
+	if err != nil {
+		fmt.Printf("Error listing accounts: %v\n", err)
+		os.Exit(1)
+	}
+	return policy.FromProto(rules.Rules, accounts.Accounts)
+}
+
+// currentAccountID returns the ID of the account the cli is logged in as, if any
+func currentAccountID(ctx *cli.Context) string {
+	env := cliutil.GetEnv(ctx)
+	tok, err := config.Get("micro", "auth", env.Name, "token")
+	if err != nil || len(tok) == 0 {
+		return ""
+	}
+	acc, err := authFromContext(ctx).Inspect(tok)
+	if err != nil {
+		return ""
+	}
+	return acc.ID
+}
+
+// applyPolicy converges the namespace on a policy file. Rules and accounts which aren't in
+// the file are only deleted with --prune.
+func applyPolicy(ctx *cli.Context) {
+	file := ctx.String("file")
+	if len(file) == 0 {
+		fmt.Println("Missing policy file, set it with --file")
+		os.Exit(1)
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Printf("Error reading policy: %v\n", err)
+		os.Exit(1)
+	}
+	desired, err := policy.Parse(b)
+	if err != nil {
+		fmt.Printf("Error parsing %v: %v\n", file, err)
+		os.Exit(1)
+	}
+
+	changes, err := policy.Diff(currentPolicy(ctx), desired, currentAccountID(ctx))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	var applied, skipped int
+	for _, c := range changes {
+		if c.Action == policy.Delete && !ctx.Bool("prune") {
+			skipped++
+			continue
+		}
+		if ctx.Bool("dry-run") {
+			fmt.Println(c)
+			applied++
+			continue
+		}
+		if err := applyChange(ctx, c); err != nil {
+			fmt.Printf("Error applying %v: %v\n", c, err)
+			os.Exit(1)
+		}
+		fmt.Println(c)
+		applied++
+	}
+
+	if applied == 0 {
+		fmt.Println("The policy is already applied")
+	}
+	if skipped > 0 {
+		fmt.Printf("%d rules and accounts not in the policy are only deleted with --prune\n", skipped)
+	}
+}
+
+func applyChange(ctx *cli.Context, c *policy.Change) error {
+	rules := rulesFromContext(ctx)
+	accounts := accountsFromContext(ctx)
+
+	if c.Rule != nil {
+		var err error
+		switch c.Action {
+		case policy.Create:
+			_, err = rules.Create(context.TODO(), &pb.CreateRequest{Rule: c.Rule.Proto()})
+		case policy.Update:
+			_, err = rules.Update(context.TODO(), &pb.UpdateRequest{Rule: c.Rule.Proto()})
+		case policy.Delete:
+			_, err = rules.Delete(context.TODO(), &pb.DeleteRequest{Id: c.Rule.ID})
+		}
+		return err
+	}
+
+	acc := c.Account
+	switch c.Action {
+	case policy.Create:
+		// the secret isn't part of the policy, it's generated and shown once
+		secret := uuid.New().String()
+		_, err := authServiceFromContext(ctx).Generate(context.TODO(), &pb.GenerateRequest{
+			Id:       acc.ID,
+			Type:     acc.Type,
+			Scopes:   acc.Scopes,
+			Metadata: acc.Metadata,
+			Secret:   secret,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Account %v has the secret %v\n", acc.ID, secret)
+		if acc.Disabled {
+			_, err = accounts.Disable(context.TODO(), &pb.DisableAccountRequest{Id: acc.ID})
+		}
+		return err
+	case policy.Update:
+		_, err := accounts.Update(context.TODO(), &pb.UpdateAccountRequest{
+			Id:       acc.ID,
+			Scopes:   acc.Scopes,
+			Metadata: c.Metadata,
+		})
+		if err != nil {
+			return err
+		}
+		if acc.Disabled {
+			_, err = accounts.Disable(context.TODO(), &pb.DisableAccountRequest{Id: acc.ID})
+		} else {
+			_, err = accounts.Enable(context.TODO(), &pb.EnableAccountRequest{Id: acc.ID})
+		}
+		return err
+	case policy.Delete:
+		_, err := accounts.Delete(context.TODO(), &pb.DeleteAccountRequest{Id: acc.ID})
+		return err
+	}
+	return nil
+}
+
+// exportPolicy writes the rules and accounts of the namespace out as a policy, to stdout
+// unless a file is given. The format is json for .json files and yaml otherwise.
+func exportPolicy(ctx *cli.Context) {
+	file := ctx.String("file")
+	format := ctx.String("format")
+	if len(format) == 0 {
+		format = "yaml"
+		if filepath.Ext(file) == ".json" {
+			format = "json"
+		}
+	}
+
+	b, err := currentPolicy(ctx).Marshal(format)
+	if err != nil {
+		fmt.Printf("Error exporting policy: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(file) == 0 {
+		os.Stdout.Write(b)
+		return
+	}
+	if err := os.WriteFile(file, b, 0o644); err != nil {
+		fmt.Printf("Error writing policy: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Policy written to %v\n", file)
+}
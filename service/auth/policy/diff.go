@@ -0,0 +1,192 @@
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Action is what a change does
+type Action string
+
+const (
+	Create Action = "create"
+	Update Action = "update"
+	Delete Action = "delete"
+)
+
+// adminScope is the scope the account applying a policy needs to keep
+const adminScope = "admin"
+
+// Change is a step to converge a namespace on a policy, it's either for a rule or an account
+type Change struct {
+	Action Action
+	// Rule or Account is the one created, deleted or updated to
+	Rule    *Rule
+	Account *Account
+	// Metadata is merged into the metadata of an updated account, an empty value removes
+	// the key
+	Metadata map[string]string
+	// Details describe what an update changes
+	Details []string
+}
+
+func (c *Change) String() string {
+	kind, id := "rule", ""
+	if c.Rule != nil {
+		id = c.Rule.ID
+	} else if c.Account != nil {
+		kind, id = "account", c.Account.ID
+	}
+	s := fmt.Sprintf("%s %s %s", c.Action, kind, id)
+	if len(c.Details) > 0 {
+		s += ": " + strings.Join(c.Details, ", ")
+	}
+	return s
+}
+
+// Diff returns the changes which converge the current policy on the desired one: creates and
+// updates, then deletes. Rules are compared as a whole, accounts by their scopes, metadata and
+// whether they're disabled. The scopes and metadata of an account are left alone when the
+// desired account omits them. Service accounts are issued by the runtime so they're never
+// deleted, nor is the account with the ID self applying the policy, which is never disabled
+// either and can't lose the admin scope.
+func Diff(current, desired *Policy, self string) ([]*Change, error) {
+	var changes, deletes []*Change
+
+	currentRules := make(map[string]*Rule, len(current.Rules))
+	for _, r := range current.Rules {
+		currentRules[r.ID] = r
+	}
+	desiredRules := make(map[string]bool, len(desired.Rules))
+	for _, r := range desired.Rules {
+		desiredRules[r.ID] = true
+		cur, ok := currentRules[r.ID]
+		if !ok {
+			changes = append(changes, &Change{Action: Create, Rule: r})
+			continue
+		}
+		if details := diffRule(cur, r); len(details) > 0 {
+			changes = append(changes, &Change{Action: Update, Rule: r, Details: details})
+		}
+	}
+	for _, r := range current.Rules {
+		if !desiredRules[r.ID] {
+			deletes = append(deletes, &Change{Action: Delete, Rule: r})
+		}
+	}
+
+	currentAccounts := make(map[string]*Account, len(current.Accounts))
+	for _, a := range current.Accounts {
+		currentAccounts[a.ID] = a
+	}
+	desiredAccounts := make(map[string]bool, len(desired.Accounts))
+	for _, a := range desired.Accounts {
+		desiredAccounts[a.ID] = true
+		cur, ok := currentAccounts[a.ID]
+		if !ok {
+			changes = append(changes, &Change{Action: Create, Account: a})
+			continue
+		}
+		if len(a.Type) > 0 && a.Type != cur.Type {
+			return nil, fmt.Errorf("account %s: type can't be changed from %s to %s", a.ID, cur.Type, a.Type)
+		}
+		if a.ID == self && len(a.Scopes) > 0 && hasScope(cur.Scopes, adminScope) && !hasScope(a.Scopes, adminScope) {
+			return nil, fmt.Errorf("account %s: the %s scope of the account applying the policy can't be removed", a.ID, adminScope)
+		}
+		if a.ID == self && a.Disabled != cur.Disabled {
+			acc := *a
+			acc.Disabled = cur.Disabled
+			a = &acc
+		}
+		c := diffAccount(cur, a)
+		if len(c.Details) > 0 {
+			changes = append(changes, c)
+		}
+	}
+	for _, a := range current.Accounts {
+		if !desiredAccounts[a.ID] && a.Type != "service" && a.ID != self {
+			deletes = append(deletes, &Change{Action: Delete, Account: a})
+		}
+	}
+
+	return append(changes, deletes...), nil
+}
+
+func diffRule(cur, r *Rule) []string {
+	var details []string
+	if cur.Scope != r.Scope {
+		details = append(details, fmt.Sprintf("scope %q => %q", cur.Scope, r.Scope))
+	}
+	if cur.Resource != r.Resource {
+		details = append(details, fmt.Sprintf("resource %s => %s", cur.Resource, r.Resource))
+	}
+	if cur.Access != r.Access {
+		details = append(details, fmt.Sprintf("access %s => %s", cur.Access, r.Access))
+	}
+	if cur.Priority != r.Priority {
+		details = append(details, fmt.Sprintf("priority %d => %d", cur.Priority, r.Priority))
+	}
+	return details
+}
+
+func diffAccount(cur, a *Account) *Change {
+	c := &Change{Action: Update, Account: a, Metadata: make(map[string]string)}
+
+	if len(a.Scopes) > 0 && !sameScopes(cur.Scopes, a.Scopes) {
+		c.Details = append(c.Details, fmt.Sprintf("scopes %v => %v", cur.Scopes, a.Scopes))
+	}
+
+	var keys []string
+	for k, v := range a.Metadata {
+		if cur.Metadata[k] != v {
+			c.Metadata[k] = v
+			keys = append(keys, k)
+		}
+	}
+	// omitted metadata is left alone, empty metadata removes every key
+	if a.Metadata != nil {
+		for k := range cur.Metadata {
+			if _, ok := a.Metadata[k]; !ok {
+				c.Metadata[k] = ""
+				keys = append(keys, k)
+			}
+		}
+	}
+	if len(keys) > 0 {
+		sort.Strings(keys)
+		c.Details = append(c.Details, "metadata "+strings.Join(keys, " "))
+	}
+
+	if cur.Disabled != a.Disabled {
+		c.Details = append(c.Details, fmt.Sprintf("disabled %v => %v", cur.Disabled, a.Disabled))
+	}
+	return c
+}
+
+// hasScope returns true if the scopes include scope
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// sameScopes returns true if two accounts have the same scopes in any order
+func sameScopes(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
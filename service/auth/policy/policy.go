@@ -0,0 +1,165 @@
+// Package policy is the declarative form of the rules and accounts of a namespace, used by
+// micro auth apply and micro auth export
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	pb "c-z.dev/micro/service/auth/proto"
+	"gopkg.in/yaml.v3"
+)
+
+// Policy is the access policy of a namespace. Secrets are never part of it.
+type Policy struct {
+	Rules    []*Rule    `json:"rules,omitempty" yaml:"rules,omitempty"`
+	Accounts []*Account `json:"accounts,omitempty" yaml:"accounts,omitempty"`
+}
+
+// Rule gives a scope access to a resource
+type Rule struct {
+	ID string `json:"id" yaml:"id"`
+	// Scope is blank for public rules and * for any account
+	Scope string `json:"scope" yaml:"scope"`
+	// Resource is in the format type:name:endpoint
+	Resource string `json:"resource" yaml:"resource"`
+	// Access is granted or denied
+	Access   string `json:"access" yaml:"access"`
+	Priority int32  `json:"priority,omitempty" yaml:"priority,omitempty"`
+}
+
+// Account is an account without its secret
+type Account struct {
+	ID       string            `json:"id" yaml:"id"`
+	Type     string            `json:"type,omitempty" yaml:"type,omitempty"`
+	Scopes   []string          `json:"scopes,omitempty" yaml:"scopes,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+	Disabled bool              `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+}
+
+// Parse a policy file, JSON is parsed as YAML
+func Parse(b []byte) (*Policy, error) {
+	var p Policy
+	if err := yaml.Unmarshal(b, &p); err != nil {
+		return nil, err
+	}
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// validate the policy, setting the defaults
+func (p *Policy) validate() error {
+	rules := make(map[string]bool, len(p.Rules))
+	for i, r := range p.Rules {
+		if r == nil || len(r.ID) == 0 {
+			return fmt.Errorf("rule %d: id missing", i)
+		}
+		if rules[r.ID] {
+			return fmt.Errorf("rule %s: defined more than once", r.ID)
+		}
+		rules[r.ID] = true
+
+		if len(strings.Split(r.Resource, ":")) != 3 {
+			return fmt.Errorf("rule %s: resource must be in the format type:name:endpoint", r.ID)
+		}
+		switch r.Access {
+		case "":
+			r.Access = "granted"
+		case "granted", "denied":
+		default:
+			return fmt.Errorf("rule %s: access must be granted or denied", r.ID)
+		}
+	}
+
+	accounts := make(map[string]bool, len(p.Accounts))
+	for i, a := range p.Accounts {
+		if a == nil || len(a.ID) == 0 {
+			return fmt.Errorf("account %d: id missing", i)
+		}
+		if accounts[a.ID] {
+			return fmt.Errorf("account %s: defined more than once", a.ID)
+		}
+		accounts[a.ID] = true
+
+		if len(a.Type) == 0 {
+			a.Type = "user"
+		}
+	}
+	return nil
+}
+
+// Marshal the policy as yaml or json
+func (p *Policy) Marshal(format string) ([]byte, error) {
+	switch format {
+	case "yaml":
+		return yaml.Marshal(p)
+	case "json":
+		b, err := json.MarshalIndent(p, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(b, '\n'), nil
+	default:
+		return nil, fmt.Errorf("unknown format %s, must be yaml or json", format)
+	}
+}
+
+// FromProto returns the policy of the rules and accounts of a namespace, sorted by ID
+func FromProto(rules []*pb.Rule, accounts []*pb.Account) *Policy {
+	p := &Policy{}
+	for _, r := range rules {
+		p.Rules = append(p.Rules, RuleFromProto(r))
+	}
+	for _, a := range accounts {
+		p.Accounts = append(p.Accounts, AccountFromProto(a))
+	}
+	sort.Slice(p.Rules, func(i, j int) bool { return p.Rules[i].ID < p.Rules[j].ID })
+	sort.Slice(p.Accounts, func(i, j int) bool { return p.Accounts[i].ID < p.Accounts[j].ID })
+	return p
+}
+
+// RuleFromProto converts a rule of the rules service
+func RuleFromProto(r *pb.Rule) *Rule {
+	rule := &Rule{ID: r.Id, Scope: r.Scope, Priority: r.Priority, Access: "granted"}
+	if r.Access == pb.Access_DENIED {
+		rule.Access = "denied"
+	}
+	if r.Resource != nil {
+		rule.Resource = strings.Join([]string{r.Resource.Type, r.Resource.Name, r.Resource.Endpoint}, ":")
+	}
+	return rule
+}
+
+// Proto converts the rule for the rules service
+func (r *Rule) Proto() *pb.Rule {
+	comps := strings.SplitN(r.Resource, ":", 3)
+	for len(comps) < 3 {
+		comps = append(comps, "")
+	}
+	rule := &pb.Rule{
+		Id:       r.ID,
+		Scope:    r.Scope,
+		Access:   pb.Access_GRANTED,
+		Priority: r.Priority,
+		Resource: &pb.Resource{Type: comps[0], Name: comps[1], Endpoint: comps[2]},
+	}
+	if r.Access == "denied" {
+		rule.Access = pb.Access_DENIED
+	}
+	return rule
+}
+
+// AccountFromProto converts an account of the accounts service
+func AccountFromProto(a *pb.Account) *Account {
+	return &Account{
+		ID:       a.Id,
+		Type:     a.Type,
+		Scopes:   a.Scopes,
+		Metadata: a.Metadata,
+		Disabled: a.Disabled,
+	}
+}
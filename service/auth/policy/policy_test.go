@@ -0,0 +1,174 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+
+	pb "c-z.dev/micro/service/auth/proto"
+)
+
+const testPolicy = `
+rules:
+  - id: public-foo
+    scope: ""
+    resource: service:go.micro.api.foo:*
+  - id: deny-bar
+    scope: user
+    resource: service:go.micro.api.bar:Bar.Delete
+    access: denied
+    priority: 10
+accounts:
+  - id: alice
+    scopes: [admin]
+    metadata:
+      team: payments
+  - id: carol
+    scopes: [user]
+`
+
+func TestParse(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Rules) != 2 || len(p.Accounts) != 2 {
+		t.Fatalf("Expected 2 rules and 2 accounts, got %v", p)
+	}
+	if p.Rules[0].Access != "granted" || p.Accounts[0].Type != "user" {
+		t.Fatalf("Expected the defaults to be set, got %v %v", p.Rules[0], p.Accounts[0])
+	}
+
+	// json is parsed too
+	p, err = Parse([]byte(`{"rules": [{"id": "a", "resource": "service:foo:*"}]}`))
+	if err != nil || len(p.Rules) != 1 {
+		t.Fatalf("Expected a json policy to parse, got %v %v", p, err)
+	}
+
+	invalid := map[string]string{
+		"NoID":      "rules:\n  - resource: service:foo:*\n",
+		"Duplicate": "accounts:\n  - id: a\n  - id: a\n",
+		"Resource":  "rules:\n  - id: a\n    resource: service:foo\n",
+		"Access":    "rules:\n  - id: a\n    resource: service:foo:*\n    access: maybe\n",
+	}
+	for name, s := range invalid {
+		if _, err := Parse([]byte(s)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	desired, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := FromProto([]*pb.Rule{
+		{Id: "default", Scope: "*", Access: pb.Access_GRANTED, Resource: &pb.Resource{Type: "*", Name: "*", Endpoint: "*"}},
+		{Id: "deny-bar", Scope: "user", Access: pb.Access_DENIED, Resource: &pb.Resource{Type: "service", Name: "go.micro.api.bar", Endpoint: "*"}},
+	}, []*pb.Account{
+		{Id: "alice", Type: "user", Scopes: []string{"admin"}, Metadata: map[string]string{"team": "billing", "old": "x"}},
+		{Id: "bob", Type: "user", Scopes: []string{"user"}},
+		{Id: "go.micro.foo", Type: "service", Scopes: []string{"service"}},
+	})
+
+	changes, err := Diff(current, desired, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range changes {
+		got = append(got, c.String())
+	}
+	expected := []string{
+		"create rule public-foo",
+		"update rule deny-bar: resource service:go.micro.api.bar:* => service:go.micro.api.bar:Bar.Delete, priority 0 => 10",
+		"update account alice: metadata old team",
+		"create account carol",
+		"delete rule default",
+		"delete account bob",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Expected changes:\n%v\ngot:\n%v", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+	if md := changes[2].Metadata; len(md) != 2 || md["old"] != "" || md["team"] != "payments" {
+		t.Fatalf("Expected the metadata to be merged, got %v", md)
+	}
+
+	// the account applying the policy is never deleted
+	changes, err = Diff(current, desired, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range changes {
+		if c.Account != nil && c.Account.ID == "bob" {
+			t.Fatalf("Expected the account applying the policy to be left alone, got %v", c)
+		}
+	}
+
+	// omitted metadata is left alone and empty metadata is removed
+	current = FromProto(nil, []*pb.Account{
+		{Id: "alice", Type: "user", Metadata: map[string]string{"team": "billing"}},
+		{Id: "bob", Type: "user", Metadata: map[string]string{"team": "billing"}},
+	})
+	omitted, err := Parse([]byte("accounts:\n  - id: alice\n  - id: bob\n    metadata: {}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err = Diff(current, omitted, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Account.ID != "bob" || changes[0].Metadata["team"] != "" {
+		t.Fatalf("Expected only the metadata of bob to be removed, got %v", changes)
+	}
+
+	// the account applying the policy can't remove its own admin scope
+	current = FromProto(nil, []*pb.Account{{Id: "alice", Type: "user", Scopes: []string{"admin"}}})
+	demoted := &Policy{Accounts: []*Account{{ID: "alice", Type: "user", Scopes: []string{"user"}}}}
+	if _, err := Diff(current, demoted, "alice"); err == nil {
+		t.Fatal("Expected an error removing the admin scope of the account applying the policy")
+	}
+	if _, err := Diff(current, demoted, "bob"); err != nil {
+		t.Fatalf("Expected the admin scope of another account to be removable, got %v", err)
+	}
+
+	// applying the changes converges
+	current = FromProto(nil, nil)
+	current.Rules = desired.Rules
+	current.Accounts = desired.Accounts
+	if changes, _ := Diff(current, desired, ""); len(changes) != 0 {
+		t.Fatalf("Expected no changes, got %v", changes)
+	}
+
+	// the type of an account can't change
+	desired.Accounts[0].Type = "service"
+	if _, err := Diff(FromProto(nil, []*pb.Account{{Id: "alice", Type: "user"}}), desired, ""); err == nil {
+		t.Fatal("Expected an error changing the type of an account")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, format := range []string{"yaml", "json"} {
+		b, err := p.Marshal(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q, err := Parse(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changes, _ := Diff(q, p, ""); len(changes) != 0 {
+			t.Fatalf("%s: expected the policy to round trip, got %v", format, changes)
+		}
+	}
+
+	for _, r := range p.Rules {
+		if got := RuleFromProto(r.Proto()); *got != *r {
+			t.Fatalf("Expected the rule to round trip, got %v for %v", got, r)
+		}
+	}
+}
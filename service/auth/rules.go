@@ -4,13 +4,14 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"sort"
 	"strings"
 	"text/tabwriter"
 
-	pb "c-z.dev/go-micro/auth/service/proto"
+	"c-z.dev/go-micro/auth"
 	"c-z.dev/go-micro/errors"
+	inauth "c-z.dev/micro/internal/auth"
 	"c-z.dev/micro/internal/client"
+	pb "c-z.dev/micro/service/auth/proto"
 	"github.com/urfave/cli/v2"
 )
 
@@ -26,24 +27,37 @@ func listRules(ctx *cli.Context) {
 	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, '\t', 0)
 	defer w.Flush()
 
-	formatResource := func(r *pb.Resource) string {
+	formatResource := func(r *auth.Resource) string {
 		return strings.Join([]string{r.Type, r.Name, r.Endpoint}, ":")
 	}
 
-	// sort rules using resource name and priority to keep the list consistent
-	sort.Slice(rsp.Rules, func(i, j int) bool {
-		resI := formatResource(rsp.Rules[i].Resource) + string(rsp.Rules[i].Priority)
-		resJ := formatResource(rsp.Rules[j].Resource) + string(rsp.Rules[j].Priority)
-		return sort.StringsAreSorted([]string{resJ, resI})
-	})
-
-	fmt.Fprintln(w, strings.Join([]string{"ID", "Scope", "Access", "Resource", "Priority"}, "\t\t"))
+	// list the rules in the order they're evaluated in
+	rules := make([]*auth.Rule, 0, len(rsp.Rules))
+	byRule := make(map[*auth.Rule]*pb.Rule, len(rsp.Rules))
 	for _, r := range rsp.Rules {
-		res := formatResource(r.Resource)
+		res := r.Resource
+		if res == nil {
+			res = &pb.Resource{}
+		}
+		ar := &auth.Rule{
+			ID:       r.Id,
+			Priority: r.Priority,
+			Resource: &auth.Resource{Type: res.Type, Name: res.Name, Endpoint: res.Endpoint},
+		}
+		rules = append(rules, ar)
+		byRule[ar] = r
+	}
+	inauth.SortRules(rules)
+
+	fmt.Fprintln(w, strings.Join([]string{"ID", "Scope", "Access", "Resource", "Priority", "Specificity"}, "\t\t"))
+	for _, ar := range rules {
+		r := byRule[ar]
+		res := formatResource(ar.Resource)
 		if r.Scope == "" {
 			r.Scope = "<public>"
 		}
-		fmt.Fprintln(w, strings.Join([]string{r.Id, r.Scope, r.Access.String(), res, fmt.Sprintf("%d", r.Priority)}, "\t\t"))
+		spec := fmt.Sprintf("%d", inauth.Specificity(ar.Resource))
+		fmt.Fprintln(w, strings.Join([]string{r.Id, r.Scope, r.Access.String(), res, fmt.Sprintf("%d", r.Priority), spec}, "\t\t"))
 	}
 }
 
@@ -82,6 +96,72 @@ func deleteRule(ctx *cli.Context) {
 	fmt.Println("Rule deleted")
 }
 
+// verifyRules explains whether an account, or an unauthenticated request without one, has
+// access to a resource
+func verifyRules(ctx *cli.Context) {
+	resComps := strings.Split(ctx.String("resource"), ":")
+	if len(resComps) != 3 {
+		fmt.Println("Invalid resource, must be in the format type:name:endpoint")
+		os.Exit(1)
+	}
+	req := &pb.ExplainRequest{
+		Resource: &pb.Resource{
+			Type:     resComps[0],
+			Name:     resComps[1],
+			Endpoint: resComps[2],
+		},
+	}
+
+	if id := ctx.String("account"); len(id) > 0 {
+		rsp, err := accountsFromContext(ctx).List(context.TODO(), &pb.ListAccountsRequest{})
+		if err != nil {
+			fmt.Printf("Error listing accounts: %v\n", err)
+			os.Exit(1)
+		}
+		for _, acc := range rsp.Accounts {
+			if acc.Id == id {
+				req.Account = acc
+				break
+			}
+		}
+		if req.Account == nil {
+			fmt.Printf("Account not found: %v\n", id)
+			os.Exit(1)
+		}
+	}
+
+	rsp, err := rulesFromContext(ctx).Explain(context.TODO(), req)
+	if err != nil {
+		fmt.Printf("Error explaining rules: %v\n", err)
+		os.Exit(1)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, '\t', 0)
+	fmt.Fprintln(w, strings.Join([]string{"", "ID", "Scope", "Access", "Resource", "Priority"}, "\t\t"))
+	for _, r := range rsp.Rules {
+		mark := ""
+		if rsp.Winner != nil && r.Id == rsp.Winner.Id {
+			mark = "*"
+		}
+		scope := r.Scope
+		if scope == "" {
+			scope = "<public>"
+		}
+		res := strings.Join([]string{r.Resource.Type, r.Resource.Name, r.Resource.Endpoint}, ":")
+		fmt.Fprintln(w, strings.Join([]string{mark, r.Id, scope, r.Access.String(), res, fmt.Sprintf("%d", r.Priority)}, "\t\t"))
+	}
+	w.Flush()
+
+	if len(rsp.Rules) == 0 {
+		fmt.Println("No rules match the resource")
+	}
+	if rsp.Winner != nil {
+		fmt.Printf("%v by rule %v\n", rsp.Access, rsp.Winner.Id)
+	} else {
+		fmt.Printf("%v as no rule applies to the account\n", rsp.Access)
+	}
+}
+
 func constructRule(ctx *cli.Context) *pb.Rule {
 	if ctx.Args().Len() != 1 {
 		fmt.Println("Too many arguments, expected one argument: ID")
@@ -26,4 +26,8 @@ var CommonFlags = []cli.Flag{
 		Usage:   "Table option to pass to the store backend",
 		EnvVars: []string{"MICRO_STORE_TABLE"},
 	},
+	&cli.StringSliceFlag{
+		Name:  "filter",
+		Usage: "Only include tables matching these database/table patterns, e.g. micro/* or */users",
+	},
 }
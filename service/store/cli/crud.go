@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,6 +13,7 @@ import (
 
 	"c-z.dev/go-micro/config/cmd"
 	"c-z.dev/go-micro/store"
+	storeproto "c-z.dev/micro/service/store/proto"
 	"github.com/dustin/go-humanize"
 	"github.com/urfave/cli/v2"
 )
@@ -96,6 +98,9 @@ func Write(ctx *cli.Context) error {
 		}
 		record.Expiry = d
 	}
+	if ctx.Uint64("if-version") > 0 || ctx.Bool("if-not-exists") {
+		return writeConditional(ctx, record)
+	}
 
 	store := *cmd.DefaultOptions().Store
 	if err := store.Write(record); err != nil {
@@ -113,27 +118,69 @@ func List(ctx *cli.Context) error {
 	if ctx.Bool("prefix") {
 		opts = append(opts, store.ListPrefix(ctx.Args().First()))
 	}
-	if ctx.Uint("limit") != 0 {
-		opts = append(opts, store.ListLimit(ctx.Uint("limit")))
-	}
-	if ctx.Uint("offset") != 0 {
-		opts = append(opts, store.ListLimit(ctx.Uint("offset")))
+	if len(ctx.String("suffix")) > 0 {
+		opts = append(opts, store.ListSuffix(ctx.String("suffix")))
 	}
-	store := *cmd.DefaultOptions().Store
-	keys, err := store.List(opts...)
-	if err != nil {
-		return fmt.Errorf("couldn't list: %w", err)
+	limit := ctx.Uint("limit")
+	offset := ctx.Uint("offset")
+	pageSize := ctx.Uint("page-size")
+	if pageSize == 0 {
+		return errors.New("page-size must be greater than 0")
 	}
-	switch ctx.String("output") {
-	case "json":
-		jsonRecords, err := json.MarshalIndent(keys, "", "  ")
+
+	// page through the keys so they are never all held in memory
+	s := *cmd.DefaultOptions().Store
+	output := ctx.String("output")
+	count := 0
+	var first string
+	for {
+		size := pageSize
+		if limit > 0 && limit < size {
+			size = limit
+		}
+		keys, err := s.List(append(opts, store.ListLimit(size), store.ListOffset(offset))...)
 		if err != nil {
-			return fmt.Errorf("failed marshalling JSON: %w", err)
+			return fmt.Errorf("couldn't list: %w", err)
+		}
+		// older store services ignore the limit and offset and return the same keys again
+		if len(keys) > 0 && offset > 0 && keys[0] == first {
+			break
+		}
+		if len(keys) > 0 {
+			first = keys[0]
 		}
-		fmt.Printf("%s\n", string(jsonRecords))
-	default:
 		for _, key := range keys {
-			fmt.Println(key)
+			switch output {
+			case "json":
+				b, err := json.Marshal(key)
+				if err != nil {
+					return fmt.Errorf("failed marshalling JSON: %w", err)
+				}
+				if count == 0 {
+					fmt.Printf("[\n  %s", b)
+				} else {
+					fmt.Printf(",\n  %s", b)
+				}
+			default:
+				fmt.Println(key)
+			}
+			count++
+		}
+		if uint(len(keys)) != size {
+			break
+		}
+		offset += size
+		if limit > 0 {
+			if limit -= size; limit == 0 {
+				break
+			}
+		}
+	}
+	if output == "json" {
+		if count == 0 {
+			fmt.Println("[]")
+		} else {
+			fmt.Println("\n]")
 		}
 	}
 	return nil
@@ -154,6 +201,30 @@ func Delete(ctx *cli.Context) error {
 	return nil
 }
 
+// writeConditional writes a record through the store service, which checks the version
+func writeConditional(ctx *cli.Context, record *store.Record) error {
+	client := *cmd.DefaultOptions().Client
+	req := client.NewRequest(ctx.String("store"), "Store.Write", &storeproto.WriteRequest{
+		Record: &storeproto.Record{
+			Key:    record.Key,
+			Value:  record.Value,
+			Expiry: int64(record.Expiry.Seconds()),
+		},
+		Options: &storeproto.WriteOptions{
+			Database:    ctx.String("database"),
+			Table:       ctx.String("table"),
+			IfVersion:   ctx.Uint64("if-version"),
+			IfNotExists: ctx.Bool("if-not-exists"),
+		},
+	})
+	rsp := &storeproto.WriteResponse{}
+	if err := client.Call(context.TODO(), req, rsp); err != nil {
+		return fmt.Errorf("couldn't write: %w", err)
+	}
+	fmt.Printf("version %d\n", rsp.Version)
+	return nil
+}
+
 func initStore(ctx *cli.Context) error {
 	opts := []store.Option{}
 	if len(ctx.String("database")) > 0 {
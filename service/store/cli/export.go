@@ -0,0 +1,159 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"c-z.dev/go-micro/config/cmd"
+	"c-z.dev/go-micro/store"
+	"github.com/urfave/cli/v2"
+)
+
+// Export is the entrypoint for micro store export
+func Export(ctx *cli.Context) error {
+	if err := initStore(ctx); err != nil {
+		return err
+	}
+	format, err := textFormat(ctx.String("format"), ctx.String("output"))
+	if err != nil {
+		return err
+	}
+	encoding := ctx.String("encoding")
+	if encoding != "utf8" && encoding != "base64" {
+		return fmt.Errorf("unknown encoding %s, expected utf8 or base64", encoding)
+	}
+	pageSize := ctx.Uint("page-size")
+	if pageSize == 0 {
+		return errors.New("page-size must be greater than 0")
+	}
+
+	var w io.Writer = os.Stdout
+	if out := ctx.String("output"); out != "-" {
+		f, err := os.Create(out)
+		if err != nil {
+			return fmt.Errorf("couldn't create %s: %w", out, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	buf := bufio.NewWriter(w)
+
+	s := *cmd.DefaultOptions().Store
+	n, err := exportTable(s, buf, exportOptions{
+		format:   format,
+		encoding: encoding,
+		prefix:   ctx.String("prefix"),
+		pageSize: pageSize,
+	})
+	if err != nil {
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		return fmt.Errorf("couldn't write the export: %w", err)
+	}
+	if ctx.String("output") != "-" {
+		fmt.Printf("exported %d records\n", n)
+	}
+	return nil
+}
+
+// exportOptions configure exportTable
+type exportOptions struct {
+	format   string
+	encoding string
+	prefix   string
+	pageSize uint
+}
+
+// exportTable writes every record of the table the store is configured with a page of keys
+// at a time. Keys are sorted within a page, so the export is in key order if the store lists
+// keys in order and exports of the same data can be diffed.
+func exportTable(s store.Store, w io.Writer, opts exportOptions) (int, error) {
+	var cw *csv.Writer
+	if opts.format == "csv" {
+		cw = csv.NewWriter(w)
+		if err := cw.Write(csvColumns); err != nil {
+			return 0, err
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+
+	var n int
+	err := listKeys(s, opts.prefix, opts.pageSize, func(keys []string) error {
+		sort.Strings(keys)
+		for _, k := range keys {
+			recs, err := s.Read(k)
+			if err == store.ErrNotFound {
+				// deleted or expired since it was listed
+				continue
+			} else if err != nil {
+				return fmt.Errorf("couldn't read %s: %w", k, err)
+			}
+			r := recs[0]
+			t := textRecord{Key: r.Key}
+			t.Value, t.Encoding = encodeValue(r.Value, opts.encoding)
+			if r.Expiry > 0 {
+				t.ExpiresAt = time.Now().Add(r.Expiry).UTC().Truncate(time.Second).Format(time.RFC3339)
+			}
+
+			if cw != nil {
+				err = cw.Write([]string{t.Key, t.Value, t.Encoding, t.ExpiresAt})
+			} else {
+				err = enc.Encode(&t)
+			}
+			if err != nil {
+				return fmt.Errorf("couldn't write %s: %w", k, err)
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	if cw != nil {
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// listKeys pages through the keys of a store, passing every page to fn
+func listKeys(s store.Store, prefix string, pageSize uint, fn func([]string) error) error {
+	var opts []store.ListOption
+	if len(prefix) > 0 {
+		opts = append(opts, store.ListPrefix(prefix))
+	}
+	var first string
+	var offset uint
+	for {
+		page, err := s.List(append(opts, store.ListLimit(pageSize), store.ListOffset(offset))...)
+		if err != nil {
+			return fmt.Errorf("couldn't list: %w", err)
+		}
+		// older store services ignore the limit and offset and return the same keys again
+		if len(page) > 0 && offset > 0 && page[0] == first {
+			return nil
+		}
+		if offset == 0 && len(page) > 0 {
+			first = page[0]
+		}
+		if err := fn(page); err != nil {
+			return err
+		}
+		if uint(len(page)) != pageSize {
+			return nil
+		}
+		offset += pageSize
+	}
+}
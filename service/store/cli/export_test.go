@@ -0,0 +1,65 @@
+package cli
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"c-z.dev/go-micro/store"
+	"c-z.dev/go-micro/store/memory"
+)
+
+func TestExportImport(t *testing.T) {
+	s := memory.NewStore()
+	s.Write(&store.Record{Key: "b", Value: []byte("plain, \"quoted\"\nvalue")})
+	s.Write(&store.Record{Key: "a", Value: []byte{0xff, 0x00, 0x01}})
+	s.Write(&store.Record{Key: "c", Value: []byte("expiring"), Expiry: time.Hour})
+
+	for _, format := range []string{"jsonl", "csv"} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			n, err := exportTable(s, &buf, exportOptions{format: format, encoding: "utf8", pageSize: 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 3 {
+				t.Fatalf("expected 3 records to be exported, got %d", n)
+			}
+			if format == "jsonl" && !strings.HasPrefix(buf.String(), `{"key":"a"`) {
+				t.Errorf("expected the records in key order, got %s", buf.String())
+			}
+
+			batches, err := collectImport(&buf, format, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(batches) != 1 || len(batches[0].records) != 3 {
+				t.Fatalf("expected a batch of 3 records, got %v", batches)
+			}
+			for _, r := range batches[0].records {
+				recs, _ := s.Read(r.Key)
+				if !bytes.Equal(r.Value, recs[0].Value) {
+					t.Errorf("expected %s=%q, got %q", r.Key, recs[0].Value, r.Value)
+				}
+				if expiring := r.Key == "c"; expiring != (r.Expiry > 0) || r.Expiry > 3600 {
+					t.Errorf("unexpected expiry %d for %s", r.Expiry, r.Key)
+				}
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	if _, err := exportTable(s, &buf, exportOptions{format: "jsonl", encoding: "base64", prefix: "b", pageSize: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"encoding":"base64"`) || strings.Count(buf.String(), "\n") != 1 {
+		t.Errorf("expected b to be exported base64 encoded, got %s", buf.String())
+	}
+
+	// records which expired before the import are skipped
+	expired := `{"key": "old", "value": "x", "expires_at": "2000-01-01T00:00:00Z"}`
+	if batches, err := collectImport(strings.NewReader(expired), "jsonl", 10); err != nil || len(batches) != 0 {
+		t.Errorf("expected the expired record to be skipped, got %v %v", batches, err)
+	}
+}
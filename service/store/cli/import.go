@@ -0,0 +1,244 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"c-z.dev/go-micro/config/cmd"
+	storeproto "c-z.dev/micro/service/store/proto"
+	"github.com/urfave/cli/v2"
+)
+
+// importBatch is a run of operations of the same type sent in one request
+type importBatch struct {
+	delete  bool
+	line    int
+	records []*storeproto.Record
+	keys    []string
+}
+
+func (b *importBatch) size() int {
+	return len(b.records) + len(b.keys)
+}
+
+// Import is the entrypoint for micro store import
+func Import(ctx *cli.Context) error {
+	if ctx.Args().Len() < 1 {
+		return errors.New("file arg is required, - reads from stdin")
+	}
+	size := ctx.Int("batch-size")
+	if size < 1 {
+		return errors.New("batch-size must be at least 1")
+	}
+	format, err := textFormat(ctx.String("format"), ctx.Args().First())
+	if err != nil {
+		return err
+	}
+
+	// the file is read twice, stdin is kept in a temporary file to do so
+	name := ctx.Args().First()
+	if name == "-" {
+		if name, err = spoolStdin(); err != nil {
+			return err
+		}
+		defer os.Remove(name)
+	}
+
+	// check the whole file before anything is applied, so an invalid line half way
+	// through doesn't leave the batches before it imported
+	if err := readImportFile(name, format, size, func(*importBatch) error { return nil }); err != nil {
+		return err
+	}
+
+	client := *cmd.DefaultOptions().Client
+	var writes, deletes, batches int
+	err = readImportFile(name, format, size, func(b *importBatch) error {
+		var req, rsp interface{}
+		endpoint := "Store.BatchWrite"
+		if b.delete {
+			endpoint = "Store.BatchDelete"
+			req = &storeproto.BatchDeleteRequest{Keys: b.keys, Options: &storeproto.DeleteOptions{
+				Database: ctx.String("database"),
+				Table:    ctx.String("table"),
+			}}
+			rsp = &storeproto.BatchDeleteResponse{}
+		} else {
+			req = &storeproto.BatchWriteRequest{Records: b.records, Options: &storeproto.WriteOptions{
+				Database: ctx.String("database"),
+				Table:    ctx.String("table"),
+			}}
+			rsp = &storeproto.BatchWriteResponse{}
+		}
+		if err := client.Call(context.TODO(), client.NewRequest(ctx.String("store"), endpoint, req), rsp); err != nil {
+			return fmt.Errorf("couldn't import the batch starting at line %d: %w", b.line, err)
+		}
+		if b.delete {
+			deletes += len(b.keys)
+		} else {
+			writes += len(b.records)
+		}
+		batches++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%w, %d writes and %d deletes before it were imported", err, writes, deletes)
+	}
+	fmt.Printf("imported %d writes and %d deletes in %d batches\n", writes, deletes, batches)
+	return nil
+}
+
+// spoolStdin copies stdin to a temporary file and returns its name
+func spoolStdin() (string, error) {
+	f, err := os.CreateTemp("", "micro-import-")
+	if err != nil {
+		return "", fmt.Errorf("couldn't buffer stdin: %w", err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, os.Stdin); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("couldn't buffer stdin: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// readImportFile reads the operations of an import file with readImport
+func readImportFile(name, format string, size int, fn func(*importBatch) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("couldn't open %s: %w", name, err)
+	}
+	defer f.Close()
+	return readImport(f, format, size, fn)
+}
+
+// readImport reads the operations of an import into batches of at most size operations,
+// passing each to fn as soon as it's full so the file is never held in memory. Consecutive
+// writes and deletes are batched together, records which have already expired are skipped.
+func readImport(r io.Reader, format string, size int, fn func(*importBatch) error) error {
+	next, err := textReader(r, format)
+	if err != nil {
+		return err
+	}
+	var current *importBatch
+	for {
+		t, line, err := next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if len(t.Key) == 0 {
+			return fmt.Errorf("line %d has no key", line)
+		}
+		var del bool
+		switch t.Op {
+		case "", "write":
+		case "delete":
+			del = true
+		default:
+			return fmt.Errorf("line %d has unknown op %s, expected write or delete", line, t.Op)
+		}
+
+		var record *storeproto.Record
+		if !del {
+			if record, err = t.record(); err != nil {
+				return fmt.Errorf("line %d is invalid: %w", line, err)
+			} else if record == nil {
+				continue
+			}
+		}
+
+		if current == nil || current.delete != del || current.size() >= size {
+			if current != nil {
+				if err := fn(current); err != nil {
+					return err
+				}
+			}
+			current = &importBatch{delete: del, line: line}
+		}
+		if del {
+			current.keys = append(current.keys, t.Key)
+		} else {
+			current.records = append(current.records, record)
+		}
+	}
+	if current != nil {
+		return fn(current)
+	}
+	return nil
+}
+
+// textReader returns a function which reads the records of a file one at a time along with
+// their line number. It returns io.EOF once every record has been read.
+func textReader(r io.Reader, format string) (func() (*textRecord, int, error), error) {
+	if format == "csv" {
+		cr := csv.NewReader(r)
+		header, err := cr.Read()
+		if err == io.EOF {
+			return func() (*textRecord, int, error) { return nil, 0, io.EOF }, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("couldn't read the CSV header: %w", err)
+		}
+		for _, c := range header {
+			switch c {
+			case "op", "key", "value", "encoding", "expiry", "expires_at":
+			default:
+				return nil, fmt.Errorf("unknown CSV column %s", c)
+			}
+		}
+		return func() (*textRecord, int, error) {
+			row, err := cr.Read()
+			if err == io.EOF {
+				return nil, 0, io.EOF
+			} else if err != nil {
+				return nil, 0, fmt.Errorf("couldn't read the CSV: %w", err)
+			}
+			line, _ := cr.FieldPos(0)
+			t := &textRecord{}
+			for i, c := range header {
+				switch c {
+				case "op":
+					t.Op = row[i]
+				case "key":
+					t.Key = row[i]
+				case "value":
+					t.Value = row[i]
+				case "encoding":
+					t.Encoding = row[i]
+				case "expiry":
+					t.Expiry = row[i]
+				case "expires_at":
+					t.ExpiresAt = row[i]
+				}
+			}
+			return t, line, nil
+		}, nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	line := 0
+	return func() (*textRecord, int, error) {
+		for scanner.Scan() {
+			line++
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+			t := &textRecord{}
+			if err := json.Unmarshal(scanner.Bytes(), t); err != nil {
+				return nil, line, fmt.Errorf("line %d is invalid: %w", line, err)
+			}
+			return t, line, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, line, fmt.Errorf("couldn't read the import: %w", err)
+		}
+		return nil, line, io.EOF
+	}, nil
+}
@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadImport(t *testing.T) {
+	input := `{"key": "a", "value": "1"}
+{"op": "write", "key": "b", "value": "2", "expiry": "1h"}
+{"op": "write", "key": "c", "value": "3"}
+
+{"op": "delete", "key": "d"}
+{"key": "e", "value": "5"}
+`
+	batches, err := collectImport(strings.NewReader(input), "jsonl", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		delete bool
+		line   int
+		size   int
+	}{{false, 1, 2}, {false, 3, 1}, {true, 5, 1}, {false, 6, 1}}
+	if len(batches) != len(expected) {
+		t.Fatalf("expected %d batches, got %d", len(expected), len(batches))
+	}
+	for i, e := range expected {
+		b := batches[i]
+		if b.delete != e.delete || b.line != e.line || b.size() != e.size {
+			t.Errorf("expected batch %d to be %+v, got delete=%v line=%d size=%d", i, e, b.delete, b.line, b.size())
+		}
+	}
+	if batches[0].records[1].Expiry != 3600 {
+		t.Errorf("expected b to expire in an hour, got %d", batches[0].records[1].Expiry)
+	}
+
+	if _, err := collectImport(strings.NewReader(`{"op": "move", "key": "a"}`), "jsonl", 2); err == nil {
+		t.Error("expected an unknown op to be rejected")
+	}
+}
+
+// collectImport reads every batch of an import
+func collectImport(r io.Reader, format string, size int) ([]*importBatch, error) {
+	var batches []*importBatch
+	err := readImport(r, format, size, func(b *importBatch) error {
+		batches = append(batches, b)
+		return nil
+	})
+	return batches, err
+}
@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"c-z.dev/go-micro/config/cmd"
+	storeproto "c-z.dev/micro/service/store/proto"
+	"github.com/urfave/cli/v2"
+)
+
+// IndexCreate is the entrypoint for micro store index create
+func IndexCreate(ctx *cli.Context) error {
+	if ctx.Args().Len() < 1 {
+		return errors.New("field arg is required")
+	}
+	client := *cmd.DefaultOptions().Client
+	req := client.NewRequest(ctx.String("store"), "Store.CreateIndex", &storeproto.CreateIndexRequest{
+		Field:   ctx.Args().First(),
+		Options: indexOptions(ctx),
+	})
+	if err := client.Call(context.TODO(), req, &storeproto.CreateIndexResponse{}); err != nil {
+		return err
+	}
+	fmt.Printf("Created index on %s, existing records are being indexed in the background\n", ctx.Args().First())
+	return nil
+}
+
+// IndexList is the entrypoint for micro store index list
+func IndexList(ctx *cli.Context) error {
+	client := *cmd.DefaultOptions().Client
+	req := client.NewRequest(ctx.String("store"), "Store.ListIndexes", &storeproto.ListIndexesRequest{
+		Options: indexOptions(ctx),
+	})
+	rsp := &storeproto.ListIndexesResponse{}
+	if err := client.Call(context.TODO(), req, rsp); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "%v \t %v \t %v\n", "DATABASE", "TABLE", "FIELD")
+	for _, i := range rsp.Indexes {
+		fmt.Fprintf(w, "%v \t %v \t %v\n", i.Database, i.Table, i.Field)
+	}
+	return w.Flush()
+}
+
+// IndexRebuild is the entrypoint for micro store index rebuild
+func IndexRebuild(ctx *cli.Context) error {
+	if ctx.Args().Len() < 1 {
+		return errors.New("field arg is required")
+	}
+	client := *cmd.DefaultOptions().Client
+	req := client.NewRequest(ctx.String("store"), "Store.RebuildIndex", &storeproto.RebuildIndexRequest{
+		Field:   ctx.Args().First(),
+		Options: indexOptions(ctx),
+	})
+	if err := client.Call(context.TODO(), req, &storeproto.RebuildIndexResponse{}); err != nil {
+		return err
+	}
+	fmt.Printf("Rebuilding index on %s in the background\n", ctx.Args().First())
+	return nil
+}
+
+func indexOptions(ctx *cli.Context) *storeproto.IndexOptions {
+	return &storeproto.IndexOptions{
+		Database: ctx.String("database"),
+		Table:    ctx.String("table"),
+	}
+}
@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"c-z.dev/go-micro/config/cmd"
+	storeproto "c-z.dev/micro/service/store/proto"
+	"github.com/dustin/go-humanize"
+	"github.com/urfave/cli/v2"
+)
+
+// SetQuota is the entrypoint for micro store quota
+func SetQuota(ctx *cli.Context) error {
+	if len(ctx.String("database")) == 0 {
+		return errors.New("the database to set the quota of is required")
+	}
+	q := &storeproto.Quota{Keys: ctx.Uint64("keys")}
+	for _, f := range []struct {
+		name string
+		v    *uint64
+	}{{"bytes", &q.Bytes}, {"value-size", &q.ValueSize}} {
+		if len(ctx.String(f.name)) == 0 {
+			continue
+		}
+		size, err := humanize.ParseBytes(ctx.String(f.name))
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", f.name, err)
+		}
+		*f.v = size
+	}
+	if q.Bytes > 0 && q.ValueSize > q.Bytes {
+		return errors.New("the value size limit can't exceed the size limit")
+	}
+
+	client := *cmd.DefaultOptions().Client
+	req := client.NewRequest(ctx.String("store"), "Store.SetQuota", &storeproto.SetQuotaRequest{
+		Database: ctx.String("database"),
+		Quota:    q,
+		Remove:   ctx.Bool("remove"),
+	})
+	if err := client.Call(context.TODO(), req, &storeproto.SetQuotaResponse{}); err != nil {
+		return err
+	}
+	if ctx.Bool("remove") {
+		fmt.Printf("Removed the quota of %s, the default quota applies\n", ctx.String("database"))
+		return nil
+	}
+	fmt.Printf("Set the quota of %s to %s keys, %s and values of %s\n", ctx.String("database"),
+		limit(q.Keys, fmt.Sprint(q.Keys)), limit(q.Bytes, humanize.Bytes(q.Bytes)), limit(q.ValueSize, humanize.Bytes(q.ValueSize)))
+	return nil
+}
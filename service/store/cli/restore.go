@@ -3,9 +3,9 @@ package cli
 import (
 	"errors"
 	"fmt"
-	"net/url"
 
 	"c-z.dev/go-micro/logger"
+	"c-z.dev/go-micro/store"
 	"c-z.dev/micro/service/store/snapshot"
 	"github.com/urfave/cli/v2"
 )
@@ -17,41 +17,93 @@ func Restore(ctx *cli.Context) error {
 		return fmt.Errorf("couldn't construct a store: %w", err)
 	}
 	log := logger.DefaultLogger
-	var rs snapshot.Restore
 	source := ctx.String("source")
-
 	if len(source) == 0 {
 		return errors.New("source flag must be set")
 	}
-	u, err := url.Parse(source)
+	at := ctx.String("at")
+	// records which expired since the snapshot was taken are skipped unless asked for
+	keepExpired := ctx.Bool("keep-expired")
+	// records are written back to the database and table they were captured from
+	filter := ctx.StringSlice("filter")
+
+	// snapshots are only verified once they've been read to the end, so they're read
+	// twice to make sure a corrupt or truncated snapshot is rejected before any writes
+	_, err = readSnapshot(source, at, keepExpired, func(r *snapshot.Record) error {
+		_, err := r.Table.Match(filter...)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("source is invalid: %w", err)
+		return fmt.Errorf("couldn't verify %s, nothing was restored: %w", source, err)
+	}
+
+	counter := uint64(0)
+	restore := func(r *snapshot.Record) error {
+		if ok, err := r.Table.Match(filter...); err != nil {
+			return err
+		} else if !ok {
+			return nil
+		}
+		if r.Deleted {
+			err := s.Delete(r.Key, store.DeleteFrom(r.Database, r.Table.Table))
+			if err != nil && err != store.ErrNotFound {
+				log.Logf(logger.ErrorLevel, "couldn't delete key %s from %s in store %s", r.Key, r.Table, s.String())
+			}
+			return nil
+		}
+		err := s.Write(r.Record, store.WriteTo(r.Database, r.Table.Table))
+		if err != nil {
+			log.Logf(logger.ErrorLevel, "couldn't write key %s to %s in store %s", r.Key, r.Table, s.String())
+		} else {
+			counter++
+		}
+		return nil
 	}
-	switch u.Scheme {
-	case "file":
-		rs = snapshot.NewFileRestore(snapshot.Source(source))
-	default:
-		return fmt.Errorf("unsupported source scheme: %s", u.Scheme)
+	headers, err := readSnapshot(source, at, keepExpired, restore)
+	if err != nil {
+		return fmt.Errorf("restored %d records before failing: %w", counter, err)
+	}
+	log.Logf(logger.DebugLevel, "Restored %d records from %d snapshots", counter, len(headers))
+	return nil
+}
+
+// readSnapshot passes the records of the snapshot at source to fn, or if at is set those
+// of the chain of snapshots at source up to the one with that ID. It returns the headers
+// of the snapshots which were read.
+func readSnapshot(source, at string, keepExpired bool, fn func(*snapshot.Record) error) ([]snapshot.Header, error) {
+	if len(at) > 0 {
+		return snapshot.Replay(source, at, fn, snapshot.KeepExpired(keepExpired))
+	}
+
+	rs, err := snapshot.NewRestore(source, snapshot.KeepExpired(keepExpired))
+	if err != nil {
+		return nil, err
 	}
 
 	err = rs.Init()
 	if err != nil {
-		return fmt.Errorf("failed to initialise the restorer: %w", err)
+		return nil, fmt.Errorf("failed to initialise the restorer: %w", err)
 	}
 
 	recordChan, err := rs.Start()
 	if err != nil {
-		return fmt.Errorf("couldn't start the restorer: %w", err)
+		return nil, fmt.Errorf("couldn't start the restorer: %w", err)
+	}
+	if base := rs.Header().Base; len(base) > 0 {
+		// drain the channel so the restorer doesn't block
+		for range recordChan {
+		}
+		return nil, fmt.Errorf("%s is an incremental snapshot of %s, restore it from its chain with --at", source, base)
 	}
-	counter := uint64(0)
 	for r := range recordChan {
-		err := s.Write(r)
-		if err != nil {
-			log.Logf(logger.ErrorLevel, "couldn't write key %s to store %s", r.Key, s.String())
-		} else {
-			counter++
+		if err := fn(r); err != nil {
+			for range recordChan {
+			}
+			return nil, err
 		}
 	}
-	log.Logf(logger.DebugLevel, "Restored %d records", counter)
-	return nil
+	if err := rs.Err(); err != nil {
+		return nil, err
+	}
+	return []snapshot.Header{rs.Header()}, nil
 }
@@ -0,0 +1,24 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"c-z.dev/go-micro/config/cmd"
+	storeproto "c-z.dev/micro/service/store/proto"
+	"github.com/urfave/cli/v2"
+)
+
+// RotateKey is the entrypoint for micro store rotate-key
+func RotateKey(ctx *cli.Context) error {
+	client := *cmd.DefaultOptions().Client
+	req := client.NewRequest(ctx.String("store"), "Store.RotateKey", &storeproto.RotateKeyRequest{
+		Database: ctx.String("database"),
+	})
+	rsp := &storeproto.RotateKeyResponse{}
+	if err := client.Call(context.TODO(), req, rsp); err != nil {
+		return err
+	}
+	fmt.Printf("Rotated to data key %d, values are being rewritten in the background\n", rsp.Version)
+	return nil
+}
@@ -1,11 +1,16 @@
 package cli
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"fmt"
-	"net/url"
+	"sort"
+	"strconv"
+	"time"
 
 	"c-z.dev/go-micro/logger"
+	"c-z.dev/go-micro/store"
 	"c-z.dev/micro/service/store/snapshot"
 	"github.com/urfave/cli/v2"
 )
@@ -18,48 +23,221 @@ func Snapshot(ctx *cli.Context) error {
 	}
 	log := logger.DefaultLogger
 	dest := ctx.String("destination")
-	var sn snapshot.Snapshot
-
 	if len(dest) == 0 {
 		return errors.New("destination flag must be set")
 	}
-	u, err := url.Parse(dest)
+	compression, err := snapshot.ParseCompression(ctx.String("compression"))
 	if err != nil {
-		return fmt.Errorf("destination is invalid: %w", err)
+		return err
+	}
+
+	// snapshot the table the store was configured with, or every table in the catalogue
+	tables := []snapshot.Table{{
+		Database: s.Options().Database,
+		Table:    s.Options().Table,
+	}}
+	if ctx.Bool("all") {
+		if tables, err = snapshot.Catalogue(s); err != nil {
+			return err
+		}
+	}
+	if tables, err = snapshot.Filter(tables, ctx.StringSlice("filter")...); err != nil {
+		return err
+	}
+	if len(tables) == 0 {
+		return errors.New("no tables to snapshot")
+	}
+
+	// snapshots in a chain are stored under their ID, incremental ones only hold the
+	// changes since the newest snapshot in the chain
+	var id, base string
+	var state map[snapshot.Table]map[string]valueHash
+	target := dest
+	if ctx.Bool("chain") || ctx.Bool("incremental") {
+		id = snapshot.NewID()
+		if ctx.Bool("incremental") {
+			if base, state, err = chainState(dest); err != nil {
+				return fmt.Errorf("couldn't read the latest snapshot in %s: %w", dest, err)
+			}
+			if len(base) == 0 {
+				log.Logf(logger.InfoLevel, "No snapshots in %s yet, taking a full snapshot", dest)
+			}
+		}
+		if target, err = snapshot.Locate(dest, id); err != nil {
+			return err
+		}
 	}
-	switch u.Scheme {
-	case "file":
-		sn = snapshot.NewFileSnapshot(snapshot.Destination(dest))
-	default:
-		return fmt.Errorf("unsupported destination scheme: %s", u.Scheme)
+
+	sn, err := snapshot.NewSnapshot(target)
+	if err != nil {
+		return err
 	}
-	err = sn.Init()
+	err = sn.Init(
+		snapshot.Tables(tables...),
+		snapshot.Compress(compression),
+		snapshot.ID(id),
+		snapshot.Base(base),
+	)
 	if err != nil {
 		return fmt.Errorf("failed to initialise the snapshotter: %w", err)
 	}
 
-	log.Logf(logger.InfoLevel, "Snapshotting store %s", s.String())
+	log.Logf(logger.InfoLevel, "Snapshotting %d tables from store %s", len(tables), s.String())
 	recordChan, err := sn.Start()
 	if err != nil {
 		return fmt.Errorf("couldn't start the snapshotter: %w", err)
 	}
-	keys, err := s.List()
+
+	counter := 0
+	for _, t := range tables {
+		var known map[string]valueHash
+		if state != nil {
+			if known = state[t]; known == nil {
+				known = make(map[string]valueHash)
+			}
+		}
+		n, err := snapshotTable(s, t, recordChan, known)
+		if err != nil {
+			// discard the partial snapshot rather than committing it
+			snapshot.Abort(recordChan, err)
+			sn.Wait()
+			return err
+		}
+		log.Logf(logger.DebugLevel, "Snapshotted %d keys from %s", n, t)
+		counter += n
+	}
+	close(recordChan)
+	if err := sn.Wait(); err != nil {
+		return fmt.Errorf("couldn't commit the snapshot: %w", err)
+	}
+	if len(id) > 0 {
+		if err := markLatest(dest, id, tables); err != nil {
+			return fmt.Errorf("snapshot %s was written but couldn't be marked as the latest: %w", id, err)
+		}
+		log.Logf(logger.InfoLevel, "Snapshotted %d keys to %s as snapshot %s", counter, dest, id)
+		return nil
+	}
+	log.Logf(logger.InfoLevel, "Snapshotted %d keys to %s", counter, dest)
+	return nil
+}
+
+// valueHash is used to find the records which changed since the base of an incremental snapshot
+type valueHash [sha256.Size]byte
+
+// hashRecord returns the hash of the value, expiry and metadata of a record. The expiry is
+// only compared to the second since it's derived from the remaining time to live.
+func hashRecord(r *store.Record, expiresAt time.Time) valueHash {
+	h := sha256.New()
+	write := func(b []byte) {
+		var buf [binary.MaxVarintLen64]byte
+		n := binary.PutUvarint(buf[:], uint64(len(b)))
+		h.Write(buf[:n])
+		h.Write(b)
+	}
+	write(r.Value)
+	if !expiresAt.IsZero() {
+		write([]byte(strconv.FormatInt(expiresAt.Round(time.Second).Unix(), 10)))
+	} else {
+		write(nil)
+	}
+	keys := make([]string, 0, len(r.Metadata))
+	for k := range r.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		write([]byte(k))
+		write([]byte(fmt.Sprintf("%T:%v", r.Metadata[k], r.Metadata[k])))
+	}
+	var sum valueHash
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// chainState returns the ID of the newest snapshot in the chain at loc and the hash of every
+// record in it. An empty ID is returned if the chain doesn't hold any snapshots yet.
+func chainState(loc string) (string, map[snapshot.Table]map[string]valueHash, error) {
+	state := make(map[snapshot.Table]map[string]valueHash)
+	headers, err := snapshot.Replay(loc, snapshot.Latest, func(r *snapshot.Record) error {
+		if r.Deleted {
+			return nil
+		}
+		if state[r.Table] == nil {
+			state[r.Table] = make(map[string]valueHash)
+		}
+		state[r.Table][r.Key] = hashRecord(r.Record, r.ExpiresAt)
+		return nil
+	})
+	if len(headers) == 0 && errors.Is(err, snapshot.ErrNotFound) {
+		return "", nil, nil
+	}
 	if err != nil {
-		return fmt.Errorf("couldn't List() from store %s: %w", s.String(), err)
+		return "", nil, err
 	}
-	log.Logf(logger.DebugLevel, "Snapshotting %d keys", len(keys))
+	return headers[0].Base, state, nil
+}
 
+// markLatest points the latest snapshot of the chain at loc at the snapshot with the given ID
+func markLatest(loc, id string, tables []snapshot.Table) error {
+	dest, err := snapshot.Locate(loc, snapshot.Latest)
+	if err != nil {
+		return err
+	}
+	sn, err := snapshot.NewSnapshot(dest)
+	if err != nil {
+		return err
+	}
+	err = sn.Init(snapshot.Tables(tables...), snapshot.ID(snapshot.Latest), snapshot.Base(id))
+	if err != nil {
+		return err
+	}
+	recordChan, err := sn.Start()
+	if err != nil {
+		return err
+	}
+	close(recordChan)
+	return sn.Wait()
+}
+
+// snapshotTable sends the records in the table to the snapshotter. If known holds the record
+// hashes of the table in the base of an incremental snapshot, only the records which were
+// added, changed or deleted since then are sent. It returns the number of records sent.
+func snapshotTable(s store.Store, t snapshot.Table, recordChan chan<- *snapshot.Record, known map[string]valueHash) (int, error) {
+	keys, err := s.List(store.ListFrom(t.Database, t.Table))
+	if err != nil {
+		return 0, fmt.Errorf("couldn't List() %s from store %s: %w", t, s.String(), err)
+	}
+
+	counter := 0
 	for _, key := range keys {
-		r, err := s.Read(key)
-		if err != nil {
-			return fmt.Errorf("couldn't read key %s: %w", key, err)
+		r, err := s.Read(key, store.ReadFrom(t.Database, t.Table))
+		if err == store.ErrNotFound {
+			// the key was deleted or expired since it was listed
+			continue
+		} else if err != nil {
+			return 0, fmt.Errorf("couldn't read key %s from %s: %w", key, t, err)
 		}
 		if len(r) != 1 {
-			return fmt.Errorf("reading %s from %s returned 0 records", key, s.String())
+			return 0, fmt.Errorf("reading %s from %s returned %d records", key, t, len(r))
+		}
+		if known != nil {
+			h, ok := known[key]
+			delete(known, key)
+			var expiresAt time.Time
+			if r[0].Expiry > 0 {
+				expiresAt = time.Now().Add(r[0].Expiry)
+			}
+			if ok && h == hashRecord(r[0], expiresAt) {
+				continue
+			}
 		}
-		recordChan <- r[0]
+		recordChan <- &snapshot.Record{Table: t, Record: r[0]}
+		counter++
 	}
-	close(recordChan)
-	sn.Wait()
-	return nil
+	// anything left in the base is gone from the table
+	for key := range known {
+		recordChan <- &snapshot.Record{Table: t, Record: &store.Record{Key: key}, Deleted: true}
+		counter++
+	}
+	return counter, nil
 }
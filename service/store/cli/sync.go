@@ -1,8 +1,18 @@
 package cli
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"c-z.dev/go-micro/logger"
+	"c-z.dev/go-micro/store"
 	"github.com/urfave/cli/v2"
 )
 
@@ -13,26 +23,295 @@ func Sync(ctx *cli.Context) error {
 		return fmt.Errorf("Sync: %w", err)
 	}
 
-	keys, err := from.List()
+	log := logger.DefaultLogger
+	opts := syncOptions{
+		concurrency: ctx.Int("concurrency"),
+		checkpoint:  ctx.String("checkpoint"),
+		dryRun:      ctx.Bool("dry-run"),
+		delete:      ctx.Bool("delete"),
+		progress: func(p syncProgress) {
+			log.Logf(logger.InfoLevel, "Synced %d/%d keys (%.1f keys/s, ETA %s)",
+				p.done, p.total, p.rate, p.eta.Round(time.Second))
+		},
+		interval: ctx.Duration("progress"),
+	}
+	stats, err := syncStores(from, to, opts)
+	if opts.dryRun {
+		fmt.Printf("%d added, %d changed, %d deleted, %d unchanged\n",
+			stats.added, stats.changed, stats.deleted, stats.unchanged)
+		if !opts.delete && stats.deleted > 0 {
+			fmt.Println("keys are only deleted with --delete")
+		}
+	} else {
+		log.Logf(logger.InfoLevel, "Synced %s to %s: %d added, %d changed, %d deleted, %d unchanged",
+			from.String(), to.String(), stats.added, stats.changed, stats.deleted, stats.unchanged)
+	}
+	return err
+}
+
+// syncOptions configure syncStores
+type syncOptions struct {
+	// concurrency is the number of keys copied at once
+	concurrency int
+	// checkpoint is the file the progress is saved to, so an interrupted sync can be resumed
+	checkpoint string
+	// dryRun only counts the changes a sync would make
+	dryRun bool
+	// delete removes the keys from the destination which don't exist in the source
+	delete bool
+	// progress is called every interval while syncing
+	progress func(syncProgress)
+	interval time.Duration
+}
+
+// syncProgress is reported while syncing
+type syncProgress struct {
+	done  uint64
+	total uint64
+	rate  float64
+	eta   time.Duration
+}
+
+// syncStats count the changes made by a sync. In a dry run deleted counts the
+// keys which would be deleted with the delete option.
+type syncStats struct {
+	added     uint64
+	changed   uint64
+	deleted   uint64
+	unchanged uint64
+	failed    uint64
+}
+
+// syncCheckpoint is saved to the checkpoint file. Keys are synced in order, every key up
+// to and including Key has been synced
+type syncCheckpoint struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Key  string `json:"key"`
+}
+
+// syncStores copies every key which was added or changed in from to to. Failures don't stop
+// the sync, the first one is returned once every key has been tried.
+func syncStores(from, to store.Store, opts syncOptions) (syncStats, error) {
+	var stats syncStats
+	if opts.concurrency < 1 {
+		opts.concurrency = 1
+	}
+
+	fromKeys, err := from.List()
 	if err != nil {
-		return fmt.Errorf("couldn't list from store %s: %w", from.String(), err)
+		return stats, fmt.Errorf("couldn't list from store %s: %w", from.String(), err)
 	}
-	for _, k := range keys {
-		r, err := from.Read(k)
+	inSource := make(map[string]bool, len(fromKeys))
+	for _, k := range fromKeys {
+		inSource[k] = true
+	}
+	keys := fromKeys
+	if opts.delete || opts.dryRun {
+		toKeys, err := to.List()
 		if err != nil {
-			return fmt.Errorf("couldn't read %s from store %s: %w", k, from.String(), err)
+			return stats, fmt.Errorf("couldn't list store %s: %w", to.String(), err)
+		}
+		for _, k := range toKeys {
+			if !inSource[k] {
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	// skip the keys synced before an interruption
+	cp := syncCheckpoint{From: describeStore(from), To: describeStore(to)}
+	if len(opts.checkpoint) > 0 && !opts.dryRun {
+		b, err := os.ReadFile(opts.checkpoint)
+		if err != nil && !os.IsNotExist(err) {
+			return stats, fmt.Errorf("couldn't read checkpoint %s: %w", opts.checkpoint, err)
+		}
+		if err == nil {
+			var saved syncCheckpoint
+			if err := json.Unmarshal(b, &saved); err != nil {
+				return stats, fmt.Errorf("couldn't read checkpoint %s: %w", opts.checkpoint, err)
+			}
+			if saved.From != cp.From || saved.To != cp.To {
+				return stats, fmt.Errorf("checkpoint %s is for a sync from %s to %s", opts.checkpoint, saved.From, saved.To)
+			}
+			skip := sort.SearchStrings(keys, saved.Key)
+			if skip < len(keys) && keys[skip] == saved.Key {
+				skip++
+			}
+			keys = keys[skip:]
+			cp.Key = saved.Key
 		}
-		if len(r) != 1 {
-			return fmt.Errorf("received multiple records reading %s from %s", k, from.String())
+	}
+
+	// done tracks the completed keys so the checkpoint only ever moves past keys which synced
+	var (
+		mtx      sync.Mutex
+		done     = make([]bool, len(keys))
+		next     int
+		firstErr error
+	)
+	saveCheckpoint := func() error {
+		if len(opts.checkpoint) == 0 || opts.dryRun {
+			return nil
 		}
-		err = to.Write(r[0])
+		mtx.Lock()
+		c := cp
+		mtx.Unlock()
+		b, err := json.Marshal(c)
 		if err != nil {
-			return fmt.Errorf("couldn't write %s to store %s: %w", k, to.String(), err)
+			return err
 		}
+		// write and rename so a crash never leaves a partial checkpoint behind
+		if err := os.WriteFile(opts.checkpoint+".tmp", b, 0o600); err != nil {
+			return err
+		}
+		return os.Rename(opts.checkpoint+".tmp", opts.checkpoint)
+	}
+
+	var processed uint64
+	start := time.Now()
+	stop := make(chan bool)
+	var wg sync.WaitGroup
+	if opts.progress != nil && opts.interval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(opts.interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-ticker.C:
+				}
+				n := atomic.LoadUint64(&processed)
+				p := syncProgress{done: n, total: uint64(len(keys))}
+				if elapsed := time.Since(start).Seconds(); elapsed > 0 {
+					p.rate = float64(n) / elapsed
+				}
+				if p.rate > 0 {
+					p.eta = time.Duration(float64(p.total-n) / p.rate * float64(time.Second))
+				}
+				opts.progress(p)
+				if err := saveCheckpoint(); err != nil {
+					logger.Logf(logger.WarnLevel, "couldn't save checkpoint %s: %v", opts.checkpoint, err)
+				}
+			}
+		}()
+	}
+
+	jobs := make(chan int)
+	var workers sync.WaitGroup
+	for i := 0; i < opts.concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for i := range jobs {
+				err := syncKey(from, to, keys[i], inSource[keys[i]], opts, &stats)
+				atomic.AddUint64(&processed, 1)
+
+				mtx.Lock()
+				if err != nil {
+					stats.failed++
+					if firstErr == nil {
+						firstErr = err
+					}
+				}
+				done[i] = err == nil
+				// a failed key holds the checkpoint back so it is retried on resume
+				for next < len(done) && done[next] {
+					cp.Key = keys[next]
+					next++
+				}
+				mtx.Unlock()
+			}
+		}()
+	}
+	for i := range keys {
+		jobs <- i
+	}
+	close(jobs)
+	workers.Wait()
+	close(stop)
+	wg.Wait()
+
+	if firstErr != nil {
+		if err := saveCheckpoint(); err != nil {
+			logger.Logf(logger.WarnLevel, "couldn't save checkpoint %s: %v", opts.checkpoint, err)
+		}
+		return stats, fmt.Errorf("%d keys failed to sync, the first error was: %w", stats.failed, firstErr)
+	}
+	if len(opts.checkpoint) > 0 && !opts.dryRun {
+		if err := os.Remove(opts.checkpoint); err != nil && !os.IsNotExist(err) {
+			return stats, fmt.Errorf("couldn't remove checkpoint %s: %w", opts.checkpoint, err)
+		}
+	}
+	return stats, nil
+}
+
+// syncKey copies a key from one store to the other if it was added or changed, or deletes it
+// from the destination if it is no longer in the source
+func syncKey(from, to store.Store, key string, inSource bool, opts syncOptions, stats *syncStats) error {
+	if !inSource {
+		atomic.AddUint64(&stats.deleted, 1)
+		if opts.dryRun || !opts.delete {
+			return nil
+		}
+		if err := to.Delete(key); err != nil && err != store.ErrNotFound {
+			return fmt.Errorf("couldn't delete %s from store %s: %w", key, to.String(), err)
+		}
+		return nil
+	}
+
+	r, err := from.Read(key)
+	if err == store.ErrNotFound {
+		// deleted or expired since it was listed
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("couldn't read %s from store %s: %w", key, from.String(), err)
+	}
+	if len(r) != 1 {
+		return fmt.Errorf("received multiple records reading %s from %s", key, from.String())
+	}
+
+	existing, err := to.Read(key)
+	switch {
+	case err == store.ErrNotFound:
+		atomic.AddUint64(&stats.added, 1)
+	case err != nil:
+		return fmt.Errorf("couldn't read %s from store %s: %w", key, to.String(), err)
+	case len(existing) == 1 && sameRecord(existing[0], r[0]):
+		atomic.AddUint64(&stats.unchanged, 1)
+		return nil
+	default:
+		atomic.AddUint64(&stats.changed, 1)
+	}
+	if opts.dryRun {
+		return nil
+	}
+	if err := to.Write(r[0]); err != nil {
+		return fmt.Errorf("couldn't write %s to store %s: %w", key, to.String(), err)
 	}
 	return nil
 }
 
+// sameRecord returns true if both records hold the same value and metadata
+func sameRecord(a, b *store.Record) bool {
+	if !bytes.Equal(a.Value, b.Value) {
+		return false
+	}
+	if len(a.Metadata) == 0 && len(b.Metadata) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a.Metadata, b.Metadata)
+}
+
+// describeStore identifies a store and the table it is configured with
+func describeStore(s store.Store) string {
+	return fmt.Sprintf("%s/%s/%s", s.String(), s.Options().Database, s.Options().Table)
+}
+
 // SyncFlags are the flags for micro store sync
 var SyncFlags = []cli.Flag{
 	&cli.StringFlag{
@@ -75,4 +354,26 @@ var SyncFlags = []cli.Flag{
 		Usage:   "Table to sync to",
 		EnvVars: []string{"MICRO_STORE_TO_TABLE"},
 	},
+	&cli.IntFlag{
+		Name:  "concurrency",
+		Usage: "Number of keys to copy at once",
+		Value: 8,
+	},
+	&cli.StringFlag{
+		Name:  "checkpoint",
+		Usage: "File to save progress to, an interrupted sync resumes from it when run again",
+	},
+	&cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "Print the number of keys which would be added, changed and deleted without syncing",
+	},
+	&cli.BoolFlag{
+		Name:  "delete",
+		Usage: "Delete keys from the destination which don't exist in the source",
+	},
+	&cli.DurationFlag{
+		Name:  "progress",
+		Usage: "Interval to report progress at",
+		Value: 5 * time.Second,
+	},
 }
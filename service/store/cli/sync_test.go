@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"c-z.dev/go-micro/store"
+	"c-z.dev/go-micro/store/memory"
+)
+
+// failingStore fails writes of a key
+type failingStore struct {
+	store.Store
+	key string
+}
+
+func (f *failingStore) Write(r *store.Record, opts ...store.WriteOption) error {
+	if r.Key == f.key {
+		return errors.New("write failed")
+	}
+	return f.Store.Write(r, opts...)
+}
+
+func TestSyncStores(t *testing.T) {
+	from, to := memory.NewStore(), memory.NewStore()
+	for k, v := range map[string]string{"a": "1", "b": "2", "c": "3"} {
+		from.Write(&store.Record{Key: k, Value: []byte(v)})
+	}
+	for k, v := range map[string]string{"b": "2", "c": "old", "d": "4"} {
+		to.Write(&store.Record{Key: k, Value: []byte(v)})
+	}
+	check := func(stats syncStats, added, changed, deleted, unchanged uint64) {
+		t.Helper()
+		if stats.added != added || stats.changed != changed || stats.deleted != deleted || stats.unchanged != unchanged {
+			t.Errorf("expected %d added, %d changed, %d deleted, %d unchanged, got %+v",
+				added, changed, deleted, unchanged, stats)
+		}
+	}
+
+	stats, err := syncStores(from, to, syncOptions{concurrency: 2, dryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(stats, 1, 1, 1, 1)
+	if _, err := to.Read("a"); err != store.ErrNotFound {
+		t.Errorf("expected a dry run to leave the destination alone, got %v", err)
+	}
+
+	// the failed key holds the checkpoint back
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint")
+	_, err = syncStores(from, &failingStore{Store: to, key: "c"}, syncOptions{
+		concurrency: 2,
+		checkpoint:  checkpoint,
+		delete:      true,
+	})
+	if err == nil {
+		t.Fatal("expected the failed write to be reported")
+	}
+	b, err := os.ReadFile(checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cp syncCheckpoint
+	if err := json.Unmarshal(b, &cp); err != nil {
+		t.Fatal(err)
+	}
+	if cp.Key != "b" {
+		t.Errorf("expected the checkpoint to stop at b, got %q", cp.Key)
+	}
+
+	// only c is left to sync on resume
+	stats, err = syncStores(from, to, syncOptions{concurrency: 2, checkpoint: checkpoint, delete: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(stats, 0, 1, 0, 0)
+	if _, err := os.Stat(checkpoint); !os.IsNotExist(err) {
+		t.Errorf("expected the checkpoint to be removed, got %v", err)
+	}
+	for k, v := range map[string]string{"a": "1", "b": "2", "c": "3"} {
+		recs, err := to.Read(k)
+		if err != nil || string(recs[0].Value) != v {
+			t.Errorf("expected %s=%s, got %v %v", k, v, recs, err)
+		}
+	}
+	if _, err := to.Read("d"); err != store.ErrNotFound {
+		t.Errorf("expected d to be deleted, got %v", err)
+	}
+}
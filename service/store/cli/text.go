@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	storeproto "c-z.dev/micro/service/store/proto"
+)
+
+// textRecord is a record in the JSON Lines and CSV files written by micro store export and
+// read by micro store import
+type textRecord struct {
+	// Op is write or delete, write if empty
+	Op    string `json:"op,omitempty"`
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+	// Encoding is the encoding of the value, utf8 or base64, utf8 if empty
+	Encoding string `json:"encoding,omitempty"`
+	// Expiry is how long the record lives for once imported, e.g. 1h
+	Expiry string `json:"expiry,omitempty"`
+	// ExpiresAt is when the record expires in RFC 3339 format, it takes precedence over Expiry
+	ExpiresAt string `json:"expires_at,omitempty"`
+}
+
+// csvColumns are the columns of an exported CSV file, an import can have them in any order
+var csvColumns = []string{"key", "value", "encoding", "expires_at"}
+
+// textFormat returns the format of a file, from its extension if none is given
+func textFormat(format, file string) (string, error) {
+	if len(format) == 0 {
+		if strings.EqualFold(filepath.Ext(file), ".csv") {
+			return "csv", nil
+		}
+		return "jsonl", nil
+	}
+	switch format {
+	case "jsonl", "csv":
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown format %s, expected jsonl or csv", format)
+}
+
+// encodeValue encodes a value for a text file. Values which aren't valid UTF-8 are always
+// base64 encoded so nothing is lost.
+func encodeValue(value []byte, encoding string) (string, string) {
+	if encoding == "base64" || !utf8.Valid(value) {
+		return base64.StdEncoding.EncodeToString(value), "base64"
+	}
+	return string(value), ""
+}
+
+// record returns the record to import
+func (t *textRecord) record() (*storeproto.Record, error) {
+	r := &storeproto.Record{Key: t.Key}
+	switch t.Encoding {
+	case "", "utf8":
+		r.Value = []byte(t.Value)
+	case "base64":
+		v, err := base64.StdEncoding.DecodeString(t.Value)
+		if err != nil {
+			return nil, fmt.Errorf("value isn't valid base64: %w", err)
+		}
+		r.Value = v
+	default:
+		return nil, fmt.Errorf("unknown encoding %s, expected utf8 or base64", t.Encoding)
+	}
+
+	switch {
+	case len(t.ExpiresAt) > 0:
+		at, err := time.Parse(time.RFC3339, t.ExpiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expires_at: %w", err)
+		}
+		// round up so a record never lives shorter than it should
+		d := time.Until(at)
+		if d <= 0 {
+			return nil, nil
+		}
+		r.Expiry = int64((d + time.Second - 1) / time.Second)
+	case len(t.Expiry) > 0:
+		d, err := time.ParseDuration(t.Expiry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expiry: %w", err)
+		}
+		r.Expiry = int64(d.Seconds())
+	}
+	return r, nil
+}
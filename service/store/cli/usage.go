@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"c-z.dev/go-micro/config/cmd"
+	storeproto "c-z.dev/micro/service/store/proto"
+	"github.com/dustin/go-humanize"
+	"github.com/urfave/cli/v2"
+)
+
+// Usage is the entrypoint for micro store usage
+func Usage(ctx *cli.Context) error {
+	client := *cmd.DefaultOptions().Client
+	req := client.NewRequest(ctx.String("store"), "Store.Usage", &storeproto.UsageRequest{
+		Database: ctx.String("database"),
+	})
+	rsp := &storeproto.UsageResponse{}
+	if err := client.Call(context.TODO(), req, rsp); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "%v \t %v \t %v \t %v\n", "DATABASE", "TABLE", "KEYS", "SIZE")
+	for _, u := range rsp.Usage {
+		fmt.Fprintf(w, "%v \t %v \t %v \t %v\n", u.Database, u.Table, u.Keys, humanize.Bytes(u.Bytes))
+	}
+	w.Flush()
+
+	if len(rsp.Quotas) == 0 {
+		return nil
+	}
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "%v \t %v \t %v \t %v\n", "DATABASE", "KEY LIMIT", "SIZE LIMIT", "VALUE SIZE LIMIT")
+	for db, q := range rsp.Quotas {
+		fmt.Fprintf(w, "%v \t %v \t %v \t %v\n", db, limit(q.Keys, fmt.Sprint(q.Keys)),
+			limit(q.Bytes, humanize.Bytes(q.Bytes)), limit(q.ValueSize, humanize.Bytes(q.ValueSize)))
+	}
+	return w.Flush()
+}
+
+// limit formats a quota limit, zero is unlimited
+func limit(v uint64, s string) string {
+	if v == 0 {
+		return "unlimited"
+	}
+	return s
+}
@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"c-z.dev/go-micro/config/cmd"
+	storeproto "c-z.dev/micro/service/store/proto"
+	"github.com/urfave/cli/v2"
+)
+
+// watchEvent is how a change is printed with --output json
+type watchEvent struct {
+	Type      string    `json:"type"`
+	Database  string    `json:"database"`
+	Table     string    `json:"table"`
+	Key       string    `json:"key"`
+	Value     []byte    `json:"value,omitempty"`
+	Version   uint64    `json:"version,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Watch is the entrypoint for micro store watch
+func Watch(ctx *cli.Context) error {
+	client := *cmd.DefaultOptions().Client
+	stream, err := storeproto.NewStoreService(ctx.String("store"), client).Watch(context.Background(), &storeproto.WatchRequest{
+		Prefix: ctx.Args().First(),
+		Options: &storeproto.WatchOptions{
+			Database: ctx.String("database"),
+			Table:    ctx.String("table"),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't watch: %w", err)
+	}
+	defer stream.Close()
+
+	for {
+		rsp, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("couldn't watch: %w", err)
+		}
+		ts := time.Unix(0, rsp.Timestamp)
+		switch ctx.String("output") {
+		case "json":
+			b, err := json.Marshal(&watchEvent{
+				Type:      rsp.Type,
+				Database:  rsp.Database,
+				Table:     rsp.Table,
+				Key:       rsp.Record.Key,
+				Value:     rsp.Record.Value,
+				Version:   rsp.Record.Version,
+				Timestamp: ts,
+			})
+			if err != nil {
+				return fmt.Errorf("failed marshalling JSON: %w", err)
+			}
+			fmt.Println(string(b))
+		default:
+			value := string(rsp.Record.Value)
+			if !isPrintable(rsp.Record.Value) {
+				value = fmt.Sprintf("%#x", rsp.Record.Value)
+			}
+			if runes := []rune(value); len(runes) > 50 {
+				value = string(runes[:50]) + "..."
+			}
+			fmt.Printf("%s %-6s %s %s\n", ts.Format(time.RFC3339), rsp.Type, rsp.Record.Key, value)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"context"
+
+	"c-z.dev/go-micro/auth"
+	"c-z.dev/go-micro/errors"
+	"c-z.dev/go-micro/metadata"
+	"c-z.dev/micro/internal/namespace"
+)
+
+// AdminScope is the scope of the accounts which can use every database
+const AdminScope = "admin"
+
+// MicroDatabaseHeader is the metadata an admin's call sets to "true" to use the micro database
+// itself. Without it micro is the caller's own database for admins too, as it's what the cli
+// requests by default, so the records of the store can't be changed by accident.
+const MicroDatabaseHeader = "Micro-Store-Micro-Database"
+
+// wantsMicro returns true if an admin's call asked for the micro database itself
+func (s *Store) wantsMicro(ctx context.Context) bool {
+	v, _ := metadata.Get(ctx, MicroDatabaseHeader)
+	return v == "true" && s.isAdmin(ctx)
+}
+
+// isAdmin returns true if the caller can use every database. Calls without an account
+// are only trusted when the store is Open.
+func (s *Store) isAdmin(ctx context.Context) bool {
+	acc, ok := auth.AccountFromContext(ctx)
+	if !ok {
+		return s.Open
+	}
+	for _, s := range acc.Scopes {
+		if s == AdminScope {
+			return true
+		}
+	}
+	return false
+}
+
+// ownDatabase returns the database of the caller's namespace, falling back to the issuer
+// of their account
+func ownDatabase(ctx context.Context) string {
+	ns := namespace.FromContext(ctx)
+	if len(ns) == 0 {
+		if acc, ok := auth.AccountFromContext(ctx); ok {
+			ns = acc.Issuer
+		}
+	}
+	// we're using "micro" as the database
+	// TODO: change default namespace to micro
+	if ns == "go.micro" {
+		ns = "micro"
+	}
+	return ns
+}
+
+// authorize returns the database a call uses, the caller's own if none is requested.
+// Only admins can use a database outside their namespace, anyone else gets an
+// errors.Forbidden error.
+func (s *Store) authorize(ctx context.Context, database string) (string, error) {
+	own := ownDatabase(ctx)
+	if s.isAdmin(ctx) {
+		// the cli requests micro by default, it's only the micro database when asked for
+		if len(database) == 0 || (database == "micro" && len(own) > 0 && !s.wantsMicro(ctx)) {
+			return own, nil
+		}
+		return database, nil
+	}
+	if len(own) == 0 {
+		return "", errors.Forbidden("go.micro.store", "calls without a namespace require the %s scope", AdminScope)
+	}
+	// the cli requests the micro database by default, which means the caller's own
+	if len(database) == 0 || database == "micro" || database == own {
+		return own, nil
+	}
+	return "", errors.Forbidden("go.micro.store", "database %s is outside your namespace, it requires the %s scope", database, AdminScope)
+}
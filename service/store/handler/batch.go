@@ -0,0 +1,398 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"c-z.dev/go-micro/errors"
+	log "c-z.dev/go-micro/logger"
+	"c-z.dev/go-micro/store"
+	pb "c-z.dev/micro/service/store/proto"
+	"github.com/google/uuid"
+)
+
+// maxBatchSize is the number of operations a batch can hold
+const maxBatchSize = 10000
+
+// batchTimeout is how long a journal can go without being updated before its batch is
+// considered abandoned and rolled back. Batches being applied update their journal well
+// within it.
+const batchTimeout = 5 * time.Minute
+
+// batchOp is an operation in a batch, a delete if record is nil
+type batchOp struct {
+	key    string
+	record *store.Record
+	// value is the value as written by the client, before it is encrypted
+	value []byte
+}
+
+// journal is written to journal/<id> in the micro/internal table before a batch is applied.
+// It holds the records as they were, so a batch which fails part way or was being applied
+// by an instance which stopped can be rolled back. Deleting it commits the batch.
+type journal struct {
+	Database string          `json:"database"`
+	Table    string          `json:"table"`
+	Updated  time.Time       `json:"updated"`
+	Before   []journalRecord `json:"before"`
+}
+
+// journalRecord is a record as it was before a batch
+type journalRecord struct {
+	Key       string    `json:"key"`
+	Exists    bool      `json:"exists"`
+	Value     []byte    `json:"value,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	Version   uint64    `json:"version,omitempty"`
+	// HighWater is the highest version the record has had, it's written at the one after
+	HighWater uint64 `json:"high_water,omitempty"`
+}
+
+func (j *journal) keys() []string {
+	keys := make([]string, len(j.Before))
+	for i, r := range j.Before {
+		keys[i] = r.Key
+	}
+	return keys
+}
+
+// BatchWrite writes every record of the request or none of them
+func (s *Store) BatchWrite(ctx context.Context, req *pb.BatchWriteRequest, rsp *pb.BatchWriteResponse) error {
+	var database, table string
+
+	if req.Options != nil {
+		if db := req.Options.Database; len(db) > 0 {
+			database = db
+		}
+		if tb := req.Options.Table; len(tb) > 0 {
+			table = tb
+		}
+	}
+
+	// get new store
+	database, table, err := s.get(ctx, database, table)
+	if err != nil {
+		return err
+	}
+
+	ops := make([]batchOp, len(req.Records))
+	for i, r := range req.Records {
+		ops[i] = batchOp{
+			key:   r.Key,
+			value: r.Value,
+			record: &store.Record{
+				Key:    r.Key,
+				Value:  r.Value,
+				Expiry: time.Duration(r.Expiry) * time.Second,
+			},
+		}
+	}
+	versions, err := s.applyBatch(database, table, ops)
+	if err != nil {
+		return err
+	}
+	rsp.Versions = versions
+	return nil
+}
+
+// BatchDelete deletes every key of the request or none of them
+func (s *Store) BatchDelete(ctx context.Context, req *pb.BatchDeleteRequest, rsp *pb.BatchDeleteResponse) error {
+	var database, table string
+
+	if req.Options != nil {
+		if db := req.Options.Database; len(db) > 0 {
+			database = db
+		}
+		if tb := req.Options.Table; len(tb) > 0 {
+			table = tb
+		}
+	}
+
+	// get new store
+	database, table, err := s.get(ctx, database, table)
+	if err != nil {
+		return err
+	}
+
+	ops := make([]batchOp, len(req.Keys))
+	for i, k := range req.Keys {
+		ops[i] = batchOp{key: k}
+	}
+	_, err = s.applyBatch(database, table, ops)
+	return err
+}
+
+// applyBatch applies every operation or none of them. It returns the version of every
+// record written, in the order of the operations, with 0 for deletes.
+func (s *Store) applyBatch(database, table string, ops []batchOp) ([]uint64, error) {
+	if len(ops) > maxBatchSize {
+		return nil, errors.BadRequest("go.micro.store", "batch of %d operations exceeds the limit of %d", len(ops), maxBatchSize)
+	}
+	keys := make([]string, len(ops))
+	seen := make(map[string]bool, len(ops))
+	for i, op := range ops {
+		if seen[op.key] {
+			return nil, errors.BadRequest("go.micro.store", "%s appears more than once in the batch", op.key)
+		}
+		seen[op.key] = true
+		keys[i] = op.key
+	}
+
+	// the value sizes are checked before the values are encrypted
+	q, err := s.quota(database)
+	if err != nil {
+		return nil, errors.InternalServerError("go.micro.store", err.Error())
+	}
+	for _, op := range ops {
+		if op.record == nil {
+			continue
+		}
+		if err := q.checkValueSize(database, op.key, len(op.value)); err != nil {
+			return nil, err
+		}
+	}
+
+	unlock := s.versions.lockAll(database, table, keys)
+	defer unlock()
+
+	if s.encrypts(database) {
+		// values are rewritten under this lock while a key is rotated
+		l := s.Encryption.lock(database)
+		l.RLock()
+		defer l.RUnlock()
+
+		for _, op := range ops {
+			if op.record == nil {
+				continue
+			}
+			var err error
+			if op.record.Value, err = s.Encryption.Encrypt(database, op.value); err != nil {
+				return nil, errors.InternalServerError("go.micro.store", "couldn't encrypt %s: %v", op.key, err)
+			}
+		}
+	}
+
+	j := &journal{Database: database, Table: table, Before: make([]journalRecord, len(ops))}
+	for i, op := range ops {
+		before, err := s.recordState(database, table, op.key)
+		if err != nil {
+			return nil, errors.InternalServerError("go.micro.store", "couldn't read %s: %v", op.key, err)
+		}
+		j.Before[i] = before
+	}
+
+	done, err := s.reserveBatch(database, table, q, ops, j.Before)
+	if err != nil {
+		return nil, err
+	}
+
+	id := "journal/" + uuid.New().String()
+	if err := s.writeJournal(id, j); err != nil {
+		done(false)
+		return nil, errors.InternalServerError("go.micro.store", "couldn't write the batch journal: %v", err)
+	}
+
+	versions, err := s.apply(id, j, ops)
+	if err == nil {
+		// deleting the journal commits the batch
+		if err = s.Default.Delete(id, store.DeleteFrom("micro", "internal")); err != nil {
+			err = fmt.Errorf("couldn't commit the batch: %w", err)
+		}
+	}
+	if err != nil {
+		done(false)
+		if rerr := s.rollback(j); rerr != nil {
+			log.Errorf("Couldn't roll back batch %s, it is rolled back once abandoned: %v", id, rerr)
+		} else if derr := s.Default.Delete(id, store.DeleteFrom("micro", "internal")); derr != nil && derr != store.ErrNotFound {
+			log.Errorf("Couldn't delete the journal of rolled back batch %s: %v", id, derr)
+		}
+		return nil, errors.InternalServerError("go.micro.store", "batch was rolled back: %v", err)
+	}
+	done(true)
+
+	now := time.Now()
+	for i, op := range ops {
+		c := &change{database: database, table: table, version: versions[i], timestamp: now}
+		switch {
+		case op.record != nil && j.Before[i].Exists:
+			c.typ = changeUpdate
+		case op.record != nil:
+			c.typ = changeCreate
+		case j.Before[i].Exists:
+			c.typ = changeDelete
+		default:
+			continue
+		}
+		if op.record != nil {
+			c.record = &store.Record{Key: op.key, Value: op.value, Expiry: op.record.Expiry}
+		} else {
+			c.record = &store.Record{Key: op.key}
+		}
+		s.changes().publish(c)
+	}
+	return versions, nil
+}
+
+// apply applies the operations of a batch, updating its journal now and then so it isn't
+// considered abandoned
+func (s *Store) apply(id string, j *journal, ops []batchOp) ([]uint64, error) {
+	versions := make([]uint64, len(ops))
+	for i, op := range ops {
+		if time.Since(j.Updated) > batchTimeout/5 {
+			if err := s.writeJournal(id, j); err != nil {
+				return nil, fmt.Errorf("couldn't update the batch journal: %w", err)
+			}
+		}
+
+		before := j.Before[i]
+		if op.record == nil {
+			if !before.Exists {
+				continue
+			}
+			if err := s.Default.Delete(op.key, store.DeleteFrom(j.Database, j.Table)); err != nil && err != store.ErrNotFound {
+				return nil, fmt.Errorf("couldn't delete %s: %w", op.key, err)
+			}
+			if err := s.deleteVersion(j.Database, j.Table, op.key); err != nil {
+				return nil, fmt.Errorf("couldn't delete the version of %s: %w", op.key, err)
+			}
+			if err := s.updateIndexes(j.Database, j.Table, &store.Record{Key: op.key}, true); err != nil {
+				return nil, fmt.Errorf("couldn't remove the index entries of %s: %w", op.key, err)
+			}
+			continue
+		}
+
+		if err := s.Default.Write(op.record, store.WriteTo(j.Database, j.Table)); err != nil {
+			return nil, fmt.Errorf("couldn't write %s: %w", op.key, err)
+		}
+		versions[i] = before.HighWater + 1
+		if before.Version > before.HighWater {
+			versions[i] = before.Version + 1
+		}
+		if err := s.writeVersion(j.Database, j.Table, op.record, versions[i]); err != nil {
+			return nil, fmt.Errorf("couldn't write the version of %s: %w", op.key, err)
+		}
+		indexed := &store.Record{Key: op.key, Value: op.value, Expiry: op.record.Expiry}
+		if err := s.updateIndexes(j.Database, j.Table, indexed, false); err != nil {
+			return nil, fmt.Errorf("couldn't index %s: %w", op.key, err)
+		}
+	}
+	return versions, nil
+}
+
+// rollback restores the records of a batch as they were before it was applied
+func (s *Store) rollback(j *journal) error {
+	// the usage is counted again on the next write
+	s.forgetUsage(j.Database)
+
+	for _, r := range j.Before {
+		if err := s.restore(j, r); err != nil {
+			return err
+		}
+		if err := s.reindex(j.Database, j.Table, r.Key); err != nil {
+			return fmt.Errorf("couldn't index %s: %w", r.Key, err)
+		}
+	}
+	return nil
+}
+
+// restore writes a record of a batch back as it was before the batch
+func (s *Store) restore(j *journal, r journalRecord) error {
+	expired := !r.ExpiresAt.IsZero() && !time.Now().Before(r.ExpiresAt)
+	if !r.Exists || expired {
+		if err := s.Default.Delete(r.Key, store.DeleteFrom(j.Database, j.Table)); err != nil && err != store.ErrNotFound {
+			return fmt.Errorf("couldn't delete %s: %w", r.Key, err)
+		}
+		if err := s.deleteVersion(j.Database, j.Table, r.Key); err != nil {
+			return fmt.Errorf("couldn't delete the version of %s: %w", r.Key, err)
+		}
+		return nil
+	}
+
+	rec := &store.Record{Key: r.Key, Value: r.Value}
+	if !r.ExpiresAt.IsZero() {
+		rec.Expiry = time.Until(r.ExpiresAt)
+	}
+	if err := s.Default.Write(rec, store.WriteTo(j.Database, j.Table)); err != nil {
+		return fmt.Errorf("couldn't restore %s: %w", r.Key, err)
+	}
+	// the high-water mark isn't lowered, versions the batch wrote are never reused
+	var err error
+	if r.Version > 0 {
+		err = s.setVersion(j.Database, j.Table, rec, r.Version)
+	} else {
+		err = s.deleteVersion(j.Database, j.Table, r.Key)
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't restore the version of %s: %w", r.Key, err)
+	}
+	return nil
+}
+
+// RecoverBatches rolls back the batches left part way through by an instance of the service
+// which stopped. Journals updated within batchTimeout may still be being applied and are
+// left alone.
+func (s *Store) RecoverBatches() error {
+	recs, err := s.Default.Read("journal/", store.ReadPrefix(), store.ReadFrom("micro", "internal"))
+	if err == store.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, rec := range recs {
+		j := &journal{}
+		if err := json.Unmarshal(rec.Value, j); err != nil {
+			return fmt.Errorf("invalid journal %s: %w", rec.Key, err)
+		}
+		if time.Since(j.Updated) < batchTimeout {
+			continue
+		}
+
+		unlock := s.versions.lockAll(j.Database, j.Table, j.keys())
+		err := s.rollback(j)
+		if err == nil {
+			err = s.Default.Delete(rec.Key, store.DeleteFrom("micro", "internal"))
+		}
+		unlock()
+		if err != nil {
+			return fmt.Errorf("couldn't roll back batch %s: %w", rec.Key, err)
+		}
+		log.Infof("Rolled back abandoned batch %s of %d records in %s/%s", rec.Key, len(j.Before), j.Database, j.Table)
+	}
+	return nil
+}
+
+// recordState returns a record as it is before a batch is applied
+func (s *Store) recordState(database, table, key string) (journalRecord, error) {
+	r := journalRecord{Key: key}
+	var err error
+	if r.HighWater, err = s.highWater(database, table, key); err != nil {
+		return r, err
+	}
+	recs, err := s.Default.Read(key, store.ReadFrom(database, table))
+	if err == store.ErrNotFound {
+		return r, nil
+	} else if err != nil {
+		return r, err
+	}
+	r.Exists = true
+	r.Value = recs[0].Value
+	if recs[0].Expiry > 0 {
+		r.ExpiresAt = time.Now().Add(recs[0].Expiry)
+	}
+	if r.Version, err = s.version(database, table, key); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+func (s *Store) writeJournal(id string, j *journal) error {
+	j.Updated = time.Now()
+	b, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	return s.Default.Write(&store.Record{Key: id, Value: b}, store.WriteTo("micro", "internal"))
+}
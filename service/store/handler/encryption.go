@@ -0,0 +1,372 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "c-z.dev/go-micro/logger"
+	"c-z.dev/go-micro/store"
+)
+
+// encryptedPrefix marks an encrypted value. It is followed by the version of the data key
+// (4 bytes), the nonce and the sealed value. Values without it are returned as is, so records
+// written before encryption was enabled can still be read.
+var encryptedPrefix = []byte("\x00menc")
+
+// errKeysChanged is returned when the data keys of a database were changed by another instance
+var errKeysChanged = errors.New("data keys were changed by another instance")
+
+// maxKeyWriteAttempts is how many times a rotation is retried when the keys changed under it
+const maxKeyWriteAttempts = 3
+
+// Encryption encrypts values at rest with AES-GCM. Every database has its own data keys,
+// which are stored in the micro/internal table wrapped by the master key.
+type Encryption struct {
+	store  store.Store
+	master cipher.AEAD
+
+	sync.Mutex
+	keys  map[string]*dataKeys
+	locks map[string]*sync.RWMutex
+}
+
+// dataKeys are the data keys of a database by version, new values are sealed with the current one
+type dataKeys struct {
+	current uint32
+	aeads   map[uint32]cipher.AEAD
+}
+
+// storedKeys is how the data keys of a database are stored
+type storedKeys struct {
+	Current uint32            `json:"current"`
+	Keys    map[string][]byte `json:"keys"`
+}
+
+// NewEncryption returns an Encryption which keeps its data keys in s
+func NewEncryption(s store.Store, masterKey []byte) (*Encryption, error) {
+	master, err := newAEAD(masterKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key: %w", err)
+	}
+	return &Encryption{
+		store:  s,
+		master: master,
+		keys:   make(map[string]*dataKeys),
+		locks:  make(map[string]*sync.RWMutex),
+	}, nil
+}
+
+// LoadMasterKey reads a base64 encoded 32 byte master key from a file, or the environment
+// variable if no file is given. It returns nil if neither is set.
+func LoadMasterKey(file, env string) ([]byte, error) {
+	encoded := os.Getenv(env)
+	if len(file) > 0 {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read master key: %w", err)
+		}
+		encoded = string(b)
+	}
+	if len(encoded) == 0 {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("master key must be base64 encoded: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("master key must be 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+// Encrypt seals a value with the current data key of the database, creating one if needed
+func (e *Encryption) Encrypt(database string, value []byte) ([]byte, error) {
+	keys, err := e.dataKeys(database, false)
+	if err != nil {
+		return nil, err
+	}
+	aead := keys.aeads[keys.current]
+	out := make([]byte, len(encryptedPrefix)+4+aead.NonceSize(), len(encryptedPrefix)+4+aead.NonceSize()+len(value)+aead.Overhead())
+	copy(out, encryptedPrefix)
+	binary.BigEndian.PutUint32(out[len(encryptedPrefix):], keys.current)
+	nonce := out[len(encryptedPrefix)+4:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, nonce, value, []byte(database)), nil
+}
+
+// Decrypt opens a value sealed by Encrypt. Values which aren't encrypted are returned as is.
+func (e *Encryption) Decrypt(database string, value []byte) ([]byte, error) {
+	if !bytes.HasPrefix(value, encryptedPrefix) {
+		return value, nil
+	}
+	value = value[len(encryptedPrefix):]
+	if len(value) < 4 {
+		return nil, errors.New("encrypted value is truncated")
+	}
+	version := binary.BigEndian.Uint32(value)
+	value = value[4:]
+
+	keys, err := e.dataKeys(database, false)
+	if err != nil {
+		return nil, err
+	}
+	aead, ok := keys.aeads[version]
+	if !ok {
+		// the key may have been rotated by another instance of the service
+		if keys, err = e.dataKeys(database, true); err != nil {
+			return nil, err
+		}
+		if aead, ok = keys.aeads[version]; !ok {
+			return nil, fmt.Errorf("unknown data key version %d for database %s", version, database)
+		}
+	}
+	if len(value) < aead.NonceSize() {
+		return nil, errors.New("encrypted value is truncated")
+	}
+	return aead.Open(nil, value[:aead.NonceSize()], value[aead.NonceSize():], []byte(database))
+}
+
+// Rotate adds a new data key to the database, which is used for every value written from now
+// on. Previous keys are kept so values sealed with them can still be read until rewritten.
+func (e *Encryption) Rotate(database string) (uint32, error) {
+	e.Lock()
+	defer e.Unlock()
+
+	var stored *storedKeys
+	for attempt := 0; ; attempt++ {
+		var err error
+		if stored, err = e.readKeys(database); err != nil {
+			return 0, err
+		}
+		prev := stored.Current
+		if err := e.addKey(stored); err != nil {
+			return 0, err
+		}
+		err = e.writeKeys(database, stored, prev)
+		if err == errKeysChanged && attempt < maxKeyWriteAttempts {
+			// another instance rotated the keys, add ours on top of theirs
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		break
+	}
+	keys, err := e.unwrap(stored)
+	if err != nil {
+		return 0, err
+	}
+	e.keys[database] = keys
+	return keys.current, nil
+}
+
+// version returns the version of the data key a value was sealed with
+func (e *Encryption) version(value []byte) (uint32, bool) {
+	if !bytes.HasPrefix(value, encryptedPrefix) || len(value) < len(encryptedPrefix)+4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(value[len(encryptedPrefix):]), true
+}
+
+// lock returns the lock held while values of the database are rewritten
+func (e *Encryption) lock(database string) *sync.RWMutex {
+	e.Lock()
+	defer e.Unlock()
+	l, ok := e.locks[database]
+	if !ok {
+		l = &sync.RWMutex{}
+		e.locks[database] = l
+	}
+	return l
+}
+
+// dataKeys returns the data keys of a database, creating the first one if it has none
+func (e *Encryption) dataKeys(database string, reload bool) (*dataKeys, error) {
+	e.Lock()
+	defer e.Unlock()
+
+	if keys, ok := e.keys[database]; ok && !reload {
+		return keys, nil
+	}
+	stored, err := e.readKeys(database)
+	if err != nil {
+		return nil, err
+	}
+	if len(stored.Keys) == 0 {
+		if err := e.addKey(stored); err != nil {
+			return nil, err
+		}
+		// another instance may have created a key first, in which case that one is used
+		if err := e.writeKeys(database, stored, 0); err != nil && err != errKeysChanged {
+			return nil, err
+		}
+		if stored, err = e.readKeys(database); err != nil {
+			return nil, err
+		}
+	}
+	keys, err := e.unwrap(stored)
+	if err != nil {
+		return nil, err
+	}
+	e.keys[database] = keys
+	return keys, nil
+}
+
+// addKey generates a data key, wraps it and makes it the current key
+func (e *Encryption) addKey(stored *storedKeys) error {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return err
+	}
+	nonce := make([]byte, e.master.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	stored.Current++
+	stored.Keys[strconv.FormatUint(uint64(stored.Current), 10)] = e.master.Seal(nonce, nonce, key, nil)
+	return nil
+}
+
+// unwrap decrypts the stored data keys with the master key
+func (e *Encryption) unwrap(stored *storedKeys) (*dataKeys, error) {
+	keys := &dataKeys{current: stored.Current, aeads: make(map[uint32]cipher.AEAD, len(stored.Keys))}
+	for v, wrapped := range stored.Keys {
+		version, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid data key version %s", v)
+		}
+		n := e.master.NonceSize()
+		if len(wrapped) < n {
+			return nil, fmt.Errorf("data key %s is truncated", v)
+		}
+		key, err := e.master.Open(nil, wrapped[:n], wrapped[n:], nil)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't unwrap data key %s, is the master key correct? %w", v, err)
+		}
+		if keys.aeads[uint32(version)], err = newAEAD(key); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := keys.aeads[keys.current]; !ok {
+		return nil, fmt.Errorf("current data key %d is missing", keys.current)
+	}
+	return keys, nil
+}
+
+func (e *Encryption) readKeys(database string) (*storedKeys, error) {
+	stored := &storedKeys{Keys: make(map[string][]byte)}
+	recs, err := e.store.Read("datakeys/"+database, store.ReadFrom("micro", "internal"))
+	if err == store.ErrNotFound {
+		return stored, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("couldn't read data keys of %s: %w", database, err)
+	}
+	if err := json.Unmarshal(recs[0].Value, stored); err != nil {
+		return nil, fmt.Errorf("couldn't read data keys of %s: %w", database, err)
+	}
+	return stored, nil
+}
+
+// writeKeys writes the data keys of a database if the current key is still the version
+// they were read at, so keys stored by another instance are never overwritten. It returns
+// errKeysChanged if they were.
+func (e *Encryption) writeKeys(database string, stored *storedKeys, prev uint32) error {
+	existing, err := e.readKeys(database)
+	if err != nil {
+		return err
+	}
+	if existing.Current != prev {
+		return errKeysChanged
+	}
+	b, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	if err := e.store.Write(&store.Record{Key: "datakeys/" + database, Value: b}, store.WriteTo("micro", "internal")); err != nil {
+		return fmt.Errorf("couldn't write data keys of %s: %w", database, err)
+	}
+	return nil
+}
+
+// encrypts returns true if the values of a database are encrypted. The micro database holds
+// the data keys, quotas and catalogue of the store, which are read without decrypting them,
+// so it's never encrypted.
+func (s *Store) encrypts(database string) bool {
+	return s.Encryption != nil && database != "micro"
+}
+
+// reencrypt rewrites every value in the database which wasn't sealed with the given data key
+func (s *Store) reencrypt(database string, version uint32) {
+	tables, err := s.catalogueTables(database)
+	if err != nil {
+		log.Errorf("Couldn't rewrite %s with data key %d: %v", database, version, err)
+		return
+	}
+	l := s.Encryption.lock(database)
+	var count, failed int
+	for _, t := range tables {
+		keys, err := s.Default.List(store.ListFrom(database, t))
+		if err != nil {
+			log.Errorf("Couldn't list %s/%s to rewrite it with data key %d: %v", database, t, version, err)
+			failed++
+			continue
+		}
+		for _, k := range keys {
+			// block writes through this service so a newer value is never overwritten
+			l.Lock()
+			rewritten, err := s.reencryptRecord(database, t, k, version)
+			l.Unlock()
+			if err != nil {
+				log.Errorf("Couldn't rewrite %s in %s/%s with data key %d: %v", k, database, t, version, err)
+				failed++
+			} else if rewritten {
+				count++
+			}
+		}
+	}
+	log.Infof("Rewrote %d values in %s with data key %d, %d failed", count, database, version, failed)
+}
+
+// reencryptRecord rewrites a record with the given data key if it was sealed with another one
+func (s *Store) reencryptRecord(database, table, key string, version uint32) (bool, error) {
+	recs, err := s.Default.Read(key, store.ReadFrom(database, table))
+	if err == store.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	r := recs[0]
+	if v, ok := s.Encryption.version(r.Value); ok && v >= version {
+		return false, nil
+	}
+	value, err := s.Encryption.Decrypt(database, r.Value)
+	if err != nil {
+		return false, err
+	}
+	if r.Value, err = s.Encryption.Encrypt(database, value); err != nil {
+		return false, err
+	}
+	return true, s.Default.Write(r, store.WriteTo(database, table))
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -3,15 +3,13 @@ package handler
 import (
 	"context"
 	"io"
-	"strings"
 	"sync"
 	"time"
 
 	"c-z.dev/go-micro/errors"
 	"c-z.dev/go-micro/metadata"
 	"c-z.dev/go-micro/store"
-	pb "c-z.dev/go-micro/store/service/proto"
-	"c-z.dev/micro/internal/namespace"
+	pb "c-z.dev/micro/service/store/proto"
 )
 
 type Store struct {
@@ -25,22 +23,35 @@ type Store struct {
 	sync.RWMutex
 
 	Stores map[string]bool
+
+	// Quota is the default quota of every database
+	Quota Quota
+
+	// Encryption encrypts values at rest if set
+	Encryption *Encryption
+
+	// Open trusts calls without an account to use every database, it's only set when auth
+	// is disabled
+	Open bool
+
+	// usage of the databases with a quota
+	usageMtx sync.Mutex
+	usage    map[string]*databaseUsage
+
+	// versions serialise versioned writes to a key
+	versions versionLocks
+
+	// changeLog sends changes to watchers, it is started by the first change
+	changesOnce sync.Once
+	changeLog   *changeLog
 }
 
 // TODO: remove this horrible bs
-func (s *Store) get(ctx context.Context, database, table string) (string, string) {
+func (s *Store) get(ctx context.Context, database, table string) (string, string, error) {
 	// lock (might be a race)
 	s.Lock()
 	defer s.Unlock()
 
-	// get the namespace from context
-	ns := namespace.FromContext(ctx)
-	// we're using "micro" as the database"
-	// TODO: change default namespace to micro
-	if ns == "go.micro" {
-		ns = "micro"
-	}
-
 	// retrieve values from metadata
 	// TODO: switch to options
 	if md, ok := metadata.FromContext(ctx); ok {
@@ -53,9 +64,10 @@ func (s *Store) get(ctx context.Context, database, table string) (string, string
 		}
 	}
 
-	// set the database to the namespace
-	if len(ns) > 0 {
-		database = ns
+	// use the database of the namespace unless the caller can use another
+	database, err := s.authorize(ctx, database)
+	if err != nil {
+		return "", "", err
 	}
 
 	// reset database to options if not set
@@ -70,7 +82,13 @@ func (s *Store) get(ctx context.Context, database, table string) (string, string
 
 	// just use the default if nothing is specified
 	if len(database) == 0 && len(table) == 0 {
-		return "micro", "store"
+		return "micro", "store", nil
+	}
+
+	// quotas, data keys, versions, journals and indexes are kept in micro/internal
+	if database == "micro" && table == "internal" && !s.wantsMicro(ctx) {
+		return "", "", errors.Forbidden("go.micro.store", "micro/internal is reserved for the store, it requires the %s scope and the %s header",
+			AdminScope, MicroDatabaseHeader)
 	}
 
 	// attempt to get the database
@@ -83,7 +101,7 @@ func (s *Store) get(ctx context.Context, database, table string) (string, string
 	// save store
 	s.Stores[database+":"+table] = true
 
-	return database, table
+	return database, table, nil
 }
 
 func (s *Store) Read(ctx context.Context, req *pb.ReadRequest, rsp *pb.ReadResponse) error {
@@ -103,7 +121,10 @@ func (s *Store) Read(ctx context.Context, req *pb.ReadRequest, rsp *pb.ReadRespo
 	}
 
 	// get new store
-	database, table = s.get(ctx, database, table)
+	database, table, err := s.get(ctx, database, table)
+	if err != nil {
+		return err
+	}
 	opts = append(opts, store.ReadFrom(database, table))
 
 	vals, err := s.Default.Read(req.Key, opts...)
@@ -113,11 +134,31 @@ func (s *Store) Read(ctx context.Context, req *pb.ReadRequest, rsp *pb.ReadRespo
 		return errors.InternalServerError("go.micro.store", err.Error())
 	}
 
+	// the versions of a prefix read are read together rather than one record at a time
+	var versions map[string]uint64
+	if req.Options.GetPrefix() {
+		if versions, err = s.prefixVersions(database, table, req.Key); err != nil {
+			return errors.InternalServerError("go.micro.store", "couldn't read the versions of %s: %v", req.Key, err)
+		}
+	}
+
 	for _, val := range vals {
+		if s.Encryption != nil {
+			if val.Value, err = s.Encryption.Decrypt(database, val.Value); err != nil {
+				return errors.InternalServerError("go.micro.store", "couldn't decrypt %s: %v", val.Key, err)
+			}
+		}
+		version := versions[val.Key]
+		if !req.Options.GetPrefix() {
+			if version, err = s.version(database, table, val.Key); err != nil {
+				return errors.InternalServerError("go.micro.store", "couldn't read the version of %s: %v", val.Key, err)
+			}
+		}
 		rsp.Records = append(rsp.Records, &pb.Record{
-			Key:    val.Key,
-			Value:  val.Value,
-			Expiry: int64(val.Expiry.Seconds()),
+			Key:     val.Key,
+			Value:   val.Value,
+			Expiry:  int64(val.Expiry.Seconds()),
+			Version: version,
 		})
 	}
 	return nil
@@ -136,7 +177,10 @@ func (s *Store) Write(ctx context.Context, req *pb.WriteRequest, rsp *pb.WriteRe
 	}
 
 	// get new store
-	database, table = s.get(ctx, database, table)
+	database, table, err := s.get(ctx, database, table)
+	if err != nil {
+		return err
+	}
 
 	if req.Record == nil {
 		return errors.BadRequest("go.micro.store", "no record specified")
@@ -151,13 +195,74 @@ func (s *Store) Write(ctx context.Context, req *pb.WriteRequest, rsp *pb.WriteRe
 	var opts []store.WriteOption
 	opts = append(opts, store.WriteTo(database, table))
 
-	err := s.Default.Write(record, opts...)
+	// the value size is checked before the value is encrypted
+	q, err := s.quota(database)
+	if err != nil {
+		return errors.InternalServerError("go.micro.store", err.Error())
+	}
+	if err := q.checkValueSize(database, record.Key, len(record.Value)); err != nil {
+		return err
+	}
+
+	// the version is checked and bumped under the lock of the key
+	l := s.versions.lock(database, table, record.Key)
+	l.Lock()
+	defer l.Unlock()
+
+	last, exists, err := s.checkVersion(database, table, record, &versionCheck{
+		ifVersion:   req.Options.GetIfVersion(),
+		ifNotExists: req.Options.GetIfNotExists(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if s.encrypts(database) {
+		// values are rewritten under this lock while a key is rotated
+		l := s.Encryption.lock(database)
+		l.RLock()
+		defer l.RUnlock()
+
+		if record.Value, err = s.Encryption.Encrypt(database, record.Value); err != nil {
+			return errors.InternalServerError("go.micro.store", "couldn't encrypt %s: %v", record.Key, err)
+		}
+	}
+
+	done, err := s.reserveWrite(database, table, q, record)
+	if err != nil {
+		return err
+	}
+	err = s.Default.Write(record, opts...)
+	done(err == nil)
 	if err != nil && err == store.ErrNotFound {
 		return errors.NotFound("go.micro.store", err.Error())
 	} else if err != nil {
 		return errors.InternalServerError("go.micro.store", err.Error())
 	}
 
+	if err := s.writeVersion(database, table, record, last+1); err != nil {
+		return errors.InternalServerError("go.micro.store", "couldn't write the version of %s: %v", record.Key, err)
+	}
+	rsp.Version = last + 1
+
+	indexed := &store.Record{Key: record.Key, Value: req.Record.Value, Expiry: record.Expiry}
+	if err := s.updateIndexes(database, table, indexed, false); err != nil {
+		return errors.InternalServerError("go.micro.store", "%s was written but couldn't be indexed: %v", record.Key, err)
+	}
+
+	typ := changeCreate
+	if exists {
+		typ = changeUpdate
+	}
+	s.changes().publish(&change{
+		typ:       typ,
+		database:  database,
+		table:     table,
+		record:    &store.Record{Key: record.Key, Value: req.Record.Value, Expiry: record.Expiry},
+		version:   rsp.Version,
+		timestamp: time.Now(),
+	})
+
 	return nil
 }
 
@@ -174,47 +279,149 @@ func (s *Store) Delete(ctx context.Context, req *pb.DeleteRequest, rsp *pb.Delet
 	}
 
 	// get new store
-	database, table = s.get(ctx, database, table)
+	database, table, err := s.get(ctx, database, table)
+	if err != nil {
+		return err
+	}
 
 	var opts []store.DeleteOption
 	opts = append(opts, store.DeleteFrom(database, table))
 
-	if err := s.Default.Delete(req.Key, opts...); err == store.ErrNotFound {
+	l := s.versions.lock(database, table, req.Key)
+	l.Lock()
+	defer l.Unlock()
+
+	done, err := s.reserveDelete(database, table, req.Key)
+	if err != nil {
+		return err
+	}
+	err = s.Default.Delete(req.Key, opts...)
+	done(err == nil)
+	if err == store.ErrNotFound {
 		return errors.NotFound("go.micro.store", err.Error())
 	} else if err != nil {
 		return errors.InternalServerError("go.micro.store", err.Error())
 	}
+	if err := s.deleteVersion(database, table, req.Key); err != nil {
+		return errors.InternalServerError("go.micro.store", "couldn't delete the version of %s: %v", req.Key, err)
+	}
+	if err := s.updateIndexes(database, table, &store.Record{Key: req.Key}, true); err != nil {
+		return errors.InternalServerError("go.micro.store", "%s was deleted but its index entries couldn't be removed: %v", req.Key, err)
+	}
+	s.changes().publish(&change{
+		typ:       changeDelete,
+		database:  database,
+		table:     table,
+		record:    &store.Record{Key: req.Key},
+		timestamp: time.Now(),
+	})
 	return nil
 }
 
-// TODO: lock down to admin?
+// Databases lists every database to admins, anyone else only sees their own
 func (s *Store) Databases(ctx context.Context, req *pb.DatabasesRequest, rsp *pb.DatabasesResponse) error {
-	recs, err := s.Default.Read("databases/", store.ReadPrefix(), store.ReadFrom("micro", "internal"))
+	if !s.isAdmin(ctx) {
+		if db := ownDatabase(ctx); len(db) > 0 {
+			rsp.Databases = []string{db}
+		}
+		return nil
+	}
+	databases, err := s.catalogueDatabases()
 	if err != nil {
 		return errors.InternalServerError("go.micro.store", err.Error())
 	}
-	rsp.Databases = make([]string, len(recs))
-	for i, r := range recs {
-		rsp.Databases[i] = strings.TrimPrefix(r.Key, "databases/")
-	}
+	rsp.Databases = databases
 	return nil
 }
 
-// TODO: lock down to admin?
+// Tables lists the tables of a database, only admins can list those outside their namespace
 func (s *Store) Tables(ctx context.Context, req *pb.TablesRequest, rsp *pb.TablesResponse) error {
-	recs, err := s.Default.Read("tables/"+req.Database+"/", store.ReadPrefix(), store.ReadFrom("micro", "internal"))
+	database, err := s.authorize(ctx, req.Database)
+	if err != nil {
+		return err
+	}
+	tables, err := s.catalogueTables(database)
 	if err != nil {
 		return errors.InternalServerError("go.micro.store", err.Error())
 	}
-	rsp.Tables = make([]string, len(recs))
-	for i, r := range recs {
-		rsp.Tables[i] = strings.TrimPrefix(r.Key, "tables/"+req.Database+"/")
+	rsp.Tables = tables
+	return nil
+}
+
+// Usage reports the number of keys and bytes in every table of a database along with its quota.
+// Admins see every database if none is requested, anyone else only their own.
+func (s *Store) Usage(ctx context.Context, req *pb.UsageRequest, rsp *pb.UsageResponse) error {
+	var databases []string
+	if len(req.Database) == 0 && s.isAdmin(ctx) {
+		var err error
+		if databases, err = s.catalogueDatabases(); err != nil {
+			return errors.InternalServerError("go.micro.store", err.Error())
+		}
+	} else {
+		db, err := s.authorize(ctx, req.Database)
+		if err != nil {
+			return err
+		}
+		databases = []string{db}
+	}
+
+	rsp.Quotas = make(map[string]*pb.Quota)
+	for _, db := range databases {
+		tables, err := s.catalogueTables(db)
+		if err != nil {
+			return errors.InternalServerError("go.micro.store", err.Error())
+		}
+		for _, t := range tables {
+			u, err := s.tableUsage(db, t)
+			if err != nil {
+				return errors.InternalServerError("go.micro.store", err.Error())
+			}
+			rsp.Usage = append(rsp.Usage, &pb.Usage{Database: db, Table: t, Keys: u.keys, Bytes: u.bytes})
+		}
+		q, err := s.quota(db)
+		if err != nil {
+			return errors.InternalServerError("go.micro.store", err.Error())
+		}
+		if !q.unlimited() {
+			rsp.Quotas[db] = &pb.Quota{Keys: q.Keys, Bytes: q.Bytes, ValueSize: q.ValueSize}
+		}
 	}
 	return nil
 }
 
+// RotateKey adds a new data key to a database and rewrites its values with it in the background
+func (s *Store) RotateKey(ctx context.Context, req *pb.RotateKeyRequest, rsp *pb.RotateKeyResponse) error {
+	if s.Encryption == nil {
+		return errors.BadRequest("go.micro.store", "encryption is not enabled")
+	}
+	database, err := s.authorize(ctx, req.Database)
+	if err != nil {
+		return err
+	}
+	if len(database) == 0 {
+		return errors.BadRequest("go.micro.store", "database is required")
+	}
+	if database == "micro" {
+		return errors.BadRequest("go.micro.store", "the micro database isn't encrypted")
+	}
+
+	version, err := s.Encryption.Rotate(database)
+	if err != nil {
+		return errors.InternalServerError("go.micro.store", err.Error())
+	}
+	go s.reencrypt(database, version)
+
+	rsp.Version = version
+	return nil
+}
+
+// listBatchSize is the number of keys read from the store and sent on a List stream at once
+const listBatchSize = 1000
+
 func (s *Store) List(ctx context.Context, req *pb.ListRequest, stream pb.Store_ListStream) error {
 	var database, table string
+	var limit, offset uint
+	var opts []store.ListOption
 
 	if req.Options != nil {
 		if db := req.Options.Database; len(db) > 0 {
@@ -223,34 +430,76 @@ func (s *Store) List(ctx context.Context, req *pb.ListRequest, stream pb.Store_L
 		if tb := req.Options.Table; len(tb) > 0 {
 			table = tb
 		}
+		if p := req.Options.Prefix; len(p) > 0 {
+			opts = append(opts, store.ListPrefix(p))
+		}
+		if sf := req.Options.Suffix; len(sf) > 0 {
+			opts = append(opts, store.ListSuffix(sf))
+		}
+		limit = uint(req.Options.Limit)
+		offset = uint(req.Options.Offset)
 	}
 
 	// get new store
-	database, table = s.get(ctx, database, table)
-
-	var opts []store.ListOption
+	database, table, err := s.get(ctx, database, table)
+	if err != nil {
+		return err
+	}
 	opts = append(opts, store.ListFrom(database, table))
 
-	vals, err := s.Default.List(opts...)
-	if err != nil && err == store.ErrNotFound {
-		return errors.NotFound("go.micro.store", err.Error())
-	} else if err != nil {
-		return errors.InternalServerError("go.micro.store", err.Error())
-	}
+	// page through the store so only one batch of keys is held at a time
+	var first string
+	for {
+		size := uint(listBatchSize)
+		if limit > 0 && limit < size {
+			size = limit
+		}
+		keys, err := s.Default.List(append(opts, store.ListLimit(size), store.ListOffset(offset))...)
+		if err != nil && err == store.ErrNotFound {
+			return errors.NotFound("go.micro.store", err.Error())
+		} else if err != nil {
+			return errors.InternalServerError("go.micro.store", err.Error())
+		}
+		// a store which ignores the offset returns the same page again
+		if len(keys) > 0 && offset > 0 && keys[0] == first {
+			return nil
+		}
+		if len(keys) > 0 {
+			first = keys[0]
+		}
 
-	rsp := new(pb.ListResponse)
+		err = sendKeys(stream, keys)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.InternalServerError("go.micro.store", err.Error())
+		}
 
-	// TODO: batch sync
-	for _, val := range vals {
-		rsp.Keys = append(rsp.Keys, val)
+		// a store which doesn't support paging returns every key at once
+		if uint(len(keys)) != size {
+			return nil
+		}
+		offset += size
+		if limit > 0 {
+			if limit -= size; limit == 0 {
+				return nil
+			}
+		}
 	}
+}
 
-	err = stream.Send(rsp)
-	if err == io.EOF {
-		return nil
-	}
-	if err != nil {
-		return errors.InternalServerError("go.micro.store", err.Error())
+// sendKeys sends the keys on the stream in batches of listBatchSize
+func sendKeys(stream pb.Store_ListStream, keys []string) error {
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > listBatchSize {
+			n = listBatchSize
+		}
+		if err := stream.Send(&pb.ListResponse{Keys: keys[:n]}); err != nil {
+			return err
+		}
+		keys = keys[n:]
 	}
 	return nil
 }
@@ -0,0 +1,797 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"c-z.dev/go-micro/auth"
+	"c-z.dev/go-micro/errors"
+	"c-z.dev/go-micro/metadata"
+	"c-z.dev/go-micro/store"
+	"c-z.dev/go-micro/store/memory"
+	"c-z.dev/micro/internal/namespace"
+	pb "c-z.dev/micro/service/store/proto"
+)
+
+// listStream records the responses sent on a List stream
+type listStream struct {
+	pb.Store_ListStream
+	responses []*pb.ListResponse
+}
+
+func (l *listStream) Send(rsp *pb.ListResponse) error {
+	l.responses = append(l.responses, rsp)
+	return nil
+}
+
+// watchStream passes the responses sent on a Watch stream to a channel
+type watchStream struct {
+	pb.Store_WatchStream
+	responses chan *pb.WatchResponse
+}
+
+func (w *watchStream) Send(rsp *pb.WatchResponse) error {
+	w.responses <- rsp
+	return nil
+}
+
+func newTestStore(t *testing.T) *Store {
+	s := &Store{
+		Default: memory.NewStore(),
+		New:     func(string, string) (store.Store, error) { return nil, nil },
+		Stores:  make(map[string]bool),
+		Open:    true,
+	}
+	for i := 0; i < 2500; i++ {
+		key := fmt.Sprintf("key-%04d", i)
+		if i%2 == 1 {
+			key += "-odd"
+		}
+		if err := s.Default.Write(&store.Record{Key: key}, store.WriteTo("test", "list")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestList(t *testing.T) {
+	s := newTestStore(t)
+
+	for name, tc := range map[string]struct {
+		options *pb.ListOptions
+		keys    int
+		batches int
+	}{
+		"all":    {&pb.ListOptions{}, 2500, 3},
+		"limit":  {&pb.ListOptions{Limit: 1200}, 1200, 2},
+		"offset": {&pb.ListOptions{Offset: 2000}, 500, 1},
+		"prefix": {&pb.ListOptions{Prefix: "key-00"}, 100, 1},
+		"suffix": {&pb.ListOptions{Suffix: "-odd", Limit: 10, Offset: 5}, 10, 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tc.options.Database = "test"
+			tc.options.Table = "list"
+			stream := &listStream{}
+			if err := s.List(context.Background(), &pb.ListRequest{Options: tc.options}, stream); err != nil {
+				t.Fatal(err)
+			}
+			if len(stream.responses) != tc.batches {
+				t.Errorf("expected %d batches, got %d", tc.batches, len(stream.responses))
+			}
+			seen := make(map[string]bool)
+			for _, rsp := range stream.responses {
+				if len(rsp.Keys) > listBatchSize {
+					t.Errorf("expected batches of at most %d keys, got %d", listBatchSize, len(rsp.Keys))
+				}
+				for _, k := range rsp.Keys {
+					if seen[k] {
+						t.Errorf("key %s was sent twice", k)
+					}
+					seen[k] = true
+				}
+			}
+			if len(seen) != tc.keys {
+				t.Errorf("expected %d keys, got %d", tc.keys, len(seen))
+			}
+		})
+	}
+}
+
+// catalogue records new databases and tables in the internal table like the store service
+func catalogue(s *Store) func(string, string) (store.Store, error) {
+	return func(database, table string) (store.Store, error) {
+		s.Default.Write(&store.Record{Key: "databases/" + database}, store.WriteTo("micro", "internal"))
+		s.Default.Write(&store.Record{Key: "tables/" + database + "/" + table}, store.WriteTo("micro", "internal"))
+		return s.Default, nil
+	}
+}
+
+func TestQuota(t *testing.T) {
+	s := &Store{
+		Default: memory.NewStore(),
+		Stores:  make(map[string]bool),
+		Open:    true,
+		Quota:   Quota{Keys: 2, ValueSize: 4},
+	}
+	s.New = catalogue(s)
+	ctx := context.Background()
+	opts := &pb.WriteOptions{Database: "team", Table: "data"}
+	write := func(key, value string) error {
+		return s.Write(ctx, &pb.WriteRequest{Record: &pb.Record{Key: key, Value: []byte(value)}, Options: opts}, &pb.WriteResponse{})
+	}
+	forbidden := func(err error) bool {
+		e, ok := err.(*errors.Error)
+		return ok && e.Code == 403
+	}
+
+	for _, k := range []string{"a", "b", "a"} {
+		if err := write(k, "1"); err != nil {
+			t.Fatalf("unexpected error writing %s: %v", k, err)
+		}
+	}
+	if err := write("c", "1"); !forbidden(err) {
+		t.Errorf("expected the key limit to be enforced, got %v", err)
+	}
+	if err := write("a", "12345"); !forbidden(err) {
+		t.Errorf("expected the value size limit to be enforced, got %v", err)
+	}
+	err := s.Delete(ctx, &pb.DeleteRequest{Key: "b", Options: &pb.DeleteOptions{Database: "team", Table: "data"}}, &pb.DeleteResponse{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := write("c", "1"); err != nil {
+		t.Errorf("expected a key to be freed by the delete, got %v", err)
+	}
+
+	rsp := &pb.UsageResponse{}
+	if err := s.Usage(ctx, &pb.UsageRequest{Database: "team"}, rsp); err != nil {
+		t.Fatal(err)
+	}
+	if len(rsp.Usage) != 1 || rsp.Usage[0].Keys != 2 || rsp.Usage[0].Bytes != 4 {
+		t.Errorf("expected 2 keys and 4 bytes in team/data, got %v", rsp.Usage)
+	}
+	if q := rsp.Quotas["team"]; q == nil || q.Keys != 2 || q.ValueSize != 4 {
+		t.Errorf("expected the quota of team to be reported, got %v", rsp.Quotas)
+	}
+
+	// admins can set the quota of a database in place of the default
+	setQuota := func(ctx context.Context, req *pb.SetQuotaRequest) error {
+		return s.SetQuota(ctx, req, &pb.SetQuotaResponse{})
+	}
+	userCtx := auth.ContextWithAccount(ctx, &auth.Account{ID: "alice", Issuer: "team", Scopes: []string{"user"}})
+	if err := setQuota(userCtx, &pb.SetQuotaRequest{Database: "team", Quota: &pb.Quota{Keys: 10}}); !forbidden(err) {
+		t.Errorf("expected setting a quota to require the admin scope, got %v", err)
+	}
+	err = setQuota(ctx, &pb.SetQuotaRequest{Database: "team", Quota: &pb.Quota{Bytes: 4, ValueSize: 8}})
+	if e, ok := err.(*errors.Error); !ok || e.Code != 400 {
+		t.Errorf("expected a value size limit above the size limit to be refused, got %v", err)
+	}
+	if err := setQuota(ctx, &pb.SetQuotaRequest{Database: "team", Quota: &pb.Quota{Keys: 3}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := write("d", "12345"); err != nil {
+		t.Errorf("expected the quota to replace the default, got %v", err)
+	}
+	if err := setQuota(ctx, &pb.SetQuotaRequest{Database: "team", Remove: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := write("e", "1"); !forbidden(err) {
+		t.Errorf("expected the default quota to apply once the quota is removed, got %v", err)
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	s := &Store{
+		Default: memory.NewStore(),
+		Stores:  make(map[string]bool),
+		Open:    true,
+	}
+	s.New = catalogue(s)
+	var err error
+	if s.Encryption, err = NewEncryption(s.Default, bytes.Repeat([]byte{1}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	s.Default.Write(&store.Record{Key: "legacy", Value: []byte("plain")}, store.WriteTo("team", "data"))
+	err = s.Write(ctx, &pb.WriteRequest{
+		Record:  &pb.Record{Key: "secret", Value: []byte("value")},
+		Options: &pb.WriteOptions{Database: "team", Table: "data"},
+	}, &pb.WriteResponse{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := func(key string) []byte {
+		recs, err := s.Default.Read(key, store.ReadFrom("team", "data"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return recs[0].Value
+	}
+	read := func(key string) string {
+		rsp := &pb.ReadResponse{}
+		err := s.Read(ctx, &pb.ReadRequest{Key: key, Options: &pb.ReadOptions{Database: "team", Table: "data"}}, rsp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(rsp.Records[0].Value)
+	}
+
+	if v, ok := s.Encryption.version(raw("secret")); !ok || v != 1 || bytes.Contains(raw("secret"), []byte("value")) {
+		t.Errorf("expected the value to be sealed with data key 1, got %q", raw("secret"))
+	}
+	for k, v := range map[string]string{"secret": "value", "legacy": "plain"} {
+		if got := read(k); got != v {
+			t.Errorf("expected %s=%s, got %s", k, v, got)
+		}
+	}
+
+	version, err := s.Encryption.Rotate("team")
+	if err != nil || version != 2 {
+		t.Fatalf("expected data key 2, got %d %v", version, err)
+	}
+	s.reencrypt("team", version)
+	for k, v := range map[string]string{"secret": "value", "legacy": "plain"} {
+		if kv, ok := s.Encryption.version(raw(k)); !ok || kv != 2 {
+			t.Errorf("expected %s to be sealed with data key 2", k)
+		}
+		if got := read(k); got != v {
+			t.Errorf("expected %s=%s after rotation, got %s", k, v, got)
+		}
+	}
+
+	// another master key can't unwrap the data keys
+	other, _ := NewEncryption(s.Default, bytes.Repeat([]byte{2}, 32))
+	if _, err := other.Decrypt("team", raw("secret")); err == nil {
+		t.Error("expected decrypting with the wrong master key to fail")
+	}
+
+	// keys read before another instance stored its own aren't written over them
+	second, _ := NewEncryption(s.Default, bytes.Repeat([]byte{1}, 32))
+	stale := &storedKeys{Keys: make(map[string][]byte)}
+	second.addKey(stale)
+	if err := second.writeKeys("team", stale, 0); err != errKeysChanged {
+		t.Errorf("expected the stored data keys to be kept, got %v", err)
+	}
+	if got := read("secret"); got != "value" {
+		t.Errorf("expected secret=value, got %s", got)
+	}
+
+	// the value size quota applies to the value before it's encrypted
+	s.Quota = Quota{ValueSize: 5}
+	err = s.Write(ctx, &pb.WriteRequest{
+		Record:  &pb.Record{Key: "sized", Value: []byte("12345")},
+		Options: &pb.WriteOptions{Database: "team", Table: "data"},
+	}, &pb.WriteResponse{})
+	if err != nil {
+		t.Errorf("expected a value at the limit to be written, got %v", err)
+	}
+}
+
+func TestEncryptionInternal(t *testing.T) {
+	s := &Store{
+		Default: memory.NewStore(),
+		Stores:  make(map[string]bool),
+		Open:    true,
+	}
+	s.New = catalogue(s)
+	var err error
+	if s.Encryption, err = NewEncryption(s.Default, bytes.Repeat([]byte{1}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	ctx := metadata.Set(context.Background(), MicroDatabaseHeader, "true")
+	write := func(database, table, key, value string) error {
+		return s.Write(ctx, &pb.WriteRequest{
+			Record:  &pb.Record{Key: key, Value: []byte(value)},
+			Options: &pb.WriteOptions{Database: database, Table: table},
+		}, &pb.WriteResponse{})
+	}
+
+	// a quota written by an admin is stored as is and enforced
+	if err := write("micro", "internal", "quotas/team", `{"value_size": 4}`); err != nil {
+		t.Fatal(err)
+	}
+	recs, err := s.Default.Read("quotas/team", store.ReadFrom("micro", "internal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Encryption.version(recs[0].Value); ok {
+		t.Error("expected the micro database not to be encrypted")
+	}
+	if err := write("team", "data", "a", "1234"); err != nil {
+		t.Fatalf("expected a write within the quota, got %v", err)
+	}
+	if err := write("team", "data", "b", "12345"); err == nil {
+		t.Error("expected the quota to be enforced")
+	}
+
+	// the data keys in the micro database are never sealed with a data key
+	err = s.RotateKey(ctx, &pb.RotateKeyRequest{Database: "micro"}, &pb.RotateKeyResponse{})
+	if e, ok := err.(*errors.Error); !ok || e.Code != 400 {
+		t.Errorf("expected rotating the micro database to be refused, got %v", err)
+	}
+}
+
+func TestVersions(t *testing.T) {
+	s := &Store{
+		Default: memory.NewStore(),
+		Stores:  make(map[string]bool),
+		Open:    true,
+	}
+	s.New = catalogue(s)
+	ctx := context.Background()
+	write := func(key string, opts *pb.WriteOptions) (uint64, error) {
+		opts.Database, opts.Table = "team", "data"
+		rsp := &pb.WriteResponse{}
+		err := s.Write(ctx, &pb.WriteRequest{Record: &pb.Record{Key: key, Value: []byte("v")}, Options: opts}, rsp)
+		return rsp.Version, err
+	}
+	conflict := func(err error) bool {
+		e, ok := err.(*errors.Error)
+		return ok && e.Code == 409
+	}
+
+	if v, err := write("a", &pb.WriteOptions{IfNotExists: true}); err != nil || v != 1 {
+		t.Fatalf("expected version 1, got %d %v", v, err)
+	}
+	if _, err := write("a", &pb.WriteOptions{IfNotExists: true}); !conflict(err) {
+		t.Errorf("expected a conflict writing an existing key, got %v", err)
+	}
+	if v, err := write("a", &pb.WriteOptions{IfVersion: 1}); err != nil || v != 2 {
+		t.Fatalf("expected version 2, got %d %v", v, err)
+	}
+	if _, err := write("a", &pb.WriteOptions{IfVersion: 1}); !conflict(err) {
+		t.Errorf("expected a conflict writing a stale version, got %v", err)
+	}
+	if _, err := write("b", &pb.WriteOptions{IfVersion: 1}); !conflict(err) {
+		t.Errorf("expected a conflict writing a missing key, got %v", err)
+	}
+	if v, err := write("a", &pb.WriteOptions{}); err != nil || v != 3 {
+		t.Fatalf("expected an unconditional write to bump the version to 3, got %d %v", v, err)
+	}
+
+	rsp := &pb.ReadResponse{}
+	if err := s.Read(ctx, &pb.ReadRequest{Key: "a", Options: &pb.ReadOptions{Database: "team", Table: "data"}}, rsp); err != nil {
+		t.Fatal(err)
+	}
+	if rsp.Records[0].Version != 3 {
+		t.Errorf("expected to read version 3, got %d", rsp.Records[0].Version)
+	}
+	if _, err := write("ab", &pb.WriteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	rsp = &pb.ReadResponse{}
+	if err := s.Read(ctx, &pb.ReadRequest{Key: "a", Options: &pb.ReadOptions{Database: "team", Table: "data", Prefix: true}}, rsp); err != nil {
+		t.Fatal(err)
+	}
+	if len(rsp.Records) != 2 || rsp.Records[0].Version != 3 || rsp.Records[1].Version != 1 {
+		t.Errorf("expected versions 3 and 1 from a prefix read, got %v", rsp.Records)
+	}
+
+	err := s.Delete(ctx, &pb.DeleteRequest{Key: "a", Options: &pb.DeleteOptions{Database: "team", Table: "data"}}, &pb.DeleteResponse{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := write("a", &pb.WriteOptions{IfVersion: 3}); !conflict(err) {
+		t.Errorf("expected a conflict writing the version of a deleted key, got %v", err)
+	}
+	if v, err := write("a", &pb.WriteOptions{IfNotExists: true}); err != nil || v != 4 {
+		t.Errorf("expected a deleted key to carry on at version 4, got %d %v", v, err)
+	}
+	if _, err := write("a", &pb.WriteOptions{IfVersion: 1}); !conflict(err) {
+		t.Errorf("expected a conflict writing a version from before the delete, got %v", err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	s := &Store{
+		Default: memory.NewStore(),
+		Stores:  make(map[string]bool),
+		Open:    true,
+	}
+	s.New = catalogue(s)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream := &watchStream{responses: make(chan *pb.WatchResponse, 10)}
+	go s.Watch(ctx, &pb.WatchRequest{Prefix: "user/", Options: &pb.WatchOptions{Database: "team", Table: "data"}}, stream)
+	// wait for the watcher to be registered
+	for {
+		s.changes().Lock()
+		n := len(s.changes().watchers)
+		s.changes().Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	write := func(key, value string, expiry int64) {
+		err := s.Write(ctx, &pb.WriteRequest{
+			Record:  &pb.Record{Key: key, Value: []byte(value), Expiry: expiry},
+			Options: &pb.WriteOptions{Database: "team", Table: "data"},
+		}, &pb.WriteResponse{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("user/1", "a", 0)
+	write("other", "b", 0)
+	write("user/1", "c", 0)
+	err := s.Delete(ctx, &pb.DeleteRequest{Key: "user/1", Options: &pb.DeleteOptions{Database: "team", Table: "data"}}, &pb.DeleteResponse{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	write("user/2", "d", 1)
+
+	for _, expected := range []struct {
+		typ, key, value string
+	}{
+		{changeCreate, "user/1", "a"},
+		{changeUpdate, "user/1", "c"},
+		{changeDelete, "user/1", ""},
+		{changeCreate, "user/2", "d"},
+		{changeExpire, "user/2", ""},
+	} {
+		select {
+		case rsp := <-stream.responses:
+			if rsp.Type != expected.typ || rsp.Record.Key != expected.key || string(rsp.Record.Value) != expected.value {
+				t.Errorf("expected %s of %s=%q, got %s of %s=%q", expected.typ, expected.key, expected.value,
+					rsp.Type, rsp.Record.Key, rsp.Record.Value)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s of %s", expected.typ, expected.key)
+		}
+	}
+}
+
+func TestWatchExpiries(t *testing.T) {
+	c := newChangeLog(func(database, table, key string) bool { return true })
+	publish := func(typ, key string, expiry time.Duration) {
+		c.publish(&change{typ: typ, database: "team", table: "data", record: &store.Record{Key: key, Expiry: expiry}, timestamp: time.Now()})
+	}
+	pending := func() int {
+		c.Lock()
+		defer c.Unlock()
+		if len(c.expiries) != len(c.pending) {
+			t.Fatalf("expected an expiry per pending record, got %d for %d", len(c.expiries), len(c.pending))
+		}
+		return len(c.expiries)
+	}
+
+	// rewriting a record with a TTL moves its expiry rather than adding another
+	for i := 0; i < 100; i++ {
+		publish(changeUpdate, "a", time.Hour+time.Duration(i)*time.Second)
+	}
+	publish(changeCreate, "b", time.Hour)
+	if n := pending(); n != 2 {
+		t.Errorf("expected 2 expiries, got %d", n)
+	}
+
+	// and removing the TTL or deleting the record removes it
+	publish(changeUpdate, "a", 0)
+	publish(changeDelete, "b", 0)
+	if n := pending(); n != 0 {
+		t.Errorf("expected no expiries, got %d", n)
+	}
+}
+
+// failingStore fails writes of a key
+type failingStore struct {
+	store.Store
+	key string
+}
+
+func (f *failingStore) Write(r *store.Record, opts ...store.WriteOption) error {
+	if r.Key == f.key {
+		return fmt.Errorf("write of %s failed", r.Key)
+	}
+	return f.Store.Write(r, opts...)
+}
+
+func TestBatch(t *testing.T) {
+	backend := memory.NewStore()
+	s := &Store{
+		Default: &failingStore{Store: backend, key: "c"},
+		Stores:  make(map[string]bool),
+		Open:    true,
+	}
+	s.New = catalogue(s)
+	ctx := context.Background()
+	opts := &pb.WriteOptions{Database: "team", Table: "data"}
+	value := func(key string) string {
+		recs, err := backend.Read(key, store.ReadFrom("team", "data"))
+		if err == store.ErrNotFound {
+			return ""
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		return string(recs[0].Value)
+	}
+	records := func(kv ...string) []*pb.Record {
+		var recs []*pb.Record
+		for i := 0; i < len(kv); i += 2 {
+			recs = append(recs, &pb.Record{Key: kv[i], Value: []byte(kv[i+1])})
+		}
+		return recs
+	}
+
+	rsp := &pb.BatchWriteResponse{}
+	if err := s.BatchWrite(ctx, &pb.BatchWriteRequest{Records: records("a", "1", "b", "2"), Options: opts}, rsp); err != nil {
+		t.Fatal(err)
+	}
+	if len(rsp.Versions) != 2 || rsp.Versions[0] != 1 || rsp.Versions[1] != 1 {
+		t.Errorf("expected both records at version 1, got %v", rsp.Versions)
+	}
+
+	// the failed write of c rolls back a and the new key d
+	err := s.BatchWrite(ctx, &pb.BatchWriteRequest{Records: records("a", "changed", "d", "4", "c", "3"), Options: opts}, &pb.BatchWriteResponse{})
+	if err == nil {
+		t.Fatal("expected the batch to fail")
+	}
+	for k, v := range map[string]string{"a": "1", "b": "2", "c": "", "d": ""} {
+		if got := value(k); got != v {
+			t.Errorf("expected %s=%q after the rollback, got %q", k, v, got)
+		}
+	}
+	if v, _ := s.version("team", "data", "a"); v != 1 {
+		t.Errorf("expected the version of a to be rolled back to 1, got %d", v)
+	}
+	if recs, err := backend.Read("journal/", store.ReadPrefix(), store.ReadFrom("micro", "internal")); err == nil && len(recs) > 0 {
+		t.Errorf("expected the journal to be removed, got %d", len(recs))
+	}
+
+	err = s.BatchWrite(ctx, &pb.BatchWriteRequest{Records: records("a", "1", "a", "2"), Options: opts}, &pb.BatchWriteResponse{})
+	if e, ok := err.(*errors.Error); !ok || e.Code != 400 {
+		t.Errorf("expected a batch writing a key twice to be rejected, got %v", err)
+	}
+
+	if err := s.BatchDelete(ctx, &pb.BatchDeleteRequest{Keys: []string{"a", "b", "missing"}, Options: &pb.DeleteOptions{Database: "team", Table: "data"}}, &pb.BatchDeleteResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if value("a") != "" || value("b") != "" {
+		t.Error("expected a and b to be deleted")
+	}
+
+	// a journal left by an instance which stopped is rolled back once abandoned
+	j := &journal{Database: "team", Table: "data", Before: []journalRecord{
+		{Key: "a", Exists: true, Value: []byte("1"), Version: 1},
+		{Key: "e"},
+	}}
+	if err := s.writeJournal("journal/abandoned", j); err != nil {
+		t.Fatal(err)
+	}
+	backend.Write(&store.Record{Key: "e", Value: []byte("5")}, store.WriteTo("team", "data"))
+	if err := s.RecoverBatches(); err != nil {
+		t.Fatal(err)
+	}
+	if value("e") != "5" {
+		t.Error("expected a recent journal to be left alone")
+	}
+	j.Updated = time.Now().Add(-2 * batchTimeout)
+	b, _ := json.Marshal(j)
+	backend.Write(&store.Record{Key: "journal/abandoned", Value: b}, store.WriteTo("micro", "internal"))
+	if err := s.RecoverBatches(); err != nil {
+		t.Fatal(err)
+	}
+	if value("a") != "1" || value("e") != "" {
+		t.Errorf("expected the abandoned batch to be rolled back, got a=%q e=%q", value("a"), value("e"))
+	}
+}
+
+func TestAccess(t *testing.T) {
+	s := &Store{
+		Default: memory.NewStore(),
+		Stores:  make(map[string]bool),
+	}
+	s.New = catalogue(s)
+	account := func(ns string, scopes ...string) context.Context {
+		ctx := namespace.ContextWithNamespace(context.Background(), ns)
+		return auth.ContextWithAccount(ctx, &auth.Account{ID: "user", Issuer: ns, Scopes: scopes})
+	}
+	user, admin := account("team"), account("go.micro", AdminScope)
+	forbidden := func(err error) bool {
+		e, ok := err.(*errors.Error)
+		return ok && e.Code == 403
+	}
+	write := func(ctx context.Context, database string) error {
+		return s.Write(ctx, &pb.WriteRequest{
+			Record:  &pb.Record{Key: "key"},
+			Options: &pb.WriteOptions{Database: database, Table: "data"},
+		}, &pb.WriteResponse{})
+	}
+
+	for _, db := range []string{"", "team"} {
+		if err := write(user, db); err != nil {
+			t.Errorf("expected a write to the own database %q to succeed, got %v", db, err)
+		}
+	}
+	if err := write(user, "other"); !forbidden(err) {
+		t.Errorf("expected a write to another database to be forbidden, got %v", err)
+	}
+	if err := write(user, "micro"); err != nil {
+		t.Errorf("expected the default micro database to be the own database, got %v", err)
+	}
+	if _, err := s.Default.Read("key", store.ReadFrom("micro", "data")); err != store.ErrNotFound {
+		t.Errorf("expected the write to micro to go to team, got %v", err)
+	}
+	if err := write(context.Background(), "other"); !forbidden(err) {
+		t.Errorf("expected a call without an account to be forbidden, got %v", err)
+	}
+	if err := write(account(""), "other"); !forbidden(err) {
+		t.Errorf("expected a call without a namespace to be forbidden, got %v", err)
+	}
+	if err := write(admin, "other"); err != nil {
+		t.Errorf("expected an admin to write to another database, got %v", err)
+	}
+	if _, err := s.Default.Read("key", store.ReadFrom("other", "data")); err != nil {
+		t.Errorf("expected the admin to have written to other, got %v", err)
+	}
+	err := s.Read(user, &pb.ReadRequest{Key: "key", Options: &pb.ReadOptions{Database: "other", Table: "data"}}, &pb.ReadResponse{})
+	if !forbidden(err) {
+		t.Errorf("expected a read of another database to be forbidden, got %v", err)
+	}
+
+	dbs := &pb.DatabasesResponse{}
+	if err := s.Databases(user, &pb.DatabasesRequest{}, dbs); err != nil || len(dbs.Databases) != 1 || dbs.Databases[0] != "team" {
+		t.Errorf("expected only the own database to be listed, got %v %v", dbs.Databases, err)
+	}
+	dbs = &pb.DatabasesResponse{}
+	if err := s.Databases(admin, &pb.DatabasesRequest{}, dbs); err != nil || len(dbs.Databases) != 2 {
+		t.Errorf("expected an admin to see every database, got %v %v", dbs.Databases, err)
+	}
+	if err := s.Tables(user, &pb.TablesRequest{Database: "other"}, &pb.TablesResponse{}); !forbidden(err) {
+		t.Errorf("expected listing the tables of another database to be forbidden, got %v", err)
+	}
+	tables := &pb.TablesResponse{}
+	if err := s.Tables(admin, &pb.TablesRequest{Database: "team"}, tables); err != nil || len(tables.Tables) != 1 {
+		t.Errorf("expected an admin to list the tables of team, got %v %v", tables.Tables, err)
+	}
+
+	micro := account("go.micro")
+	if err := write(micro, "micro"); err != nil {
+		t.Errorf("expected a write to the own database micro to succeed, got %v", err)
+	}
+	err = s.Write(micro, &pb.WriteRequest{
+		Record:  &pb.Record{Key: "datakeys/team"},
+		Options: &pb.WriteOptions{Database: "micro", Table: "internal"},
+	}, &pb.WriteResponse{})
+	if !forbidden(err) {
+		t.Errorf("expected a write to micro/internal to be forbidden, got %v", err)
+	}
+	err = s.Read(micro, &pb.ReadRequest{Key: "datakeys/team", Options: &pb.ReadOptions{Database: "micro", Table: "internal"}}, &pb.ReadResponse{})
+	if !forbidden(err) {
+		t.Errorf("expected a read of micro/internal to be forbidden, got %v", err)
+	}
+
+	// admins only get the micro database itself when they ask for it
+	s.Default.Delete("key", store.DeleteFrom("micro", "data"))
+	teamAdmin := account("team", AdminScope)
+	if err := write(teamAdmin, "micro"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Default.Read("key", store.ReadFrom("micro", "data")); err != store.ErrNotFound {
+		t.Errorf("expected the admin's write to micro to go to team, got %v", err)
+	}
+	if err := write(metadata.Set(teamAdmin, MicroDatabaseHeader, "true"), "micro"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Default.Read("key", store.ReadFrom("micro", "data")); err != nil {
+		t.Errorf("expected the admin to have written to micro, got %v", err)
+	}
+	err = s.Read(admin, &pb.ReadRequest{Key: "datakeys/team", Options: &pb.ReadOptions{Database: "micro", Table: "internal"}}, &pb.ReadResponse{})
+	if !forbidden(err) {
+		t.Errorf("expected a read of micro/internal without the header to be forbidden, got %v", err)
+	}
+	if err := write(metadata.Set(micro, MicroDatabaseHeader, "true"), "other"); !forbidden(err) {
+		t.Errorf("expected the header not to give a user access to other databases, got %v", err)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	s := &Store{
+		Default: memory.NewStore(),
+		Stores:  make(map[string]bool),
+		Open:    true,
+	}
+	s.New = catalogue(s)
+	ctx := context.Background()
+	write := func(key, value string) {
+		err := s.Write(ctx, &pb.WriteRequest{
+			Record:  &pb.Record{Key: key, Value: []byte(value)},
+			Options: &pb.WriteOptions{Database: "team", Table: "users"},
+		}, &pb.WriteResponse{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	query := func(req *pb.QueryRequest) []string {
+		t.Helper()
+		req.Options = &pb.IndexOptions{Database: "team", Table: "users"}
+		rsp := &pb.QueryResponse{}
+		if err := s.Query(ctx, req, rsp); err != nil {
+			t.Fatal(err)
+		}
+		keys := make([]string, len(rsp.Records))
+		for i, r := range rsp.Records {
+			keys[i] = r.Key
+		}
+		return keys
+	}
+	expect := func(name string, got []string, expected ...string) {
+		t.Helper()
+		if fmt.Sprint(got) != fmt.Sprint(expected) {
+			t.Errorf("%s: expected %v, got %v", name, expected, got)
+		}
+	}
+
+	write("1", `{"status": "active", "age": 30, "user": {"email": "c@example.com"}}`)
+	write("2", `{"status": "active", "age": -5, "user": {"email": "a@example.com"}}`)
+	write("3", `{"status": "inactive", "age": 41}`)
+	write("4", `not json`)
+
+	// index the existing records
+	for _, f := range []string{"status", "age", "user.email"} {
+		s.Default.Write(&store.Record{Key: indexDefPrefix("team", "users") + f}, store.WriteTo("micro", "internal"))
+		s.rebuildIndex("team", "users", f)
+	}
+	indexes := &pb.ListIndexesResponse{}
+	if err := s.ListIndexes(ctx, &pb.ListIndexesRequest{Options: &pb.IndexOptions{Database: "team"}}, indexes); err != nil || len(indexes.Indexes) != 3 {
+		t.Errorf("expected 3 indexes, got %v %v", indexes.Indexes, err)
+	}
+
+	expect("eq", query(&pb.QueryRequest{Filters: []*pb.QueryFilter{{Field: "status", Op: "eq", Value: "active"}}}), "1", "2")
+	expect("range", query(&pb.QueryRequest{Filters: []*pb.QueryFilter{{Field: "age", Op: "gte", Value: "0"}}}), "1", "3")
+	expect("both", query(&pb.QueryRequest{Filters: []*pb.QueryFilter{
+		{Field: "age", Op: "lt", Value: "35"},
+		{Field: "status", Op: "eq", Value: `"active"`},
+	}}), "1", "2")
+	expect("order", query(&pb.QueryRequest{OrderBy: "user.email"}), "2", "1")
+	expect("descending", query(&pb.QueryRequest{OrderBy: "age", Descending: true, Limit: 2}), "3", "1")
+
+	// writes and deletes keep the index up to date
+	write("2", `{"status": "inactive", "age": -5}`)
+	err := s.Delete(ctx, &pb.DeleteRequest{Key: "3", Options: &pb.DeleteOptions{Database: "team", Table: "users"}}, &pb.DeleteResponse{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect("after update", query(&pb.QueryRequest{Filters: []*pb.QueryFilter{{Field: "status", Op: "eq", Value: "inactive"}}}), "2")
+	expect("after delete", query(&pb.QueryRequest{OrderBy: "user.email"}), "1")
+
+	err = s.Query(ctx, &pb.QueryRequest{
+		Filters: []*pb.QueryFilter{{Field: "name", Op: "eq", Value: "x"}},
+		Options: &pb.IndexOptions{Database: "team", Table: "users"},
+	}, &pb.QueryResponse{})
+	if e, ok := err.(*errors.Error); !ok || e.Code != 400 {
+		t.Errorf("expected a query on a field without an index to be rejected, got %v", err)
+	}
+
+	// enabling encryption drops the entries and stops values being indexed in the clear
+	if s.Encryption, err = NewEncryption(s.Default, bytes.Repeat([]byte{1}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.DropIndexEntries(); err != nil || n != 3 {
+		t.Errorf("expected the entries of 3 indexes to be dropped, got %d %v", n, err)
+	}
+	write("5", `{"status": "active"}`)
+	for _, prefix := range []string{"index/", "indexkeys/"} {
+		entries, err := s.Default.List(store.ListPrefix(prefix), store.ListFrom("micro", "internal"))
+		if err != nil || len(entries) != 0 {
+			t.Errorf("expected no index entries, got %v %v", entries, err)
+		}
+	}
+	if defs, _ := s.indexes("team", "users"); len(defs) != 3 {
+		t.Errorf("expected the index definitions to be kept, got %v", defs)
+	}
+	err = s.Query(ctx, &pb.QueryRequest{
+		Filters: []*pb.QueryFilter{{Field: "status", Op: "eq", Value: "active"}},
+		Options: &pb.IndexOptions{Database: "team", Table: "users"},
+	}, &pb.QueryResponse{})
+	if e, ok := err.(*errors.Error); !ok || e.Code != 400 {
+		t.Errorf("expected a query to be rejected with encryption, got %v", err)
+	}
+}
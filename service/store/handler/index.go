@@ -0,0 +1,506 @@
+package handler
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"c-z.dev/go-micro/errors"
+	log "c-z.dev/go-micro/logger"
+	"c-z.dev/go-micro/store"
+	pb "c-z.dev/micro/service/store/proto"
+)
+
+// Indexes are kept in the micro/internal table. An index on a field is declared by
+// indexdefs/<database>/<table>/<field> and has an entry for every record holding the field
+// at index/<database>/<table>/<field>/<value>/<key>. The entries of a record are listed at
+// indexkeys/<database>/<table>/<key> so they can be removed when it changes. Entries expire
+// along with their record.
+
+// errIndexEncrypted is returned by the index calls while encryption is enabled
+var errIndexEncrypted = errors.BadRequest("go.micro.store", "index entries hold values in the clear, so indexes can't be used with encryption")
+
+func indexDefPrefix(database, table string) string {
+	return "indexdefs/" + database + "/" + table + "/"
+}
+
+func indexPrefix(database, table, field string) string {
+	return "index/" + database + "/" + table + "/" + field + "/"
+}
+
+func indexKeysKey(database, table, key string) string {
+	return "indexkeys/" + database + "/" + table + "/" + key
+}
+
+// encodeIndexValue encodes a JSON value so encoded values sort in the order of the values.
+// The first byte is the type, nulls sort before booleans, numbers and strings. Objects and
+// arrays can't be indexed.
+func encodeIndexValue(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return "0", true
+	case bool:
+		if v {
+			return "1t", true
+		}
+		return "1f", true
+	case float64:
+		// flip the sign bit of positive numbers and every bit of negative ones so the
+		// bits sort like the numbers
+		bits := math.Float64bits(v)
+		if bits&(1<<63) != 0 {
+			bits = ^bits
+		} else {
+			bits |= 1 << 63
+		}
+		return fmt.Sprintf("2%016x", bits), true
+	case string:
+		// hex keeps the order of the bytes and never contains the separator
+		return "3" + hex.EncodeToString([]byte(v)), true
+	}
+	return "", false
+}
+
+// lookupField returns the value of a field in a JSON document, nested fields are
+// separated by dots
+func lookupField(doc interface{}, field string) (interface{}, bool) {
+	for _, part := range strings.Split(field, ".") {
+		m, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if doc, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+func validField(field string) bool {
+	return len(field) > 0 && !strings.Contains(field, "/")
+}
+
+// indexes returns the fields indexed in a table
+func (s *Store) indexes(database, table string) ([]string, error) {
+	prefix := indexDefPrefix(database, table)
+	recs, err := s.Default.Read(prefix, store.ReadPrefix(), store.ReadFrom("micro", "internal"))
+	if err != nil && err != store.ErrNotFound {
+		return nil, err
+	}
+	fields := make([]string, len(recs))
+	for i, r := range recs {
+		fields[i] = strings.TrimPrefix(r.Key, prefix)
+	}
+	return fields, nil
+}
+
+// updateIndexes replaces the index entries of a record with those of its new value, or
+// removes them if it was deleted. The value is the value as written by the client.
+// Nothing is indexed while encryption is enabled.
+func (s *Store) updateIndexes(database, table string, r *store.Record, deleted bool) error {
+	if s.Encryption != nil {
+		return nil
+	}
+	fields, err := s.indexes(database, table)
+	if err != nil {
+		return err
+	}
+	// entries are only removed along with the whole index by DropIndexEntries
+	if len(fields) == 0 {
+		return nil
+	}
+
+	var old []string
+	recs, err := s.Default.Read(indexKeysKey(database, table, r.Key), store.ReadFrom("micro", "internal"))
+	if err == nil {
+		if err := json.Unmarshal(recs[0].Value, &old); err != nil {
+			return fmt.Errorf("invalid index entries of %s: %w", r.Key, err)
+		}
+	} else if err != store.ErrNotFound {
+		return err
+	}
+
+	var entries []string
+	var doc interface{}
+	if !deleted && json.Unmarshal(r.Value, &doc) == nil {
+		for _, f := range fields {
+			v, ok := lookupField(doc, f)
+			if !ok {
+				continue
+			}
+			enc, ok := encodeIndexValue(v)
+			if !ok {
+				continue
+			}
+			entries = append(entries, indexPrefix(database, table, f)+enc+"/"+r.Key)
+		}
+	}
+
+	keep := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		keep[e] = true
+		if err := s.Default.Write(&store.Record{Key: e, Expiry: r.Expiry}, store.WriteTo("micro", "internal")); err != nil {
+			return err
+		}
+	}
+	for _, e := range old {
+		if keep[e] {
+			continue
+		}
+		if err := s.Default.Delete(e, store.DeleteFrom("micro", "internal")); err != nil && err != store.ErrNotFound {
+			return err
+		}
+	}
+
+	if len(entries) == 0 {
+		err := s.Default.Delete(indexKeysKey(database, table, r.Key), store.DeleteFrom("micro", "internal"))
+		if err == store.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return s.Default.Write(&store.Record{
+		Key:    indexKeysKey(database, table, r.Key),
+		Value:  b,
+		Expiry: r.Expiry,
+	}, store.WriteTo("micro", "internal"))
+}
+
+// reindex updates the index entries of a record from its value in the backend
+func (s *Store) reindex(database, table, key string) error {
+	if s.Encryption != nil {
+		return nil
+	}
+	recs, err := s.Default.Read(key, store.ReadFrom(database, table))
+	if err == store.ErrNotFound {
+		return s.updateIndexes(database, table, &store.Record{Key: key}, true)
+	} else if err != nil {
+		return err
+	}
+	return s.updateIndexes(database, table, recs[0], false)
+}
+
+// rebuildIndex removes every entry of an index and indexes every record in the table again
+func (s *Store) rebuildIndex(database, table, field string) {
+	prefix := indexPrefix(database, table, field)
+	entries, err := s.Default.List(store.ListPrefix(prefix), store.ListFrom("micro", "internal"))
+	if err != nil {
+		log.Errorf("Couldn't list the index on %s of %s/%s: %v", field, database, table, err)
+		return
+	}
+	for _, e := range entries {
+		if err := s.Default.Delete(e, store.DeleteFrom("micro", "internal")); err != nil && err != store.ErrNotFound {
+			log.Errorf("Couldn't remove index entry %s: %v", e, err)
+		}
+	}
+
+	keys, err := s.Default.List(store.ListFrom(database, table))
+	if err != nil {
+		log.Errorf("Couldn't list %s/%s to index it: %v", database, table, err)
+		return
+	}
+	var failed int
+	for _, k := range keys {
+		l := s.versions.lock(database, table, k)
+		l.Lock()
+		err := s.reindex(database, table, k)
+		l.Unlock()
+		if err != nil {
+			log.Errorf("Couldn't index %s in %s/%s: %v", k, database, table, err)
+			failed++
+		}
+	}
+	log.Infof("Indexed %d records of %s/%s on %s, %d failed", len(keys)-failed, database, table, field, failed)
+}
+
+// DropIndexEntries removes the entries of every index, keeping the definitions. Index entries
+// hold values in the clear, so they're dropped when encryption is enabled and the indexes are
+// rebuilt with RebuildIndex once it's disabled again. It returns the number of indexes.
+func (s *Store) DropIndexEntries() (int, error) {
+	defs, err := s.Default.List(store.ListPrefix("indexdefs/"), store.ListFrom("micro", "internal"))
+	if err != nil {
+		return 0, err
+	}
+	for _, prefix := range []string{"index/", "indexkeys/"} {
+		keys, err := s.Default.List(store.ListPrefix(prefix), store.ListFrom("micro", "internal"))
+		if err != nil {
+			return 0, err
+		}
+		for _, k := range keys {
+			if err := s.Default.Delete(k, store.DeleteFrom("micro", "internal")); err != nil && err != store.ErrNotFound {
+				return 0, err
+			}
+		}
+	}
+	return len(defs), nil
+}
+
+// indexRequest returns the database, table and field of an index request
+func (s *Store) indexRequest(ctx context.Context, field string, opts *pb.IndexOptions) (string, string, error) {
+	if s.Encryption != nil {
+		return "", "", errIndexEncrypted
+	}
+	if !validField(field) {
+		return "", "", errors.BadRequest("go.micro.store", "invalid field %q", field)
+	}
+	database, table, err := s.get(ctx, opts.GetDatabase(), opts.GetTable())
+	if err != nil {
+		return "", "", err
+	}
+	return database, table, nil
+}
+
+// CreateIndex declares an index on a field and indexes the records of the table in the
+// background
+func (s *Store) CreateIndex(ctx context.Context, req *pb.CreateIndexRequest, rsp *pb.CreateIndexResponse) error {
+	database, table, err := s.indexRequest(ctx, req.Field, req.Options)
+	if err != nil {
+		return err
+	}
+	def := indexDefPrefix(database, table) + req.Field
+	if err := s.Default.Write(&store.Record{Key: def}, store.WriteTo("micro", "internal")); err != nil {
+		return errors.InternalServerError("go.micro.store", err.Error())
+	}
+	go s.rebuildIndex(database, table, req.Field)
+	return nil
+}
+
+// ListIndexes lists the indexes of a table, or of every table in the database
+func (s *Store) ListIndexes(ctx context.Context, req *pb.ListIndexesRequest, rsp *pb.ListIndexesResponse) error {
+	database, err := s.authorize(ctx, req.Options.GetDatabase())
+	if err != nil {
+		return err
+	}
+	if len(database) == 0 {
+		database = "micro"
+	}
+	prefix := "indexdefs/" + database + "/"
+	if t := req.Options.GetTable(); len(t) > 0 {
+		prefix = indexDefPrefix(database, t)
+	}
+	recs, err := s.Default.Read(prefix, store.ReadPrefix(), store.ReadFrom("micro", "internal"))
+	if err != nil && err != store.ErrNotFound {
+		return errors.InternalServerError("go.micro.store", err.Error())
+	}
+	for _, r := range recs {
+		parts := strings.SplitN(strings.TrimPrefix(r.Key, "indexdefs/"+database+"/"), "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		rsp.Indexes = append(rsp.Indexes, &pb.Index{Database: database, Table: parts[0], Field: parts[1]})
+	}
+	return nil
+}
+
+// RebuildIndex indexes the records of a table again in the background
+func (s *Store) RebuildIndex(ctx context.Context, req *pb.RebuildIndexRequest, rsp *pb.RebuildIndexResponse) error {
+	database, table, err := s.indexRequest(ctx, req.Field, req.Options)
+	if err != nil {
+		return err
+	}
+	if err := s.checkIndexed(database, table, req.Field); err != nil {
+		return err
+	}
+	go s.rebuildIndex(database, table, req.Field)
+	return nil
+}
+
+func (s *Store) checkIndexed(database, table, field string) error {
+	_, err := s.Default.Read(indexDefPrefix(database, table)+field, store.ReadFrom("micro", "internal"))
+	if err == store.ErrNotFound {
+		return errors.BadRequest("go.micro.store", "%s isn't indexed in %s/%s", field, database, table)
+	} else if err != nil {
+		return errors.InternalServerError("go.micro.store", err.Error())
+	}
+	return nil
+}
+
+// queryFilter is a filter of a query with its value encoded like an index entry
+type queryFilter struct {
+	field string
+	op    string
+	value string
+}
+
+func (f *queryFilter) matches(enc string) bool {
+	// ranges only match values of the same type
+	if f.op != "eq" && (len(enc) == 0 || enc[0] != f.value[0]) {
+		return false
+	}
+	switch f.op {
+	case "eq":
+		return enc == f.value
+	case "gt":
+		return enc > f.value
+	case "gte":
+		return enc >= f.value
+	case "lt":
+		return enc < f.value
+	default:
+		return enc <= f.value
+	}
+}
+
+// queryResult is a record matching a query with the encoded value it is ordered by
+type queryResult struct {
+	record *store.Record
+	order  string
+}
+
+// Query returns the records of a table matching every filter. The records to read are
+// found with the index of an equality filter if there is one, or of the first filter.
+func (s *Store) Query(ctx context.Context, req *pb.QueryRequest, rsp *pb.QueryResponse) error {
+	if s.Encryption != nil {
+		return errIndexEncrypted
+	}
+	database, table, err := s.get(ctx, req.Options.GetDatabase(), req.Options.GetTable())
+	if err != nil {
+		return err
+	}
+
+	filters := make([]*queryFilter, len(req.Filters))
+	for i, f := range req.Filters {
+		switch f.Op {
+		case "eq", "gt", "gte", "lt", "lte":
+		default:
+			return errors.BadRequest("go.micro.store", "unknown op %s, expected eq, gt, gte, lt or lte", f.Op)
+		}
+		if !validField(f.Field) {
+			return errors.BadRequest("go.micro.store", "invalid field %q", f.Field)
+		}
+		if err := s.checkIndexed(database, table, f.Field); err != nil {
+			return err
+		}
+		var v interface{}
+		if err := json.Unmarshal([]byte(f.Value), &v); err != nil {
+			v = f.Value
+		}
+		enc, ok := encodeIndexValue(v)
+		if !ok {
+			return errors.BadRequest("go.micro.store", "objects and arrays can't be queried, got %s", f.Value)
+		}
+		filters[i] = &queryFilter{field: f.Field, op: f.Op, value: enc}
+	}
+
+	// find the candidate keys with the index of a filter, or of the order if there are none
+	var driver *queryFilter
+	for _, f := range filters {
+		if driver == nil || (f.op == "eq" && driver.op != "eq") {
+			driver = f
+		}
+	}
+	var prefix string
+	switch {
+	case driver != nil:
+		prefix = indexPrefix(database, table, driver.field)
+		if driver.op == "eq" {
+			prefix += driver.value + "/"
+		}
+	case validField(req.OrderBy):
+		if err := s.checkIndexed(database, table, req.OrderBy); err != nil {
+			return err
+		}
+		prefix = indexPrefix(database, table, req.OrderBy)
+	default:
+		return errors.BadRequest("go.micro.store", "a filter or an indexed field to order by is required")
+	}
+	entries, err := s.Default.List(store.ListPrefix(prefix), store.ListFrom("micro", "internal"))
+	if err != nil {
+		return errors.InternalServerError("go.micro.store", err.Error())
+	}
+	base := "index/" + database + "/" + table + "/"
+	seen := make(map[string]bool, len(entries))
+	var keys []string
+	for _, e := range entries {
+		// entries are <field>/<value>/<key>, field names can't hold a slash
+		parts := strings.SplitN(strings.TrimPrefix(e, base), "/", 3)
+		if len(parts) != 3 || (driver != nil && !driver.matches(parts[1])) || seen[parts[2]] {
+			continue
+		}
+		seen[parts[2]] = true
+		keys = append(keys, parts[2])
+	}
+
+	var results []*queryResult
+	for _, k := range keys {
+		recs, err := s.Default.Read(k, store.ReadFrom(database, table))
+		if err == store.ErrNotFound {
+			continue
+		} else if err != nil {
+			return errors.InternalServerError("go.micro.store", err.Error())
+		}
+		r := recs[0]
+		var doc interface{}
+		if err := json.Unmarshal(r.Value, &doc); err != nil {
+			continue
+		}
+		if !matchesAll(doc, filters) {
+			continue
+		}
+		res := &queryResult{record: r}
+		if len(req.OrderBy) > 0 {
+			if v, ok := lookupField(doc, req.OrderBy); ok {
+				res.order, _ = encodeIndexValue(v)
+			}
+		}
+		results = append(results, res)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.order == b.order {
+			return a.record.Key < b.record.Key
+		}
+		if req.Descending {
+			return a.order > b.order
+		}
+		return a.order < b.order
+	})
+	if req.Offset > 0 {
+		if req.Offset >= uint64(len(results)) {
+			results = nil
+		} else {
+			results = results[req.Offset:]
+		}
+	}
+	if req.Limit > 0 && req.Limit < uint64(len(results)) {
+		results = results[:req.Limit]
+	}
+
+	for _, res := range results {
+		version, err := s.version(database, table, res.record.Key)
+		if err != nil {
+			return errors.InternalServerError("go.micro.store", "couldn't read the version of %s: %v", res.record.Key, err)
+		}
+		rsp.Records = append(rsp.Records, &pb.Record{
+			Key:     res.record.Key,
+			Value:   res.record.Value,
+			Expiry:  int64(res.record.Expiry.Seconds()),
+			Version: version,
+		})
+	}
+	return nil
+}
+
+// matchesAll returns true if a document matches every filter
+func matchesAll(doc interface{}, filters []*queryFilter) bool {
+	for _, f := range filters {
+		v, ok := lookupField(doc, f.field)
+		if !ok {
+			return false
+		}
+		enc, ok := encodeIndexValue(v)
+		if !ok || !f.matches(enc) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,351 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"c-z.dev/go-micro/errors"
+	"c-z.dev/go-micro/store"
+	pb "c-z.dev/micro/service/store/proto"
+)
+
+// Quota limits the usage of a database, zero values are unlimited
+type Quota struct {
+	// Keys is the number of keys across every table in the database
+	Keys uint64 `json:"keys"`
+	// Bytes is the size of every key and value in the database
+	Bytes uint64 `json:"bytes"`
+	// ValueSize is the size of the largest value which can be written
+	ValueSize uint64 `json:"value_size"`
+}
+
+func (q Quota) unlimited() bool {
+	return q.Keys == 0 && q.Bytes == 0 && q.ValueSize == 0
+}
+
+// checkValueSize returns an errors.Forbidden error if a value is larger than the quota allows.
+// Values are checked before they're encrypted, so the limit applies to what callers write.
+func (q Quota) checkValueSize(database, key string, size int) error {
+	if q.ValueSize > 0 && uint64(size) > q.ValueSize {
+		return errors.Forbidden("go.micro.store", "value of %s of %d bytes exceeds the limit of %d bytes for database %s",
+			key, size, q.ValueSize, database)
+	}
+	return nil
+}
+
+// usage is the number of keys and bytes in a table
+type usage struct {
+	keys  uint64
+	bytes uint64
+}
+
+// databaseUsage tracks the usage of a database with a quota. It is counted when the first
+// write to the database is checked and kept up to date by this service from then on, so
+// writes by anything else and expired keys are only noticed after a restart.
+type databaseUsage struct {
+	sync.Mutex
+	tables map[string]*usage
+}
+
+func (d *databaseUsage) total() usage {
+	var u usage
+	for _, t := range d.tables {
+		u.keys += t.keys
+		u.bytes += t.bytes
+	}
+	return u
+}
+
+// quota returns the quota of a database. A quota written to quotas/<database> in the
+// micro/internal table overrides the default quota.
+func (s *Store) quota(database string) (Quota, error) {
+	recs, err := s.Default.Read("quotas/"+database, store.ReadFrom("micro", "internal"))
+	if err == store.ErrNotFound {
+		return s.Quota, nil
+	} else if err != nil {
+		return Quota{}, err
+	}
+	// quotas written while the micro database was encrypted are still read
+	value := recs[0].Value
+	if s.Encryption != nil {
+		if value, err = s.Encryption.Decrypt("micro", value); err != nil {
+			return Quota{}, fmt.Errorf("couldn't decrypt quota for %s: %w", database, err)
+		}
+	}
+	var q Quota
+	if err := json.Unmarshal(value, &q); err != nil {
+		return Quota{}, fmt.Errorf("invalid quota for %s: %w", database, err)
+	}
+	return q, nil
+}
+
+// SetQuota writes the quota of a database to quotas/<database> in the micro/internal table,
+// which is never encrypted
+func (s *Store) SetQuota(ctx context.Context, req *pb.SetQuotaRequest, rsp *pb.SetQuotaResponse) error {
+	if !s.isAdmin(ctx) {
+		return errors.Forbidden("go.micro.store", "setting a quota requires the %s scope", AdminScope)
+	}
+	database, err := s.authorize(ctx, req.Database)
+	if err != nil {
+		return err
+	}
+	if len(database) == 0 || strings.Contains(database, "/") {
+		return errors.BadRequest("go.micro.store", "invalid database %q", database)
+	}
+
+	if req.Remove {
+		err := s.Default.Delete("quotas/"+database, store.DeleteFrom("micro", "internal"))
+		if err != nil && err != store.ErrNotFound {
+			return errors.InternalServerError("go.micro.store", "couldn't remove the quota of %s: %v", database, err)
+		}
+		return nil
+	}
+	if req.Quota == nil {
+		return errors.BadRequest("go.micro.store", "quota is required")
+	}
+	q := Quota{Keys: req.Quota.Keys, Bytes: req.Quota.Bytes, ValueSize: req.Quota.ValueSize}
+	if q.Bytes > 0 && q.ValueSize > q.Bytes {
+		return errors.BadRequest("go.micro.store", "value size limit of %d bytes exceeds the size limit of %d bytes", q.ValueSize, q.Bytes)
+	}
+	b, err := json.Marshal(q)
+	if err != nil {
+		return errors.InternalServerError("go.micro.store", err.Error())
+	}
+	if err := s.Default.Write(&store.Record{Key: "quotas/" + database, Value: b}, store.WriteTo("micro", "internal")); err != nil {
+		return errors.InternalServerError("go.micro.store", "couldn't write the quota of %s: %v", database, err)
+	}
+	return nil
+}
+
+// databaseUsage returns the usage of a database, counting it if it isn't tracked yet
+func (s *Store) databaseUsage(database string) (*databaseUsage, error) {
+	s.usageMtx.Lock()
+	defer s.usageMtx.Unlock()
+
+	if u, ok := s.usage[database]; ok {
+		return u, nil
+	}
+	tables, err := s.catalogueTables(database)
+	if err != nil {
+		return nil, err
+	}
+	u := &databaseUsage{tables: make(map[string]*usage, len(tables))}
+	for _, t := range tables {
+		tu, err := s.tableUsage(database, t)
+		if err != nil {
+			return nil, err
+		}
+		u.tables[t] = tu
+	}
+	if s.usage == nil {
+		s.usage = make(map[string]*databaseUsage)
+	}
+	s.usage[database] = u
+	return u, nil
+}
+
+// tableUsage counts the keys and bytes in a table
+func (s *Store) tableUsage(database, table string) (*usage, error) {
+	keys, err := s.Default.List(store.ListFrom(database, table))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list %s/%s: %w", database, table, err)
+	}
+	u := &usage{}
+	for _, k := range keys {
+		recs, err := s.Default.Read(k, store.ReadFrom(database, table))
+		if err == store.ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("couldn't read %s from %s/%s: %w", k, database, table, err)
+		}
+		u.keys++
+		u.bytes += recordSize(recs[0])
+	}
+	return u, nil
+}
+
+// reserveWrite checks a write against the key and byte limits of the quota of its database
+// and returns an errors.Forbidden error if they would be exceeded. The value size is checked
+// by the caller. Writes to the database are blocked until the returned function is called
+// with whether the write succeeded.
+func (s *Store) reserveWrite(database, table string, q Quota, r *store.Record) (func(bool), error) {
+	if q.unlimited() {
+		// stop tracking in case the quota was removed, it is counted again if one is set
+		s.forgetUsage(database)
+		return func(bool) {}, nil
+	}
+
+	u, err := s.databaseUsage(database)
+	if err != nil {
+		return nil, errors.InternalServerError("go.micro.store", err.Error())
+	}
+	u.Lock()
+	old, exists, err := s.existingSize(database, table, r.Key)
+	if err != nil {
+		u.Unlock()
+		return nil, errors.InternalServerError("go.micro.store", err.Error())
+	}
+
+	size := recordSize(r)
+	total := u.total()
+	if !exists && q.Keys > 0 && total.keys+1 > q.Keys {
+		u.Unlock()
+		return nil, errors.Forbidden("go.micro.store", "database %s has reached its limit of %d keys", database, q.Keys)
+	}
+	if q.Bytes > 0 && size > old && total.bytes+size-old > q.Bytes {
+		u.Unlock()
+		return nil, errors.Forbidden("go.micro.store", "write would exceed the limit of %d bytes for database %s", q.Bytes, database)
+	}
+
+	return func(ok bool) {
+		defer u.Unlock()
+		if !ok {
+			return
+		}
+		t := u.tables[table]
+		if t == nil {
+			t = &usage{}
+			u.tables[table] = t
+		}
+		if !exists {
+			t.keys++
+		}
+		t.bytes = t.bytes + size - old
+	}, nil
+}
+
+// reserveBatch checks a batch against the quota of its database like reserveWrite. before
+// holds the records as they are before the batch is applied.
+func (s *Store) reserveBatch(database, table string, q Quota, ops []batchOp, before []journalRecord) (func(bool), error) {
+	if q.unlimited() {
+		s.forgetUsage(database)
+		return func(bool) {}, nil
+	}
+
+	var keys, bytes int64
+	for i, op := range ops {
+		var old int64
+		if before[i].Exists {
+			old = int64(len(before[i].Key) + len(before[i].Value))
+		}
+		if op.record == nil {
+			if before[i].Exists {
+				keys--
+				bytes -= old
+			}
+			continue
+		}
+		if !before[i].Exists {
+			keys++
+		}
+		bytes += int64(recordSize(op.record)) - old
+	}
+
+	u, err := s.databaseUsage(database)
+	if err != nil {
+		return nil, errors.InternalServerError("go.micro.store", err.Error())
+	}
+	u.Lock()
+	total := u.total()
+	if q.Keys > 0 && keys > 0 && total.keys+uint64(keys) > q.Keys {
+		u.Unlock()
+		return nil, errors.Forbidden("go.micro.store", "batch would exceed the limit of %d keys for database %s", q.Keys, database)
+	}
+	if q.Bytes > 0 && bytes > 0 && total.bytes+uint64(bytes) > q.Bytes {
+		u.Unlock()
+		return nil, errors.Forbidden("go.micro.store", "batch would exceed the limit of %d bytes for database %s", q.Bytes, database)
+	}
+
+	return func(ok bool) {
+		defer u.Unlock()
+		if !ok {
+			return
+		}
+		t := u.tables[table]
+		if t == nil {
+			t = &usage{}
+			u.tables[table] = t
+		}
+		t.keys = uint64(int64(t.keys) + keys)
+		t.bytes = uint64(int64(t.bytes) + bytes)
+	}, nil
+}
+
+// forgetUsage stops tracking the usage of a database, it is counted again on its next write
+func (s *Store) forgetUsage(database string) {
+	s.usageMtx.Lock()
+	delete(s.usage, database)
+	s.usageMtx.Unlock()
+}
+
+// reserveDelete updates the usage of a database with a quota once a key is deleted.
+// The returned function must be called with whether the delete succeeded.
+func (s *Store) reserveDelete(database, table, key string) (func(bool), error) {
+	s.usageMtx.Lock()
+	u, ok := s.usage[database]
+	s.usageMtx.Unlock()
+	if !ok {
+		return func(bool) {}, nil
+	}
+
+	u.Lock()
+	old, exists, err := s.existingSize(database, table, key)
+	if err != nil {
+		u.Unlock()
+		return nil, errors.InternalServerError("go.micro.store", err.Error())
+	}
+	return func(ok bool) {
+		defer u.Unlock()
+		t := u.tables[table]
+		if !ok || !exists || t == nil {
+			return
+		}
+		t.keys--
+		t.bytes -= old
+	}, nil
+}
+
+// existingSize returns the size of a record if it exists
+func (s *Store) existingSize(database, table, key string) (uint64, bool, error) {
+	recs, err := s.Default.Read(key, store.ReadFrom(database, table))
+	if err == store.ErrNotFound {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	return recordSize(recs[0]), true, nil
+}
+
+// recordSize is the size of a record counted towards the bytes quota
+func recordSize(r *store.Record) uint64 {
+	return uint64(len(r.Key) + len(r.Value))
+}
+
+// catalogueDatabases returns the databases recorded in the micro/internal table
+func (s *Store) catalogueDatabases() ([]string, error) {
+	recs, err := s.Default.Read("databases/", store.ReadPrefix(), store.ReadFrom("micro", "internal"))
+	if err != nil && err != store.ErrNotFound {
+		return nil, err
+	}
+	databases := make([]string, len(recs))
+	for i, r := range recs {
+		databases[i] = strings.TrimPrefix(r.Key, "databases/")
+	}
+	return databases, nil
+}
+
+// catalogueTables returns the tables of a database recorded in the micro/internal table
+func (s *Store) catalogueTables(database string) ([]string, error) {
+	recs, err := s.Default.Read("tables/"+database+"/", store.ReadPrefix(), store.ReadFrom("micro", "internal"))
+	if err != nil && err != store.ErrNotFound {
+		return nil, err
+	}
+	tables := make([]string, len(recs))
+	for i, r := range recs {
+		tables[i] = strings.TrimPrefix(r.Key, "tables/"+database+"/")
+	}
+	return tables, nil
+}
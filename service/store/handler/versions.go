@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"c-z.dev/go-micro/errors"
+	"c-z.dev/go-micro/store"
+)
+
+// keyLocks is the number of locks writes to a key are serialised with
+const keyLocks = 256
+
+// versionLocks serialise the check and write of a versioned write. Keys share a fixed
+// number of locks so the locks never have to be cleaned up. They only serialise the writes
+// of this instance, instances of the service sharing a backend can still race each other.
+type versionLocks [keyLocks]sync.Mutex
+
+func (v *versionLocks) lock(database, table, key string) *sync.Mutex {
+	return &v[lockIndex(database, table, key)]
+}
+
+// lockAll locks every key in the same order so batches can't deadlock each other. The
+// returned function unlocks them.
+func (v *versionLocks) lockAll(database, table string, keys []string) func() {
+	seen := make(map[int]bool, len(keys))
+	var indexes []int
+	for _, k := range keys {
+		i := lockIndex(database, table, k)
+		if !seen[i] {
+			seen[i] = true
+			indexes = append(indexes, i)
+		}
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		v[i].Lock()
+	}
+	return func() {
+		for _, i := range indexes {
+			v[i].Unlock()
+		}
+	}
+}
+
+func lockIndex(database, table, key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(database + "/" + table + "/" + key))
+	return int(h.Sum32() % keyLocks)
+}
+
+// versionKey is the key in the micro/internal table the version of a record is kept under
+func versionKey(database, table, key string) string {
+	return "versions/" + database + "/" + table + "/" + key
+}
+
+// highWaterKey is the key in the micro/internal table the highest version a record has had
+// is kept under. Unlike the version it's kept once the record is deleted or expires, so a key
+// which is written again carries on from it and never reuses a version.
+func highWaterKey(database, table, key string) string {
+	return "highwater/" + database + "/" + table + "/" + key
+}
+
+// version returns the version of a record. Records written before versions were kept
+// have version 0 until they're written again.
+func (s *Store) version(database, table, key string) (uint64, error) {
+	return s.readVersion(versionKey(database, table, key))
+}
+
+// highWater returns the highest version a record has had, 0 if it was never versioned
+func (s *Store) highWater(database, table, key string) (uint64, error) {
+	return s.readVersion(highWaterKey(database, table, key))
+}
+
+func (s *Store) readVersion(key string) (uint64, error) {
+	recs, err := s.Default.Read(key, store.ReadFrom("micro", "internal"))
+	if err == store.ErrNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(recs[0].Value), 10, 64)
+}
+
+// prefixVersions returns the versions of the records with keys starting with prefix, read with a
+// single prefix read. Records without a version are missing from the map.
+func (s *Store) prefixVersions(database, table, prefix string) (map[string]uint64, error) {
+	keyPrefix := versionKey(database, table, "")
+	recs, err := s.Default.Read(keyPrefix+prefix, store.ReadPrefix(), store.ReadFrom("micro", "internal"))
+	if err == store.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	versions := make(map[string]uint64, len(recs))
+	for _, r := range recs {
+		v, err := strconv.ParseUint(string(r.Value), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		versions[strings.TrimPrefix(r.Key, keyPrefix)] = v
+	}
+	return versions, nil
+}
+
+// versionCheck are the conditions of a write
+type versionCheck struct {
+	ifVersion   uint64
+	ifNotExists bool
+}
+
+// checkVersion returns the highest version a record has had, whether it exists and an
+// errors.Conflict error if it doesn't match the conditions of a write. The record is written
+// at the version after it.
+func (s *Store) checkVersion(database, table string, r *store.Record, opts *versionCheck) (uint64, bool, error) {
+	_, exists, err := s.existingSize(database, table, r.Key)
+	if err != nil {
+		return 0, false, errors.InternalServerError("go.micro.store", err.Error())
+	}
+	var current uint64
+	if exists {
+		if current, err = s.version(database, table, r.Key); err != nil {
+			return 0, false, errors.InternalServerError("go.micro.store", "couldn't read the version of %s: %v", r.Key, err)
+		}
+	}
+	last, err := s.highWater(database, table, r.Key)
+	if err != nil {
+		return 0, false, errors.InternalServerError("go.micro.store", "couldn't read the version of %s: %v", r.Key, err)
+	}
+	if current > last {
+		last = current
+	}
+	if opts.ifNotExists && exists {
+		return 0, false, errors.Conflict("go.micro.store", "%s already exists", r.Key)
+	}
+	if opts.ifVersion > 0 && (!exists || current != opts.ifVersion) {
+		if !exists {
+			return 0, false, errors.Conflict("go.micro.store", "%s doesn't exist, expected version %d", r.Key, opts.ifVersion)
+		}
+		return 0, false, errors.Conflict("go.micro.store", "%s is at version %d, expected version %d", r.Key, current, opts.ifVersion)
+	}
+	return last, exists, nil
+}
+
+// writeVersion records the version of a newly written record and raises its high-water mark
+func (s *Store) writeVersion(database, table string, r *store.Record, version uint64) error {
+	if err := s.setVersion(database, table, r, version); err != nil {
+		return err
+	}
+	return s.Default.Write(&store.Record{
+		Key:   highWaterKey(database, table, r.Key),
+		Value: []byte(strconv.FormatUint(version, 10)),
+	}, store.WriteTo("micro", "internal"))
+}
+
+// setVersion records the version of a record, it expires along with the record
+func (s *Store) setVersion(database, table string, r *store.Record, version uint64) error {
+	return s.Default.Write(&store.Record{
+		Key:    versionKey(database, table, r.Key),
+		Value:  []byte(strconv.FormatUint(version, 10)),
+		Expiry: r.Expiry,
+	}, store.WriteTo("micro", "internal"))
+}
+
+// deleteVersion removes the version of a deleted record, its high-water mark is kept
+func (s *Store) deleteVersion(database, table, key string) error {
+	err := s.Default.Delete(versionKey(database, table, key), store.DeleteFrom("micro", "internal"))
+	if err == store.ErrNotFound {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,268 @@
+package handler
+
+import (
+	"container/heap"
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"c-z.dev/go-micro/errors"
+	"c-z.dev/go-micro/store"
+	pb "c-z.dev/micro/service/store/proto"
+)
+
+// watchBuffer is the number of changes buffered for a watcher before it is dropped
+const watchBuffer = 256
+
+// change types sent to watchers
+const (
+	changeCreate = "create"
+	changeUpdate = "update"
+	changeDelete = "delete"
+	changeExpire = "expire"
+)
+
+// change is a change made to a record
+type change struct {
+	typ       string
+	database  string
+	table     string
+	record    *store.Record
+	version   uint64
+	timestamp time.Time
+}
+
+// watcher receives the changes to the keys of a table with a prefix
+type watcher struct {
+	database string
+	table    string
+	prefix   string
+	changes  chan *change
+}
+
+func (w *watcher) matches(c *change) bool {
+	return c.database == w.database && c.table == w.table && strings.HasPrefix(c.record.Key, w.prefix)
+}
+
+// changeLog fans the changes made through this service out to watchers. Backends don't
+// report changes, so writes made by other instances of the service or directly to the
+// backend aren't seen. Expiry is tracked for records written with one and reported once
+// the backend no longer returns the record.
+type changeLog struct {
+	// exists reports whether a record is still in the backend
+	exists func(database, table, key string) bool
+
+	sync.Mutex
+	watchers map[*watcher]bool
+	expiries expiryHeap
+	// pending is the entry in expiries of every record by versionKey, a record has at most
+	// one which is moved when it's written again
+	pending map[string]*expiry
+	wake    chan bool
+}
+
+func newChangeLog(exists func(database, table, key string) bool) *changeLog {
+	c := &changeLog{
+		exists:   exists,
+		watchers: make(map[*watcher]bool),
+		pending:  make(map[string]*expiry),
+		wake:     make(chan bool, 1),
+	}
+	go c.expire()
+	return c
+}
+
+// watch registers a watcher, it must be stopped once done
+func (c *changeLog) watch(database, table, prefix string) *watcher {
+	w := &watcher{
+		database: database,
+		table:    table,
+		prefix:   prefix,
+		changes:  make(chan *change, watchBuffer),
+	}
+	c.Lock()
+	c.watchers[w] = true
+	c.Unlock()
+	return w
+}
+
+func (c *changeLog) stop(w *watcher) {
+	c.Lock()
+	defer c.Unlock()
+	if c.watchers[w] {
+		delete(c.watchers, w)
+		close(w.changes)
+	}
+}
+
+// publish sends a change to every watcher it matches. A watcher which can't keep up is
+// dropped rather than blocking writes, its changes channel is closed.
+func (c *changeLog) publish(ch *change) {
+	c.Lock()
+	defer c.Unlock()
+
+	k := versionKey(ch.database, ch.table, ch.record.Key)
+	e, ok := c.pending[k]
+	switch {
+	case (ch.typ == changeCreate || ch.typ == changeUpdate) && ch.record.Expiry > 0:
+		at := ch.timestamp.Add(ch.record.Expiry)
+		if ok {
+			e.at = at
+			heap.Fix(&c.expiries, e.index)
+		} else {
+			c.pending[k] = &expiry{at: at, database: ch.database, table: ch.table, key: ch.record.Key}
+			heap.Push(&c.expiries, c.pending[k])
+		}
+		select {
+		case c.wake <- true:
+		default:
+		}
+	case ok:
+		heap.Remove(&c.expiries, e.index)
+		delete(c.pending, k)
+	}
+
+	for w := range c.watchers {
+		if !w.matches(ch) {
+			continue
+		}
+		select {
+		case w.changes <- ch:
+		default:
+			delete(c.watchers, w)
+			close(w.changes)
+		}
+	}
+}
+
+// expire publishes an expire change for every record whose expiry has passed
+func (c *changeLog) expire() {
+	timer := time.NewTimer(time.Hour)
+	for {
+		c.Lock()
+		var due []*expiry
+		now := time.Now()
+		for len(c.expiries) > 0 && !c.expiries[0].at.After(now) {
+			e := heap.Pop(&c.expiries).(*expiry)
+			delete(c.pending, versionKey(e.database, e.table, e.key))
+			due = append(due, e)
+		}
+		wait := time.Hour
+		if len(c.expiries) > 0 {
+			wait = time.Until(c.expiries[0].at)
+		}
+		c.Unlock()
+
+		for _, e := range due {
+			if c.exists(e.database, e.table, e.key) {
+				continue
+			}
+			c.publish(&change{
+				typ:       changeExpire,
+				database:  e.database,
+				table:     e.table,
+				record:    &store.Record{Key: e.key},
+				timestamp: e.at,
+			})
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-c.wake:
+		}
+	}
+}
+
+// expiry is when a record expires
+type expiry struct {
+	at       time.Time
+	database string
+	table    string
+	key      string
+	// index is the position in the heap, kept up to date for heap.Fix and heap.Remove
+	index int
+}
+
+// expiryHeap orders expiries with the earliest first
+type expiryHeap []*expiry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *expiryHeap) Push(x interface{}) {
+	e := x.(*expiry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// changes returns the change log of the service, starting it on first use
+func (s *Store) changes() *changeLog {
+	s.changesOnce.Do(func() {
+		s.changeLog = newChangeLog(func(database, table, key string) bool {
+			_, err := s.Default.Read(key, store.ReadFrom(database, table))
+			return err != store.ErrNotFound
+		})
+	})
+	return s.changeLog
+}
+
+// Watch streams the changes to the keys of a table with a prefix until the client goes away
+func (s *Store) Watch(ctx context.Context, req *pb.WatchRequest, stream pb.Store_WatchStream) error {
+	var database, table string
+	if req.Options != nil {
+		database = req.Options.Database
+		table = req.Options.Table
+	}
+	database, table, err := s.get(ctx, database, table)
+	if err != nil {
+		return err
+	}
+
+	changes := s.changes()
+	w := changes.watch(database, table, req.Prefix)
+	defer changes.stop(w)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case c, ok := <-w.changes:
+			if !ok {
+				return errors.InternalServerError("go.micro.store", "watcher of %s/%s fell behind", database, table)
+			}
+			err := stream.Send(&pb.WatchResponse{
+				Type: c.typ,
+				Record: &pb.Record{
+					Key:     c.record.Key,
+					Value:   c.record.Value,
+					Expiry:  int64(c.record.Expiry.Seconds()),
+					Version: c.version,
+				},
+				Database:  c.database,
+				Table:     c.table,
+				Timestamp: c.timestamp.UnixNano(),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package snapshot
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"c-z.dev/go-micro/store"
+)
+
+const (
+	// catalogueDatabase and catalogueTable hold the list of every database and table
+	// created through the store service, see service/store Run
+	catalogueDatabase = "micro"
+	catalogueTable    = "internal"
+)
+
+// Catalogue returns every table the store service has recorded in the micro/internal table
+// of the given store backend
+func Catalogue(s store.Store) ([]Table, error) {
+	dbs, err := s.Read("databases/", store.ReadPrefix(), store.ReadFrom(catalogueDatabase, catalogueTable))
+	if err != nil && err != store.ErrNotFound {
+		return nil, fmt.Errorf("couldn't read databases from the catalogue: %w", err)
+	}
+
+	var tables []Table
+	for _, db := range dbs {
+		database := strings.TrimPrefix(db.Key, "databases/")
+		prefix := "tables/" + database + "/"
+		recs, err := s.Read(prefix, store.ReadPrefix(), store.ReadFrom(catalogueDatabase, catalogueTable))
+		if err != nil && err != store.ErrNotFound {
+			return nil, fmt.Errorf("couldn't read tables of %s from the catalogue: %w", database, err)
+		}
+		for _, r := range recs {
+			tables = append(tables, Table{
+				Database: database,
+				Table:    strings.TrimPrefix(r.Key, prefix),
+			})
+		}
+	}
+	return tables, nil
+}
+
+// Match reports whether the table matches any of the patterns. A pattern has the form
+// database/table, where either part may use path.Match syntax, e.g. micro/* or */users.
+// A table matches when no patterns are given.
+func (t Table) Match(patterns ...string) (bool, error) {
+	if len(patterns) == 0 {
+		return true, nil
+	}
+	for _, p := range patterns {
+		parts := strings.SplitN(p, "/", 2)
+		if len(parts) != 2 {
+			return false, fmt.Errorf("invalid table pattern %s (wanted database/table)", p)
+		}
+		dbMatch, err := path.Match(parts[0], t.Database)
+		if err != nil {
+			return false, fmt.Errorf("invalid table pattern %s: %w", p, err)
+		}
+		tableMatch, err := path.Match(parts[1], t.Table)
+		if err != nil {
+			return false, fmt.Errorf("invalid table pattern %s: %w", p, err)
+		}
+		if dbMatch && tableMatch {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Filter returns the tables which match any of the patterns, see Table.Match
+func Filter(tables []Table, patterns ...string) ([]Table, error) {
+	var filtered []Table
+	for _, t := range tables {
+		ok, err := t.Match(patterns...)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			filtered = append(filtered, t)
+		}
+	}
+	return filtered, nil
+}
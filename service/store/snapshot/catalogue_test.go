@@ -0,0 +1,63 @@
+package snapshot
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"c-z.dev/go-micro/store"
+	"c-z.dev/go-micro/store/memory"
+)
+
+func TestCatalogue(t *testing.T) {
+	s := memory.NewStore()
+	for _, key := range []string{
+		"databases/foo",
+		"databases/bar",
+		"tables/foo/users",
+		"tables/foo/orders",
+		"tables/bar/users",
+	} {
+		if err := s.Write(&store.Record{Key: key}, store.WriteTo("micro", "internal")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tables, err := Catalogue(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].String() < tables[j].String() })
+	expected := []Table{
+		{Database: "bar", Table: "users"},
+		{Database: "foo", Table: "orders"},
+		{Database: "foo", Table: "users"},
+	}
+	if !reflect.DeepEqual(tables, expected) {
+		t.Fatalf("expected %v, got %v", expected, tables)
+	}
+
+	tests := []struct {
+		patterns []string
+		expected []Table
+	}{
+		{nil, expected},
+		{[]string{"foo/*"}, expected[1:]},
+		{[]string{"*/users"}, []Table{expected[0], expected[2]}},
+		{[]string{"bar/users", "foo/orders"}, expected[:2]},
+		{[]string{"baz/*"}, nil},
+	}
+	for _, tc := range tests {
+		filtered, err := Filter(tables, tc.patterns...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(filtered, tc.expected) {
+			t.Errorf("%v: expected %v, got %v", tc.patterns, tc.expected, filtered)
+		}
+	}
+
+	if _, err := Filter(tables, "nodatabase"); err == nil {
+		t.Error("expected an error for a pattern without a table")
+	}
+}
@@ -0,0 +1,105 @@
+package snapshot
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// A chain is a location holding several snapshots, each stored under its ID, e.g.
+// file:///backups/store/20240101T000000.000Z. A full snapshot is the start of a chain and
+// incremental snapshots only hold the records added, changed or deleted since their base.
+// The snapshot stored as Latest holds no records, its base is the newest snapshot in the chain.
+
+// Latest is the ID of the snapshot pointing at the newest snapshot in a chain
+const Latest = "latest"
+
+// NewID returns an ID for a snapshot taken now. IDs sort in the order they were taken
+func NewID() string {
+	return time.Now().UTC().Format("20060102T150405.000Z")
+}
+
+// Locate returns the URL of the snapshot with the given ID in the chain at loc
+func Locate(loc, id string) (string, error) {
+	if len(id) == 0 || strings.ContainsAny(id, "/?#") {
+		return "", fmt.Errorf("invalid snapshot id %q", id)
+	}
+	u, err := url.Parse(loc)
+	if err != nil {
+		return "", fmt.Errorf("location is invalid: %w", err)
+	}
+	u.Path = path.Join("/", u.Path, id)
+	return u.String(), nil
+}
+
+// Replay reads the snapshot with the given ID from the chain at loc, following its base
+// snapshots back to a full snapshot. fn is called once for every key with its state as of
+// that snapshot, newest first; keys which had been deleted are passed with Deleted set.
+// The headers of the snapshots read are returned, newest first. The options are passed to
+// the Restore used to read each snapshot.
+func Replay(loc, id string, fn func(*Record) error, opts ...RestoreOption) ([]Header, error) {
+	var options RestoreOptions
+	for _, o := range opts {
+		o(&options)
+	}
+	type key struct {
+		Table
+		Key string
+	}
+	seen := make(map[key]bool)
+	visited := make(map[string]bool)
+	var headers []Header
+
+	for {
+		if visited[id] {
+			return headers, fmt.Errorf("%w: snapshot %s is the base of itself", ErrCorrupt, id)
+		}
+		visited[id] = true
+		src, err := Locate(loc, id)
+		if err != nil {
+			return headers, err
+		}
+		// expired records are filtered here, they still hide the same key in older snapshots
+		r, err := NewRestore(src, append(opts, KeepExpired(true))...)
+		if err != nil {
+			return headers, err
+		}
+		if err := r.Init(); err != nil {
+			return headers, err
+		}
+		records, err := r.Start()
+		if err != nil {
+			return headers, err
+		}
+		headers = append(headers, r.Header())
+
+		var ferr error
+		for rec := range records {
+			// keep draining the channel after a failure so the reader doesn't block
+			if ferr != nil {
+				continue
+			}
+			k := key{Table: rec.Table, Key: rec.Key}
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			if rec.Expired() && !options.KeepExpired {
+				continue
+			}
+			ferr = fn(rec)
+		}
+		if err := r.Err(); err != nil {
+			return headers, err
+		}
+		if ferr != nil {
+			return headers, ferr
+		}
+
+		if id = r.Header().Base; len(id) == 0 {
+			return headers, nil
+		}
+	}
+}
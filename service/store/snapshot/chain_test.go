@@ -0,0 +1,87 @@
+package snapshot
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"c-z.dev/go-micro/store"
+)
+
+func TestReplay(t *testing.T) {
+	loc := "file://" + filepath.Join(t.TempDir(), "chain")
+
+	if _, err := Replay(loc, Latest, func(*Record) error { return nil }); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected %v replaying an empty chain, got %v", ErrNotFound, err)
+	}
+
+	take := func(id, base string, records ...*Record) {
+		dest, err := Locate(loc, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sn, err := NewSnapshot(dest, Tables(testTable), ID(id), Base(base))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sn.Init(); err != nil {
+			t.Fatal(err)
+		}
+		recordChan, err := sn.Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range records {
+			recordChan <- r
+		}
+		close(recordChan)
+		if err := sn.Wait(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	value := func(k, v string) *Record {
+		return &Record{Table: testTable, Record: &store.Record{Key: k, Value: []byte(v)}}
+	}
+
+	take("a", "", value("one", "1"), value("two", "2"))
+	take("b", "a", value("two", "2b"), value("three", "3"),
+		&Record{Table: testTable, Record: &store.Record{Key: "one"}, Deleted: true})
+	take(Latest, "b")
+
+	replay := func(id string) map[string]string {
+		state := make(map[string]string)
+		if _, err := Replay(loc, id, func(r *Record) error {
+			if r.Deleted {
+				state[r.Key] = "deleted"
+			} else {
+				state[r.Key] = string(r.Value)
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return state
+	}
+
+	for id, expected := range map[string]map[string]string{
+		"a":    {"one": "1", "two": "2"},
+		"b":    {"one": "deleted", "two": "2b", "three": "3"},
+		Latest: {"one": "deleted", "two": "2b", "three": "3"},
+	} {
+		state := replay(id)
+		if len(state) != len(expected) {
+			t.Errorf("expected %v at %s, got %v", expected, id, state)
+			continue
+		}
+		for k, v := range expected {
+			if state[k] != v {
+				t.Errorf("expected %s=%s at %s, got %s", k, v, id, state[k])
+			}
+		}
+	}
+
+	take("c", "c")
+	if _, err := Replay(loc, "c", func(*Record) error { return nil }); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected %v replaying a snapshot based on itself, got %v", ErrCorrupt, err)
+	}
+}
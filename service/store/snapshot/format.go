@@ -0,0 +1,461 @@
+package snapshot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"time"
+
+	"c-z.dev/go-micro/store"
+	"github.com/klauspost/compress/zstd"
+)
+
+// A snapshot archive is laid out as follows:
+//
+//	magic (4 bytes) | format version (1 byte) | compression (1 byte) | body
+//
+// The body is compressed with the algorithm named by the compression byte and holds a gob
+// stream made up of a header, one entry for every table change or record, and a trailer.
+// The trailer carries the number of records written and a checksum over all of them, so a
+// truncated or corrupted archive can be told apart from a complete one.
+
+// FormatVersion is the version of the archive format written by this package.
+// Version 2 added snapshot IDs and deleted records for incremental snapshots,
+// version 3 added record metadata.
+const FormatVersion = 3
+
+var magic = []byte("MSNP")
+
+func init() {
+	// register the types commonly nested in record metadata so they can be encoded as interface values
+	gob.Register(map[string]interface{}{})
+	gob.Register([]interface{}{})
+	gob.Register(time.Time{})
+}
+
+var (
+	// ErrInvalidFormat is returned when the input is not a snapshot archive
+	ErrInvalidFormat = errors.New("not a snapshot archive")
+	// ErrUnsupportedVersion is returned when the archive was written by a newer format version
+	ErrUnsupportedVersion = errors.New("unsupported snapshot format version")
+	// ErrTruncated is returned when the archive ends before its trailer
+	ErrTruncated = errors.New("snapshot is truncated")
+	// ErrCorrupt is returned when the archive checksum or record count doesn't match its contents
+	ErrCorrupt = errors.New("snapshot is corrupt")
+	// ErrNotFound is returned when there is no snapshot at the source
+	ErrNotFound = errors.New("snapshot not found")
+)
+
+// Compression is the algorithm used to compress the body of an archive
+type Compression byte
+
+const (
+	// NoCompression stores the body as is
+	NoCompression Compression = iota
+	// Gzip compresses the body with gzip
+	Gzip
+	// Zstd compresses the body with zstandard
+	Zstd
+)
+
+func (c Compression) String() string {
+	switch c {
+	case NoCompression:
+		return "none"
+	case Gzip:
+		return "gzip"
+	case Zstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("unknown(%d)", byte(c))
+	}
+}
+
+// ParseCompression parses the name of a compression algorithm, e.g. "gzip"
+func ParseCompression(s string) (Compression, error) {
+	switch s {
+	case "", "none":
+		return NoCompression, nil
+	case "gzip":
+		return Gzip, nil
+	case "zstd":
+		return Zstd, nil
+	default:
+		return NoCompression, fmt.Errorf("unsupported compression %s (wanted none, gzip or zstd)", s)
+	}
+}
+
+// Table identifies a table in a store
+type Table struct {
+	Database string
+	Table    string
+}
+
+func (t Table) String() string {
+	return t.Database + "/" + t.Table
+}
+
+// Record is a store.Record along with the table it belongs to
+type Record struct {
+	Table
+	*store.Record
+	// Deleted is set when the record was deleted since the base of an incremental snapshot,
+	// in which case only the key is set
+	Deleted bool
+	// ExpiresAt is the time the record expires, it is set when the record is read from a
+	// snapshot and takes precedence over Expiry when the record is written to one
+	ExpiresAt time.Time
+
+	// abort is set on the record sent by Abort
+	abort error
+}
+
+// Expired returns true if the record expired before now
+func (r *Record) Expired() bool {
+	return !r.ExpiresAt.IsZero() && !r.ExpiresAt.After(time.Now())
+}
+
+// Header describes the contents of an archive
+type Header struct {
+	// Version is the format version the archive was written with
+	Version int
+	// ID identifies the snapshot within a chain of snapshots, see Locate
+	ID string
+	// Base is the ID of the snapshot an incremental snapshot was taken against,
+	// it is empty for a full snapshot
+	Base string
+	// Created is the time the snapshot was started
+	Created time.Time
+	// Tables are the tables captured in the archive
+	Tables []Table
+}
+
+// entry is a single item in the body of an archive, only one field is set
+type entry struct {
+	Table   *Table
+	Record  *record
+	Trailer *trailer
+}
+
+// trailer terminates an archive
+type trailer struct {
+	Count    uint64
+	Checksum []byte
+}
+
+// record is a store.Record when serialised to persistent storage.
+type record struct {
+	Key       string
+	Value     []byte
+	ExpiresAt time.Time
+	Metadata  map[string]interface{}
+	Deleted   bool
+}
+
+// archiveWriter streams records into an archive
+type archiveWriter struct {
+	compressor io.WriteCloser
+	encoder    *gob.Encoder
+	checksum   hash.Hash
+	count      uint64
+	tables     map[Table]bool
+	current    *Table
+}
+
+// newArchiveWriter writes the preamble and header of an archive to w
+func newArchiveWriter(w io.Writer, c Compression, h *Header) (*archiveWriter, error) {
+	if _, err := w.Write(append(append([]byte{}, magic...), FormatVersion, byte(c))); err != nil {
+		return nil, err
+	}
+
+	a := &archiveWriter{
+		checksum: sha256.New(),
+		tables:   make(map[Table]bool, len(h.Tables)),
+	}
+	for _, t := range h.Tables {
+		a.tables[t] = true
+	}
+
+	switch c {
+	case NoCompression:
+	case Gzip:
+		a.compressor = gzip.NewWriter(w)
+	case Zstd:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		a.compressor = zw
+	default:
+		return nil, fmt.Errorf("unsupported compression %s", c)
+	}
+	if a.compressor != nil {
+		w = a.compressor
+	}
+
+	a.encoder = gob.NewEncoder(w)
+	h.Version = FormatVersion
+	if err := a.encoder.Encode(h); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Write adds a record to the archive
+func (a *archiveWriter) Write(r *Record) error {
+	if !a.tables[r.Table] {
+		return fmt.Errorf("table %s is not listed in the snapshot header", r.Table)
+	}
+	if a.current == nil || *a.current != r.Table {
+		t := r.Table
+		if err := a.encoder.Encode(&entry{Table: &t}); err != nil {
+			return err
+		}
+		a.current = &t
+	}
+
+	ir := &record{
+		Key:      r.Key,
+		Value:    r.Value,
+		Metadata: r.Metadata,
+		Deleted:  r.Deleted,
+	}
+	// the absolute expiry is stored so it doesn't move while the archive sits on disk
+	if r.Deleted {
+		ir.Metadata = nil
+	} else if !r.ExpiresAt.IsZero() {
+		ir.ExpiresAt = r.ExpiresAt
+	} else if r.Expiry != 0 {
+		ir.ExpiresAt = time.Now().Add(r.Expiry)
+	}
+	if err := a.encoder.Encode(&entry{Record: ir}); err != nil {
+		return err
+	}
+	a.count++
+	sumRecord(a.checksum, r.Table, ir)
+	return nil
+}
+
+// Close writes the trailer and flushes any compression. It doesn't close the underlying writer
+func (a *archiveWriter) Close() error {
+	if err := a.encoder.Encode(&entry{Trailer: &trailer{
+		Count:    a.count,
+		Checksum: a.checksum.Sum(nil),
+	}}); err != nil {
+		return err
+	}
+	if a.compressor != nil {
+		return a.compressor.Close()
+	}
+	return nil
+}
+
+// writeArchive encodes the records received over the channel as an archive into w until the
+// channel is closed. The channel is drained even after a failure so the sender never blocks.
+func writeArchive(w io.Writer, records <-chan *Record, opts SnapshotOptions) error {
+	archive, err := newArchiveWriter(w, opts.Compression, &Header{
+		ID:      opts.ID,
+		Base:    opts.Base,
+		Created: time.Now(),
+		Tables:  opts.Tables,
+	})
+	if err != nil {
+		err = fmt.Errorf("couldn't write snapshot header: %w", err)
+	}
+	for r := range records {
+		if err == nil && r.abort != nil {
+			err = r.abort
+		}
+		if err != nil {
+			continue
+		}
+		if werr := archive.Write(r); werr != nil {
+			err = fmt.Errorf("couldn't write %s: %w", r.Key, werr)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// archiveReader reads records from an archive, verifying it as it goes
+type archiveReader struct {
+	header     Header
+	decoder    *gob.Decoder
+	decompress io.Closer
+	checksum   hash.Hash
+	count      uint64
+	current    *Table
+}
+
+// newArchiveReader reads the preamble and header of an archive from r
+func newArchiveReader(r io.Reader) (*archiveReader, error) {
+	preamble := make([]byte, len(magic)+2)
+	if _, err := io.ReadFull(r, preamble); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, ErrInvalidFormat
+	} else if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(preamble[:len(magic)], magic) {
+		return nil, ErrInvalidFormat
+	}
+	if v := int(preamble[len(magic)]); v > FormatVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, v)
+	}
+
+	a := &archiveReader{checksum: sha256.New()}
+	switch c := Compression(preamble[len(magic)+1]); c {
+	case NoCompression:
+	case Gzip:
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		a.decompress = gr
+		r = gr
+	case Zstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		a.decompress = zr.IOReadCloser()
+		r = zr
+	default:
+		return nil, fmt.Errorf("%w: unknown compression %s", ErrInvalidFormat, c)
+	}
+
+	a.decoder = gob.NewDecoder(r)
+	if err := a.decoder.Decode(&a.header); err != nil {
+		a.Close()
+		return nil, decodeError(err)
+	}
+	return a, nil
+}
+
+// Header returns the archive header
+func (a *archiveReader) Header() Header {
+	return a.header
+}
+
+// Next returns the next record in the archive. It returns io.EOF once the trailer has been
+// read and the archive has been verified
+func (a *archiveReader) Next() (*Table, *record, error) {
+	for {
+		var e entry
+		if err := a.decoder.Decode(&e); err != nil {
+			return nil, nil, decodeError(err)
+		}
+		switch {
+		case e.Table != nil:
+			a.current = e.Table
+		case e.Record != nil:
+			if a.current == nil {
+				return nil, nil, fmt.Errorf("%w: record %s precedes its table", ErrCorrupt, e.Record.Key)
+			}
+			a.count++
+			sumRecord(a.checksum, *a.current, e.Record)
+			return a.current, e.Record, nil
+		case e.Trailer != nil:
+			if e.Trailer.Count != a.count {
+				return nil, nil, fmt.Errorf("%w: read %d records, trailer expects %d", ErrCorrupt, a.count, e.Trailer.Count)
+			}
+			if !bytes.Equal(e.Trailer.Checksum, a.checksum.Sum(nil)) {
+				return nil, nil, fmt.Errorf("%w: checksum mismatch", ErrCorrupt)
+			}
+			return nil, nil, io.EOF
+		default:
+			return nil, nil, fmt.Errorf("%w: empty entry", ErrCorrupt)
+		}
+	}
+}
+
+// Close releases any resources held by decompression
+func (a *archiveReader) Close() error {
+	if a.decompress != nil {
+		return a.decompress.Close()
+	}
+	return nil
+}
+
+// sendRecords sends every record in the archive over the channel. Records which have expired
+// are skipped unless keepExpired is set, in which case they are sent without an Expiry.
+func (a *archiveReader) sendRecords(records chan<- *Record, keepExpired bool) error {
+	for {
+		t, r, err := a.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rec := &Record{
+			Table: *t,
+			Record: &store.Record{
+				Key:      r.Key,
+				Value:    r.Value,
+				Metadata: r.Metadata,
+			},
+			Deleted:   r.Deleted,
+			ExpiresAt: r.ExpiresAt,
+		}
+		if rec.Expired() {
+			if !keepExpired {
+				continue
+			}
+		} else if !r.ExpiresAt.IsZero() {
+			rec.Expiry = time.Until(r.ExpiresAt)
+		}
+		records <- rec
+	}
+}
+
+// decodeError maps errors from the gob decoder onto the package errors
+func decodeError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+	return fmt.Errorf("%w: %v", ErrCorrupt, err)
+}
+
+// sumRecord adds a record to an archive checksum
+func sumRecord(h hash.Hash, t Table, r *record) {
+	var buf [binary.MaxVarintLen64]byte
+	write := func(b []byte) {
+		n := binary.PutUvarint(buf[:], uint64(len(b)))
+		h.Write(buf[:n])
+		h.Write(b)
+	}
+	write([]byte(t.Database))
+	write([]byte(t.Table))
+	write([]byte(r.Key))
+	write(r.Value)
+	n := binary.PutVarint(buf[:], r.ExpiresAt.UnixNano())
+	if r.ExpiresAt.IsZero() {
+		n = binary.PutVarint(buf[:], 0)
+	}
+	h.Write(buf[:n])
+	// metadata and deletion are only summed when set so older archives still verify
+	if len(r.Metadata) > 0 {
+		keys := make([]string, 0, len(r.Metadata))
+		for k := range r.Metadata {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			write([]byte(k))
+			// fmt prints maps sorted by key so nested values sum consistently
+			write([]byte(fmt.Sprintf("%T:%v", r.Metadata[k], r.Metadata[k])))
+		}
+	}
+	if r.Deleted {
+		h.Write([]byte{1})
+	}
+}
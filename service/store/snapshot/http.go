@@ -0,0 +1,194 @@
+package snapshot
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+var errUploadFinished = errors.New("upload finished before the snapshot was written")
+
+// HTTPSnapshot uploads a snapshot to an object store compatible endpoint with a single PUT
+// request, e.g. https://bucket.example.com/backups/store-snapshot
+type HTTPSnapshot struct {
+	Options SnapshotOptions
+	// Client is used to upload the snapshot, http.DefaultClient is used if nil
+	Client *http.Client
+
+	url       string
+	records   chan *Record
+	writeErr  error
+	uploadErr error
+	wg        *sync.WaitGroup
+}
+
+// NewHTTPSnapshot returns an HTTPSnapshot
+func NewHTTPSnapshot(opts ...SnapshotOption) Snapshot {
+	h := &HTTPSnapshot{wg: &sync.WaitGroup{}}
+	for _, o := range opts {
+		o(&h.Options)
+	}
+	return h
+}
+
+// Init validates the options
+func (h *HTTPSnapshot) Init(opts ...SnapshotOption) error {
+	for _, o := range opts {
+		o(&h.Options)
+	}
+	u, err := url.Parse(h.Options.Destination)
+	if err != nil {
+		return fmt.Errorf("destination is invalid: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %s (wanted http or https)", u.Scheme)
+	}
+	if h.wg == nil {
+		h.wg = &sync.WaitGroup{}
+	}
+	h.url = u.String()
+	return nil
+}
+
+// Start opens a channel which receives *Record and streams them to the endpoint
+func (h *HTTPSnapshot) Start() (chan<- *Record, error) {
+	if h.records != nil {
+		return nil, errors.New("snapshot is already in use")
+	}
+	pr, pw := io.Pipe()
+	req, err := http.NewRequest(http.MethodPut, h.url, pr)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	h.writeErr = nil
+	h.uploadErr = nil
+	h.records = make(chan *Record)
+	h.wg.Add(2)
+	go func(records <-chan *Record) {
+		defer h.wg.Done()
+		h.writeErr = writeArchive(pw, records, h.Options)
+		pw.CloseWithError(h.writeErr)
+	}(h.records)
+	go func() {
+		defer h.wg.Done()
+		rsp, err := client.Do(req)
+		if err != nil {
+			h.uploadErr = fmt.Errorf("couldn't upload snapshot to %s: %w", h.url, err)
+		} else {
+			rsp.Body.Close()
+			if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+				h.uploadErr = fmt.Errorf("couldn't upload snapshot to %s: %s", h.url, rsp.Status)
+			}
+		}
+		// the request is over, make sure the writer isn't left blocked
+		pr.CloseWithError(errUploadFinished)
+	}()
+	return h.records, nil
+}
+
+// Wait waits for the upload to complete
+func (h *HTTPSnapshot) Wait() error {
+	h.wg.Wait()
+	h.records = nil
+	if h.writeErr != nil && !errors.Is(h.writeErr, errUploadFinished) {
+		return h.writeErr
+	}
+	if h.uploadErr != nil {
+		return h.uploadErr
+	}
+	return h.writeErr
+}
+
+// HTTPRestore downloads a snapshot from an object store compatible endpoint with a GET request
+type HTTPRestore struct {
+	Options RestoreOptions
+	// Client is used to download the snapshot, http.DefaultClient is used if nil
+	Client *http.Client
+
+	url    string
+	header Header
+	err    error
+}
+
+// NewHTTPRestore returns an HTTPRestore
+func NewHTTPRestore(opts ...RestoreOption) Restore {
+	h := &HTTPRestore{}
+	for _, o := range opts {
+		o(&h.Options)
+	}
+	return h
+}
+
+// Init validates the options
+func (h *HTTPRestore) Init(opts ...RestoreOption) error {
+	for _, o := range opts {
+		o(&h.Options)
+	}
+	u, err := url.Parse(h.Options.Source)
+	if err != nil {
+		return fmt.Errorf("source is invalid: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %s (wanted http or https)", u.Scheme)
+	}
+	h.url = u.String()
+	return nil
+}
+
+// Start downloads the snapshot and streams its records. The returned channel is closed when complete
+func (h *HTTPRestore) Start() (<-chan *Record, error) {
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	rsp, err := client.Get(h.url)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't download snapshot from %s: %w", h.url, err)
+	}
+	if rsp.StatusCode == http.StatusNotFound {
+		rsp.Body.Close()
+		return nil, fmt.Errorf("couldn't download snapshot from %s: %w", h.url, ErrNotFound)
+	}
+	if rsp.StatusCode != http.StatusOK {
+		rsp.Body.Close()
+		return nil, fmt.Errorf("couldn't download snapshot from %s: %s", h.url, rsp.Status)
+	}
+	archive, err := newArchiveReader(bufio.NewReader(rsp.Body))
+	if err != nil {
+		rsp.Body.Close()
+		return nil, fmt.Errorf("couldn't read snapshot %s: %w", h.url, err)
+	}
+	h.header = archive.Header()
+	h.err = nil
+	recordChan := make(chan *Record)
+	go func(records chan<- *Record, reader io.ReadCloser) {
+		defer close(records)
+		defer reader.Close()
+		defer archive.Close()
+		if err := archive.sendRecords(records, h.Options.KeepExpired); err != nil {
+			h.err = fmt.Errorf("couldn't read snapshot %s: %w", h.url, err)
+		}
+	}(recordChan, rsp.Body)
+	return recordChan, nil
+}
+
+// Header returns the header of the downloaded snapshot
+func (h *HTTPRestore) Header() Header {
+	return h.header
+}
+
+// Err returns the first error encountered while downloading the snapshot
+func (h *HTTPRestore) Err() error {
+	return h.err
+}
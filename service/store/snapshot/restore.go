@@ -1,14 +1,11 @@
 package snapshot
 
 import (
-	"encoding/gob"
+	"bufio"
 	"fmt"
 	"io"
 	"net/url"
 	"os"
-	"time"
-
-	"c-z.dev/go-micro/store"
 )
 
 // Restore emits records from a go-micro store snapshot
@@ -18,12 +15,43 @@ type Restore interface {
 	Init(opts ...RestoreOption) error
 	// Start opens a channel over which records from the snapshot are retrieved.
 	// The channel will be closed when the entire snapshot has been read.
-	Start() (<-chan *store.Record, error)
+	// ErrNotFound is returned if there is no snapshot at the source.
+	Start() (<-chan *Record, error)
+	// Header returns the header of the snapshot, it is available once Start has returned
+	Header() Header
+	// Err returns the first error encountered while reading the snapshot, e.g. because
+	// it is truncated or corrupt. It must be checked once the channel has been closed.
+	Err() error
+}
+
+// DefaultRestores are the Restore implementations keyed by the scheme of the source URL.
+// Plugins can register additional schemes, e.g. from internal/plugins
+var DefaultRestores = map[string]func(...RestoreOption) Restore{
+	"file":  NewFileRestore,
+	"store": NewStoreRestore,
+	"http":  NewHTTPRestore,
+	"https": NewHTTPRestore,
+}
+
+// NewRestore returns the Restore registered for the scheme of the source URL
+func NewRestore(source string, opts ...RestoreOption) (Restore, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, fmt.Errorf("source is invalid: %w", err)
+	}
+	newRestore, ok := DefaultRestores[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported source scheme: %s", u.Scheme)
+	}
+	return newRestore(append(opts, Source(source))...), nil
 }
 
 // RestoreOptions configure a Restore
 type RestoreOptions struct {
 	Source string
+	// KeepExpired restores records which expired since the snapshot was taken without an
+	// expiry, rather than skipping them
+	KeepExpired bool
 }
 
 // RestoreOption is an individual option
@@ -36,11 +64,20 @@ func Source(source string) RestoreOption {
 	}
 }
 
+// KeepExpired sets whether records which expired since the snapshot was taken are kept
+func KeepExpired(keep bool) RestoreOption {
+	return func(r *RestoreOptions) {
+		r.KeepExpired = keep
+	}
+}
+
 // FileRestore reads records from a file
 type FileRestore struct {
 	Options RestoreOptions
 
-	path string
+	path   string
+	header Header
+	err    error
 }
 
 func NewFileRestore(opts ...RestoreOption) Restore {
@@ -67,35 +104,38 @@ func (f *FileRestore) Init(opts ...RestoreOption) error {
 }
 
 // Start starts reading records from a file. The returned channel is closed when complete
-func (f *FileRestore) Start() (<-chan *store.Record, error) {
+func (f *FileRestore) Start() (<-chan *Record, error) {
 	fi, err := os.Open(f.path)
-	if err != nil {
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("couldn't open file %s: %w", f.path, ErrNotFound)
+	} else if err != nil {
 		return nil, fmt.Errorf("Couldn't open file %s: %w", f.path, err)
 	}
-	recordChan := make(chan *store.Record)
-	go func(records chan<- *store.Record, reader io.ReadCloser) {
-		defer close(recordChan)
+	archive, err := newArchiveReader(bufio.NewReader(fi))
+	if err != nil {
+		fi.Close()
+		return nil, fmt.Errorf("couldn't read snapshot %s: %w", f.path, err)
+	}
+	f.header = archive.Header()
+	f.err = nil
+	recordChan := make(chan *Record)
+	go func(records chan<- *Record, reader io.ReadCloser) {
+		defer close(records)
 		defer reader.Close()
-		dec := gob.NewDecoder(fi)
-		var r record
-		for {
-			err := dec.Decode(&r)
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				panic(err)
-			}
-			rec := &store.Record{
-				Key: r.Key,
-			}
-			rec.Value = make([]byte, len(r.Value))
-			copy(rec.Value, r.Value)
-			if !r.ExpiresAt.IsZero() {
-				rec.Expiry = time.Until(r.ExpiresAt)
-			}
-			recordChan <- rec
+		defer archive.Close()
+		if err := archive.sendRecords(records, f.Options.KeepExpired); err != nil {
+			f.err = fmt.Errorf("couldn't read snapshot %s: %w", f.path, err)
 		}
 	}(recordChan, fi)
 	return recordChan, nil
 }
+
+// Header returns the header of the file
+func (f *FileRestore) Header() Header {
+	return f.header
+}
+
+// Err returns the first error encountered while reading the file
+func (f *FileRestore) Err() error {
+	return f.err
+}
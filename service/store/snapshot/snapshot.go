@@ -1,15 +1,13 @@
 package snapshot
 
 import (
-	"encoding/gob"
+	"bufio"
 	"errors"
 	"fmt"
 	"net/url"
 	"os"
+	"path/filepath"
 	"sync"
-	"time"
-
-	"c-z.dev/go-micro/store"
 )
 
 // Snapshot creates snapshots of a go-micro store
@@ -17,16 +15,54 @@ type Snapshot interface {
 	// Init validates the Snapshot options and returns an error if they are invalid.
 	// Init must be called before the Snapshot is used
 	Init(opts ...SnapshotOption) error
-	// Start opens a channel that receives *store.Record, adding any incoming records to a backup
+	// Start opens a channel that receives *Record, adding any incoming records to a backup
 	// close() the channel to commit the results.
-	Start() (chan<- *store.Record, error)
-	// Wait waits for any operations to be committed to underlying storage
-	Wait()
+	Start() (chan<- *Record, error)
+	// Wait waits for any operations to be committed to underlying storage and returns
+	// the first error encountered while writing the snapshot
+	Wait() error
+}
+
+// Abort fails the snapshot records are being sent to with err and closes the channel,
+// nothing is committed and Wait returns err
+func Abort(records chan<- *Record, err error) {
+	records <- &Record{abort: err}
+	close(records)
+}
+
+// DefaultSnapshots are the Snapshot implementations keyed by the scheme of the destination URL.
+// Plugins can register additional schemes, e.g. from internal/plugins
+var DefaultSnapshots = map[string]func(...SnapshotOption) Snapshot{
+	"file":  NewFileSnapshot,
+	"store": NewStoreSnapshot,
+	"http":  NewHTTPSnapshot,
+	"https": NewHTTPSnapshot,
+}
+
+// NewSnapshot returns the Snapshot registered for the scheme of the destination URL
+func NewSnapshot(dest string, opts ...SnapshotOption) (Snapshot, error) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return nil, fmt.Errorf("destination is invalid: %w", err)
+	}
+	newSnapshot, ok := DefaultSnapshots[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported destination scheme: %s", u.Scheme)
+	}
+	return newSnapshot(append(opts, Destination(dest))...), nil
 }
 
 // SnapshotOptions configure a snapshotter
 type SnapshotOptions struct {
 	Destination string
+	// Tables are the tables which will be captured, records from any other table are rejected
+	Tables []Table
+	// Compression of the snapshot body
+	Compression Compression
+	// ID identifies the snapshot within a chain of snapshots
+	ID string
+	// Base is the ID of the snapshot an incremental snapshot only records changes against
+	Base string
 }
 
 // SnapshotOption is an individual option
@@ -39,14 +75,42 @@ func Destination(dest string) SnapshotOption {
 	}
 }
 
+// Tables sets the tables which are recorded in the snapshot header
+func Tables(tables ...Table) SnapshotOption {
+	return func(s *SnapshotOptions) {
+		s.Tables = tables
+	}
+}
+
+// Compress sets the compression used for the snapshot body
+func Compress(c Compression) SnapshotOption {
+	return func(s *SnapshotOptions) {
+		s.Compression = c
+	}
+}
+
+// ID sets the ID recorded in the snapshot header
+func ID(id string) SnapshotOption {
+	return func(s *SnapshotOptions) {
+		s.ID = id
+	}
+}
+
+// Base marks the snapshot as incremental, only holding the changes since the snapshot with the given ID
+func Base(id string) SnapshotOption {
+	return func(s *SnapshotOptions) {
+		s.Base = id
+	}
+}
+
 // FileSnapshot backs up incoming records to a File
 type FileSnapshot struct {
 	Options SnapshotOptions
 
-	records chan *store.Record
+	records chan *Record
 	path    string
-	encoder *gob.Encoder
 	file    *os.File
+	err     error
 	wg      *sync.WaitGroup
 }
 
@@ -78,59 +142,55 @@ func (f *FileSnapshot) Init(opts ...SnapshotOption) error {
 	return nil
 }
 
-// Start opens a channel which recieves *store.Record and writes them to storage
-func (f *FileSnapshot) Start() (chan<- *store.Record, error) {
-	if f.records != nil || f.encoder != nil || f.file != nil {
+// Start opens a channel which recieves *Record and writes them to storage.
+// Records are written to a temporary file which replaces the destination once
+// the channel is closed, so an interrupted snapshot never overwrites a complete one.
+func (f *FileSnapshot) Start() (chan<- *Record, error) {
+	if f.records != nil || f.file != nil {
 		return nil, errors.New("snapshot is already in use")
 	}
-	fi, err := os.OpenFile(f.path, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0o600)
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o700); err != nil {
+		return nil, fmt.Errorf("couldn't create directory for %s: %w", f.path, err)
+	}
+	fi, err := os.OpenFile(f.path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0o600)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't open file %s: %w", f.path, err)
 	}
-	f.encoder = gob.NewEncoder(fi)
 	f.file = fi
-	f.records = make(chan *store.Record)
+	f.err = nil
+	f.records = make(chan *Record)
+	f.wg.Add(1)
 	go f.receiveRecords(f.records)
 	return f.records, nil
 }
 
 // Wait waits for the snapshotter to commit the backups to persistent storage
-func (f *FileSnapshot) Wait() {
+func (f *FileSnapshot) Wait() error {
 	f.wg.Wait()
+	return f.err
 }
 
-func (f *FileSnapshot) receiveRecords(rec <-chan *store.Record) {
-	f.wg.Add(1)
-	for {
-		r, more := <-rec
-		if !more {
-			println("Stopping FileSnapshot")
-			f.file.Close()
-			f.encoder = nil
-			f.file = nil
-			f.records = nil
-			break
-		}
-		ir := record{
-			Key: r.Key,
-		}
-		if r.Expiry != 0 {
-			ir.ExpiresAt = time.Now().Add(r.Expiry)
-		}
-		ir.Value = make([]byte, len(r.Value))
-		copy(ir.Value, r.Value)
-		if err := f.encoder.Encode(ir); err != nil {
-			// only thing to do here is panic
-			panic(fmt.Errorf("couldn't write to file: %w", err))
-		}
-		println("encoded", ir.Key)
-	}
-	f.wg.Done()
-}
-
-// record is a store.Record when serialised to persistent storage.
-type record struct {
-	Key       string
-	Value     []byte
-	ExpiresAt time.Time
+func (f *FileSnapshot) receiveRecords(rec <-chan *Record) {
+	defer f.wg.Done()
+
+	w := bufio.NewWriter(f.file)
+	err := writeArchive(w, rec, f.Options)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.file.Sync()
+	}
+	if cerr := f.file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.file.Name(), f.path)
+	} else {
+		os.Remove(f.file.Name())
+	}
+
+	f.err = err
+	f.file = nil
+	f.records = nil
 }
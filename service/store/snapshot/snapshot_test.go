@@ -1,52 +1,384 @@
 package snapshot
 
 import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
 	"testing"
 	"time"
 
+	"c-z.dev/go-micro/config/cmd"
 	"c-z.dev/go-micro/store"
+	"c-z.dev/go-micro/store/memory"
 )
 
 func TestFileSnapshot(t *testing.T) {
-	f := NewFileSnapshot(Destination("invalid"))
-	if err := f.Init(); err == nil {
-		t.Error(err)
+	for _, c := range []Compression{NoCompression, Gzip, Zstd} {
+		t.Run(c.String(), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test-snapshot")
+			writeSnapshot(t, path, Compress(c))
+
+			r := NewFileRestore(Source("invalid"))
+			if err := r.Init(); err == nil {
+				t.Error(err)
+			}
+			if err := r.Init(Source("file://" + path)); err != nil {
+				t.Error(err)
+			}
+
+			returnChan, err := r.Start()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var receivedData []*Record
+			for r := range returnChan {
+				receivedData = append(receivedData, r)
+			}
+			if err := r.Err(); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(receivedData) != len(testData) {
+				t.Fatalf("expected %d records, got %d", len(testData), len(receivedData))
+			}
+			for i, r := range receivedData {
+				if r.Table != testTable {
+					t.Errorf("expected table %v, got %v", testTable, r.Table)
+				}
+				if r.Key != testData[i].Key || string(r.Value) != string(testData[i].Value) {
+					t.Errorf("expected %s=%s, got %s=%s", testData[i].Key, testData[i].Value, r.Key, r.Value)
+				}
+				if r.Expiry <= 0 || r.Expiry > 5*time.Second {
+					t.Errorf("unexpected expiry %v for %s", r.Expiry, r.Key)
+				}
+			}
+		})
 	}
-	if err := f.Init(Destination("file:///tmp/test-snapshot")); err != nil {
-		t.Error(err)
+}
+
+func TestHTTPSnapshot(t *testing.T) {
+	var (
+		mtx    sync.Mutex
+		object []byte
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			b, err := io.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			object = b
+		case http.MethodGet:
+			if object == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(object)
+		}
+	}))
+	defer srv.Close()
+
+	dest := srv.URL + "/backups/snapshot"
+	r, err := NewRestore(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Start(); err == nil {
+		t.Error("expected an error restoring a missing object")
+	}
+
+	sn, err := NewSnapshot(dest, Tables(testTable), Compress(Gzip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendTestData(t, sn)
+	checkRestore(t, r)
+}
+
+func TestStoreSnapshot(t *testing.T) {
+	backend := memory.NewStore()
+	cmd.DefaultStores["snapshot-test"] = func(opts ...store.Option) store.Store {
+		backend.Init(opts...)
+		return backend
+	}
+	defer delete(cmd.DefaultStores, "snapshot-test")
+
+	dest := "store://snapshot-test/backups/nightly"
+	backend.Write(&store.Record{Key: "stale"}, store.WriteTo("backups", "nightly"))
+
+	r, err := NewRestore(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Start(); !errors.Is(err, ErrTruncated) {
+		t.Errorf("expected %v restoring a snapshot without a manifest, got %v", ErrTruncated, err)
+	}
+
+	sn, err := NewSnapshot(dest, Tables(testTable))
+	if err != nil {
+		t.Fatal(err)
 	}
+	sendTestData(t, sn)
+	checkRestore(t, r)
 
+	// a failed snapshot leaves the complete one in place and removes what it staged
+	keys, _ := backend.List(store.ListFrom("backups", "nightly"))
+	recordChan, err := sn.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordChan <- &Record{Table: testTable, Record: &store.Record{Key: "partial"}}
+	failed := errors.New("failed")
+	Abort(recordChan, failed)
+	if err := sn.Wait(); !errors.Is(err, failed) {
+		t.Errorf("expected the abort error, got %v", err)
+	}
+	checkRestore(t, r)
+	if after, _ := backend.List(store.ListFrom("backups", "nightly")); len(after) != len(keys) {
+		t.Errorf("expected the staged keys to be removed, got %v", after)
+	}
+}
+
+func TestFileSnapshotUnknownTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test-snapshot")
+	f := NewFileSnapshot(Destination("file://"+path), Tables(testTable))
+	if err := f.Init(); err != nil {
+		t.Fatal(err)
+	}
 	recordChan, err := f.Start()
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
+	}
+	recordChan <- &Record{Table: Table{Database: "other", Table: "table"}, Record: testData[0]}
+	close(recordChan)
+	if err := f.Wait(); err == nil {
+		t.Error("expected an error for a record from an unlisted table")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("a failed snapshot shouldn't be committed")
 	}
+}
 
-	for _, td := range testData {
-		recordChan <- td
+func TestFileSnapshotAbort(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test-snapshot")
+	writeSnapshot(t, path)
+
+	f := NewFileSnapshot(Destination("file://"+path), Tables(testTable))
+	if err := f.Init(); err != nil {
+		t.Fatal(err)
+	}
+	recordChan, err := f.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordChan <- &Record{Table: testTable, Record: testData[0]}
+	failed := errors.New("failed")
+	Abort(recordChan, failed)
+	if err := f.Wait(); !errors.Is(err, failed) {
+		t.Errorf("expected the abort error, got %v", err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Error("an aborted snapshot should be removed")
+	}
+
+	// the complete snapshot is left in place
+	r := NewFileRestore(Source("file://" + path))
+	if err := r.Init(); err != nil {
+		t.Fatal(err)
+	}
+	recs, err := r.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for range recs {
+		count++
 	}
+	if err := r.Err(); err != nil || count != len(testData) {
+		t.Errorf("expected %d records, got %d (%v)", len(testData), count, err)
+	}
+}
+
+func TestFileRestoreCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test-snapshot")
+	writeSnapshot(t, path)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flipped := append([]byte{}, b...)
+	flipped[len(flipped)-10] ^= 0xff
+
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"empty", []byte{}, ErrInvalidFormat},
+		{"garbage", []byte("not a snapshot at all"), ErrInvalidFormat},
+		{"truncated", b[:len(b)-40], ErrTruncated},
+		{"flipped", flipped, ErrCorrupt},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), tc.name)
+			if err := os.WriteFile(p, tc.data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			r := NewFileRestore(Source("file://" + p))
+			if err := r.Init(); err != nil {
+				t.Fatal(err)
+			}
+			recordChan, err := r.Start()
+			if err == nil {
+				for range recordChan {
+				}
+				err = r.Err()
+			}
+			if !errors.Is(err, tc.err) {
+				t.Errorf("expected %v, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestFileRestoreExpiryAndMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test-snapshot")
+	f := NewFileSnapshot(Destination("file://"+path), Tables(testTable))
+	if err := f.Init(); err != nil {
+		t.Fatal(err)
+	}
+	recordChan, err := f.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := map[string]interface{}{"owner": "micro", "size": 3, "tags": []interface{}{"a", "b"}}
+	recordChan <- &Record{Table: testTable, Record: &store.Record{Key: "live", Value: []byte("1"), Metadata: metadata}}
+	recordChan <- &Record{Table: testTable, Record: &store.Record{Key: "stale", Value: []byte("2")}, ExpiresAt: time.Now().Add(-time.Hour)}
 	close(recordChan)
-	f.Wait()
+	if err := f.Wait(); err != nil {
+		t.Fatal(err)
+	}
 
-	r := NewFileRestore(Source("invalid"))
-	if err := r.Init(); err == nil {
-		t.Error(err)
+	restore := func(keep bool) map[string]*Record {
+		r := NewFileRestore(Source("file://"+path), KeepExpired(keep))
+		if err := r.Init(); err != nil {
+			t.Fatal(err)
+		}
+		records, err := r.Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+		received := make(map[string]*Record)
+		for rec := range records {
+			received[rec.Key] = rec
+		}
+		if err := r.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return received
 	}
-	if err := r.Init(Source("file:///tmp/test-snapshot")); err != nil {
-		t.Error(err)
+
+	received := restore(false)
+	if len(received) != 1 || received["live"] == nil {
+		t.Fatalf("expected only the live record, got %v", received)
+	}
+	if !reflect.DeepEqual(received["live"].Metadata, metadata) {
+		t.Errorf("expected metadata %v, got %v", metadata, received["live"].Metadata)
 	}
 
-	returnChan, err := r.Start()
+	received = restore(true)
+	if len(received) != 2 || received["stale"] == nil {
+		t.Fatalf("expected the expired record to be kept, got %v", received)
+	}
+	if rec := received["stale"]; !rec.Expired() || rec.Expiry != 0 {
+		t.Errorf("expected the kept record to be expired with no expiry, got %v", rec.Expiry)
+	}
+}
+
+func sendTestData(t *testing.T, sn Snapshot) {
+	if err := sn.Init(); err != nil {
+		t.Fatal(err)
+	}
+	recordChan, err := sn.Start()
 	if err != nil {
+		t.Fatal(err)
+	}
+	for _, td := range testData {
+		recordChan <- &Record{Table: testTable, Record: td}
+	}
+	close(recordChan)
+	if err := sn.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkRestore(t *testing.T, r Restore) {
+	recordChan, err := r.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(map[string]string)
+	for rec := range recordChan {
+		if rec.Table != testTable {
+			t.Errorf("expected table %v, got %v", testTable, rec.Table)
+		}
+		received[rec.Key] = string(rec.Value)
+	}
+	if err := r.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != len(testData) {
+		t.Fatalf("expected %d records, got %d", len(testData), len(received))
+	}
+	for _, td := range testData {
+		if received[td.Key] != string(td.Value) {
+			t.Errorf("expected %s=%s, got %s", td.Key, td.Value, received[td.Key])
+		}
+	}
+}
+
+func writeSnapshot(t *testing.T, path string, opts ...SnapshotOption) {
+	f := NewFileSnapshot(Destination("invalid"))
+	if err := f.Init(); err == nil {
 		t.Error(err)
 	}
-	var receivedData []*store.Record
-	for r := range returnChan {
-		println("decoded", r.Key)
-		receivedData = append(receivedData, r)
+	opts = append(opts, Destination("file://"+path), Tables(testTable))
+	if err := f.Init(opts...); err != nil {
+		t.Fatal(err)
 	}
 
+	recordChan, err := f.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, td := range testData {
+		recordChan <- &Record{Table: testTable, Record: td}
+	}
+	close(recordChan)
+	if err := f.Wait(); err != nil {
+		t.Fatal(err)
+	}
 }
 
+var testTable = Table{Database: "micro", Table: "store"}
+
 var testData = []*store.Record{
 	{
 		Key:    "foo",
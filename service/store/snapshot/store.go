@@ -0,0 +1,351 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"c-z.dev/go-micro/config/cmd"
+	"c-z.dev/go-micro/store"
+)
+
+// manifestKey is written to a store snapshot once it is complete. Snapshotted keys are
+// always prefixed with their database and table so they never collide with it.
+const manifestKey = "manifest"
+
+// generationPrefix starts the key prefix each snapshot written to a table is staged under,
+// the manifest names the generation which is complete
+const generationPrefix = "generation-"
+
+// manifest describes a complete store snapshot
+type manifest struct {
+	Header
+	Count uint64
+	// Generation is the key prefix of the records, snapshots written before generations
+	// were staged have their records directly under the prefix
+	Generation string `json:",omitempty"`
+	// Deleted are the records deleted since the base of an incremental snapshot
+	Deleted []deleted `json:",omitempty"`
+}
+
+// deleted identifies a deleted record in a manifest
+type deleted struct {
+	Table
+	Key string
+}
+
+// StoreSnapshot copies records into a table of another go-micro store backend, e.g.
+// store://redis/backups/nightly?nodes=127.0.0.1:6379 snapshots into the nightly table
+// of the backups database of a redis store. Records are staged under a new generation and
+// the manifest only switches to it once they're all written, so a failed snapshot leaves the
+// previous one in place. Any other keys in the table are removed after the switch.
+// Any path after the table is used as a key prefix, so a table can hold several snapshots.
+type StoreSnapshot struct {
+	Options SnapshotOptions
+
+	store      store.Store
+	prefix     string
+	generation string
+	records    chan *Record
+	err        error
+	wg         *sync.WaitGroup
+}
+
+// NewStoreSnapshot returns a StoreSnapshot
+func NewStoreSnapshot(opts ...SnapshotOption) Snapshot {
+	s := &StoreSnapshot{wg: &sync.WaitGroup{}}
+	for _, o := range opts {
+		o(&s.Options)
+	}
+	return s
+}
+
+// Init validates the options and connects to the destination store
+func (s *StoreSnapshot) Init(opts ...SnapshotOption) error {
+	for _, o := range opts {
+		o(&s.Options)
+	}
+	st, prefix, err := storeFromURL(s.Options.Destination)
+	if err != nil {
+		return fmt.Errorf("destination is invalid: %w", err)
+	}
+	if s.wg == nil {
+		s.wg = &sync.WaitGroup{}
+	}
+	s.store = st
+	s.prefix = prefix
+	return nil
+}
+
+// Start opens a channel which receives *Record and writes them to a new generation of the
+// destination table. The manifest is switched to it once the channel is closed.
+func (s *StoreSnapshot) Start() (chan<- *Record, error) {
+	if s.records != nil {
+		return nil, errors.New("snapshot is already in use")
+	}
+
+	s.generation = generationPrefix + strconv.FormatInt(time.Now().UnixNano(), 36) + "/"
+	s.err = nil
+	s.records = make(chan *Record)
+	s.wg.Add(1)
+	go s.receiveRecords(s.records)
+	return s.records, nil
+}
+
+// Wait waits for every record and the manifest to be written
+func (s *StoreSnapshot) Wait() error {
+	s.wg.Wait()
+	return s.err
+}
+
+func (s *StoreSnapshot) receiveRecords(rec <-chan *Record) {
+	defer s.wg.Done()
+
+	tables := make(map[Table]bool, len(s.Options.Tables))
+	for _, t := range s.Options.Tables {
+		tables[t] = true
+	}
+	m := &manifest{Header: Header{
+		Version: FormatVersion,
+		ID:      s.Options.ID,
+		Base:    s.Options.Base,
+		Created: time.Now(),
+		Tables:  s.Options.Tables,
+	}, Generation: s.generation}
+
+	var err error
+	for r := range rec {
+		if err == nil && r.abort != nil {
+			err = r.abort
+		}
+		// keep draining the channel after a failure so the sender doesn't block
+		if err != nil {
+			continue
+		}
+		if !tables[r.Table] {
+			err = fmt.Errorf("table %s is not listed in the snapshot header", r.Table)
+			continue
+		}
+		if r.Deleted {
+			m.Deleted = append(m.Deleted, deleted{Table: r.Table, Key: r.Key})
+			continue
+		}
+		expiry := r.Expiry
+		if !r.ExpiresAt.IsZero() {
+			expiry = time.Until(r.ExpiresAt)
+		}
+		if werr := s.store.Write(&store.Record{
+			Key:      s.prefix + s.generation + strings.Join([]string{r.Database, r.Table.Table, r.Key}, "/"),
+			Value:    r.Value,
+			Metadata: r.Metadata,
+			Expiry:   expiry,
+		}); werr != nil {
+			err = fmt.Errorf("couldn't write %s to %s: %w", r.Key, s.store.String(), werr)
+			continue
+		}
+		m.Count++
+	}
+
+	if err == nil {
+		var b []byte
+		if b, err = json.Marshal(m); err == nil {
+			err = s.store.Write(&store.Record{Key: s.prefix + manifestKey, Value: b})
+		}
+	}
+	if err == nil {
+		s.clear(func(k string) bool {
+			return k == s.prefix+manifestKey || strings.HasPrefix(k, s.prefix+s.generation)
+		})
+	} else {
+		s.clear(func(k string) bool { return !strings.HasPrefix(k, s.prefix+s.generation) })
+	}
+	s.err = err
+	s.records = nil
+}
+
+// clear removes the keys under the prefix which aren't kept, the previous generation once
+// the manifest has switched or the staged one of a failed snapshot. Restores only read the
+// generation of the manifest, so keys which can't be removed are left for the next snapshot.
+func (s *StoreSnapshot) clear(keep func(string) bool) {
+	keys, err := s.store.List(store.ListPrefix(s.prefix))
+	if err != nil {
+		return
+	}
+	for _, k := range keys {
+		if !keep(k) {
+			s.store.Delete(k)
+		}
+	}
+}
+
+// StoreRestore reads records from a snapshot written by StoreSnapshot. The backend
+// expires records itself, so KeepExpired has no effect.
+type StoreRestore struct {
+	Options RestoreOptions
+
+	store  store.Store
+	prefix string
+	header Header
+	err    error
+}
+
+// NewStoreRestore returns a StoreRestore
+func NewStoreRestore(opts ...RestoreOption) Restore {
+	s := &StoreRestore{}
+	for _, o := range opts {
+		o(&s.Options)
+	}
+	return s
+}
+
+// Init validates the options and connects to the source store
+func (s *StoreRestore) Init(opts ...RestoreOption) error {
+	for _, o := range opts {
+		o(&s.Options)
+	}
+	st, prefix, err := storeFromURL(s.Options.Source)
+	if err != nil {
+		return fmt.Errorf("source is invalid: %w", err)
+	}
+	s.store = st
+	s.prefix = prefix
+	return nil
+}
+
+// Start reads the manifest and streams the records of the generation it names. The
+// returned channel is closed when complete
+func (s *StoreRestore) Start() (<-chan *Record, error) {
+	recs, err := s.store.Read(s.prefix + manifestKey)
+	if err == store.ErrNotFound {
+		// keys without a manifest are a snapshot which never completed
+		keys, err := s.store.List(store.ListPrefix(s.prefix))
+		if err != nil {
+			return nil, fmt.Errorf("couldn't list keys in %s: %w", s.store.String(), err)
+		} else if len(keys) == 0 {
+			return nil, fmt.Errorf("couldn't read snapshot from %s: %w", s.store.String(), ErrNotFound)
+		}
+		return nil, fmt.Errorf("couldn't read snapshot from %s: %w", s.store.String(), ErrTruncated)
+	} else if err != nil {
+		return nil, fmt.Errorf("couldn't read snapshot manifest from %s: %w", s.store.String(), err)
+	}
+	var m manifest
+	if err := json.Unmarshal(recs[0].Value, &m); err != nil {
+		return nil, fmt.Errorf("couldn't read snapshot manifest: %w: %v", ErrCorrupt, err)
+	}
+	if m.Version > FormatVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, m.Version)
+	}
+	prefix := s.prefix + m.Generation
+	keys, err := s.store.List(store.ListPrefix(prefix))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list keys in %s: %w", s.store.String(), err)
+	}
+
+	tables := make(map[Table]bool, len(m.Tables))
+	for _, t := range m.Tables {
+		tables[t] = true
+	}
+
+	s.header = m.Header
+	s.err = nil
+	recordChan := make(chan *Record)
+	go func(records chan<- *Record) {
+		defer close(records)
+		count := uint64(0)
+		for _, k := range keys {
+			// snapshots without a generation share the prefix with the manifest and the
+			// generation of a snapshot being written
+			if k == s.prefix+manifestKey || (len(m.Generation) == 0 && strings.HasPrefix(k, s.prefix+generationPrefix)) {
+				continue
+			}
+			parts := strings.SplitN(strings.TrimPrefix(k, prefix), "/", 3)
+			if len(parts) != 3 || !tables[Table{Database: parts[0], Table: parts[1]}] {
+				s.err = fmt.Errorf("%w: unexpected key %s", ErrCorrupt, k)
+				return
+			}
+			recs, err := s.store.Read(k)
+			if err == store.ErrNotFound {
+				// expired since the snapshot was taken
+				count++
+				continue
+			} else if err != nil {
+				s.err = fmt.Errorf("couldn't read %s from %s: %w", k, s.store.String(), err)
+				return
+			}
+			count++
+			rec := &Record{
+				Table: Table{Database: parts[0], Table: parts[1]},
+				Record: &store.Record{
+					Key:      parts[2],
+					Value:    recs[0].Value,
+					Metadata: recs[0].Metadata,
+					Expiry:   recs[0].Expiry,
+				},
+			}
+			if rec.Expiry > 0 {
+				rec.ExpiresAt = time.Now().Add(rec.Expiry)
+			}
+			records <- rec
+		}
+		if count < m.Count {
+			s.err = fmt.Errorf("%w: read %d records, manifest expects %d", ErrCorrupt, count, m.Count)
+			return
+		}
+		for _, d := range m.Deleted {
+			records <- &Record{Table: d.Table, Record: &store.Record{Key: d.Key}, Deleted: true}
+		}
+	}(recordChan)
+	return recordChan, nil
+}
+
+// Header returns the header recorded in the snapshot manifest
+func (s *StoreRestore) Header() Header {
+	return s.header
+}
+
+// Err returns the first error encountered while reading the snapshot
+func (s *StoreRestore) Err() error {
+	return s.err
+}
+
+// storeFromURL initialises the store described by a URL in the form
+// store://backend/database/table[/prefix]?nodes=host1,host2 and returns the key prefix
+func storeFromURL(raw string) (store.Store, string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, "", err
+	}
+	if u.Scheme != "store" {
+		return nil, "", fmt.Errorf("unsupported scheme %s (wanted store)", u.Scheme)
+	}
+	newStore, ok := cmd.DefaultStores[u.Host]
+	if !ok {
+		return nil, "", fmt.Errorf("store %s is not an implemented store - check your plugins", u.Host)
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return nil, "", errors.New("path must be in the form /database/table[/prefix]")
+	}
+	var prefix string
+	if len(parts) > 2 {
+		prefix = strings.Join(parts[2:], "/") + "/"
+	}
+
+	opts := []store.Option{
+		store.Database(parts[0]),
+		store.Table(parts[1]),
+	}
+	if nodes := u.Query().Get("nodes"); len(nodes) > 0 {
+		opts = append(opts, store.Nodes(strings.Split(nodes, ",")...))
+	}
+	s := newStore(opts...)
+	if err := s.Init(); err != nil {
+		return nil, "", fmt.Errorf("couldn't init %s store: %w", u.Host, err)
+	}
+	return s, prefix, nil
+}
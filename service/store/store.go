@@ -2,14 +2,16 @@ package store
 
 import (
 	"fmt"
+	"time"
 
 	"c-z.dev/go-micro"
+	"c-z.dev/go-micro/config/cmd"
 	log "c-z.dev/go-micro/logger"
 	"c-z.dev/go-micro/store"
-	pb "c-z.dev/go-micro/store/service/proto"
 	mcli "c-z.dev/micro/client/cli"
 	"c-z.dev/micro/internal/helper"
 	"c-z.dev/micro/service/store/handler"
+	pb "c-z.dev/micro/service/store/proto"
 	"github.com/urfave/cli/v2"
 )
 
@@ -40,6 +42,13 @@ func Run(ctx *cli.Context, srvOpts ...micro.Option) {
 	storeHandler := &handler.Store{
 		Default: service.Options().Store,
 		Stores:  make(map[string]bool),
+		Quota: handler.Quota{
+			Keys:      ctx.Uint64("quota_keys"),
+			Bytes:     ctx.Uint64("quota_bytes"),
+			ValueSize: ctx.Uint64("quota_value_size"),
+		},
+		// without auth every call arrives without an account
+		Open: (*cmd.DefaultCmd.Options().Auth).String() == "noop",
 	}
 
 	table := "store"
@@ -57,6 +66,25 @@ func Run(ctx *cli.Context, srvOpts ...micro.Option) {
 
 	log.Infof("Initialising the [%s] store with opts: %+v", backend, options)
 
+	// encrypt values at rest if a master key is configured
+	masterKey, err := handler.LoadMasterKey(ctx.String("encryption_key_file"), "MICRO_STORE_ENCRYPTION_KEY")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if masterKey != nil {
+		if storeHandler.Encryption, err = handler.NewEncryption(storeHandler.Default, masterKey); err != nil {
+			log.Fatal(err)
+		}
+		log.Info("Encrypting values at rest")
+
+		// index entries hold values in the clear, the indexes are kept to rebuild later
+		if n, err := storeHandler.DropIndexEntries(); err != nil {
+			log.Fatalf("Couldn't drop the index entries: %v", err)
+		} else if n > 0 {
+			log.Warnf("%d indexes aren't maintained while encryption is enabled, rebuild them once it's disabled", n)
+		}
+	}
+
 	// set the new store initialiser
 	storeHandler.New = func(database string, table string) (store.Store, error) {
 		// Record the new database and table in the internal store
@@ -76,6 +104,16 @@ func Run(ctx *cli.Context, srvOpts ...micro.Option) {
 		return storeHandler.Default, nil
 	}
 
+	// roll back batches left part way through by instances which stopped
+	go func() {
+		for {
+			if err := storeHandler.RecoverBatches(); err != nil {
+				log.Errorf("Couldn't recover batches: %v", err)
+			}
+			time.Sleep(time.Minute)
+		}
+	}()
+
 	pb.RegisterStoreHandler(service.Server(), storeHandler)
 
 	// start the service
@@ -95,6 +133,26 @@ func Commands(options ...micro.Option) []*cli.Command {
 				Usage:   "Set the micro tunnel address :8002",
 				EnvVars: []string{"MICRO_SERVER_ADDRESS"},
 			},
+			&cli.Uint64Flag{
+				Name:    "quota_keys",
+				Usage:   "Default limit on the number of keys in a database, 0 is unlimited",
+				EnvVars: []string{"MICRO_STORE_QUOTA_KEYS"},
+			},
+			&cli.Uint64Flag{
+				Name:    "quota_bytes",
+				Usage:   "Default limit on the size of the keys and values in a database, 0 is unlimited",
+				EnvVars: []string{"MICRO_STORE_QUOTA_BYTES"},
+			},
+			&cli.StringFlag{
+				Name:    "encryption_key_file",
+				Usage:   "File holding the base64 encoded 32 byte master key used to encrypt values at rest. MICRO_STORE_ENCRYPTION_KEY can hold the key instead",
+				EnvVars: []string{"MICRO_STORE_ENCRYPTION_KEY_FILE"},
+			},
+			&cli.Uint64Flag{
+				Name:    "quota_value_size",
+				Usage:   "Default limit on the size of a value, 0 is unlimited",
+				EnvVars: []string{"MICRO_STORE_QUOTA_VALUE_SIZE"},
+			},
 		},
 		Action: func(ctx *cli.Context) error {
 			if err := helper.UnexpectedSubcommand(ctx); err != nil {